@@ -0,0 +1,130 @@
+package pluginengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const defaultMaxCallDepth = 16
+
+var (
+	// ErrCallDepthExceeded is wrapped by the CallChainError returned for a hook call that would make the chain of
+	// plugin-to-plugin calls deeper than the engine's max call depth
+	ErrCallDepthExceeded = errors.New("call depth exceeded")
+	// ErrReentrantCall is wrapped by the CallChainError returned for a hook call into a plugin whose instances are all
+	// busy further up the chain, which would otherwise wait for itself forever
+	ErrReentrantCall = errors.New("re-entrant call into a busy plugin")
+)
+
+type (
+	// CallChainError
+	//
+	// The error a hook call made through the CallHook host function is rejected with when it would recurse too deep or
+	// re-enter a busy plugin. Chain is the calls leading up to the rejected one, starting with the host and ending with
+	// the rejected hook.
+	CallChainError struct {
+		Chain []string
+		Err   error
+	}
+
+	// callLink is a call into a plugin, name is the hook, listener or schedule called
+	callLink struct {
+		plugin *plugin
+		name   string
+	}
+
+	// chainKey is the context key of the calls leading up to a host function call, see withCall
+	chainKey struct{}
+)
+
+func (e *CallChainError) Error() string {
+	return fmt.Sprintf("%v: %s", e.Err, strings.Join(e.Chain, " -> "))
+}
+
+func (e *CallChainError) Unwrap() error {
+	return e.Err
+}
+
+// SetMaxCallDepth
+//
+// Sets how deep hooks may call each other with the CallHook host function, counting the call made by the host. Calls
+// going deeper fail with ErrCallDepthExceeded. 0 keeps the default of 16.
+func (e *Engine) SetMaxCallDepth(depth int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.maxCallDepth = depth
+}
+
+// withCall returns the context for a call into a plugin, which its host functions get to find the calls leading up to
+// them
+func withCall(ctx context.Context, p *plugin, name string) context.Context {
+	chain := chainOf(ctx)
+	next := make([]callLink, len(chain), len(chain)+1)
+	copy(next, chain)
+
+	return context.WithValue(ctx, chainKey{}, append(next, callLink{plugin: p, name: name}))
+}
+
+// chainOf returns the calls in ctx, outermost first
+func chainOf(ctx context.Context) []callLink {
+	chain, _ := ctx.Value(chainKey{}).([]callLink)
+	return chain
+}
+
+// callerOf returns the plugin making a call, nil for the host
+func callerOf(ctx context.Context) *plugin {
+	if chain := chainOf(ctx); len(chain) > 0 {
+		return chain[len(chain)-1].plugin
+	}
+
+	return nil
+}
+
+// checkChain
+//
+// Returns a CallChainError when calling hookId on target from ctx would go deeper than the max call depth, or needs
+// an instance of target while all the instances it may have are held by calls further up the chain.
+func (e *Engine) checkChain(ctx context.Context, target *plugin, hookId string) error {
+	chain := chainOf(ctx)
+	if len(chain) == 0 {
+		return nil
+	}
+
+	e.mu.RLock()
+	depth := e.maxCallDepth
+	e.mu.RUnlock()
+
+	if depth <= 0 {
+		depth = defaultMaxCallDepth
+	}
+
+	var err error
+	if len(chain) >= depth {
+		err = fmt.Errorf("%w, the max is %d", ErrCallDepthExceeded, depth)
+	} else {
+		busy := 0
+		for _, link := range chain {
+			if link.plugin == target {
+				busy++
+			}
+		}
+		if instances := e.poolConfig(target).Max; busy >= instances {
+			err = fmt.Errorf("%w, all %d instances of %s@%s are in the chain", ErrReentrantCall, instances, target.Details.Id, target.Details.Version)
+		}
+	}
+
+	if nil == err {
+		return nil
+	}
+
+	names := make([]string, 0, len(chain)+2)
+	names = append(names, "host")
+	for _, link := range chain {
+		names = append(names, link.name)
+	}
+
+	return &CallChainError{Chain: append(names, hookId), Err: err}
+}
@@ -0,0 +1,49 @@
+package pluginengine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckChain(t *testing.T) {
+	e := newTestEngine(t)
+	e.SetMaxCallDepth(3)
+	e.SetPoolConfig("test.pooled", PoolConfig{Max: 2})
+
+	stateful := &plugin{Details: Plugin{Id: "test.stateful", Version: "1.0.0"}}
+	pooled := &plugin{Details: Plugin{Id: "test.pooled", Version: "1.0.0", Stateless: true}}
+	other := &plugin{Details: Plugin{Id: "test.other", Version: "1.0.0", Stateless: true}}
+
+	if err := e.checkChain(e.context, stateful, "test.stateful.a"); nil != err {
+		t.Errorf("Expected a call from the host to be allowed, got %v", err)
+	}
+
+	ctx := withCall(e.context, stateful, "test.stateful.a")
+	if callerOf(ctx) != stateful {
+		t.Error("Expected the plugin called to be the caller of its host functions")
+	}
+
+	err := e.checkChain(ctx, stateful, "test.stateful.b")
+	chainErr := &CallChainError{}
+	if !errors.Is(err, ErrReentrantCall) || !errors.As(err, &chainErr) {
+		t.Fatalf("Expected a re-entrant call into the stateful plugin to be rejected, got %v", err)
+	}
+	if expected := "host -> test.stateful.a -> test.stateful.b"; !strings.HasSuffix(chainErr.Error(), expected) {
+		t.Errorf("Expected the error to end with the chain %q, got %q", expected, chainErr.Error())
+	}
+
+	// a pooled plugin may be re-entered while it has a free instance
+	ctx = withCall(ctx, pooled, "test.pooled.a")
+	if err := e.checkChain(ctx, pooled, "test.pooled.a"); nil != err {
+		t.Errorf("Expected a re-entrant call into a pooled plugin to be allowed, got %v", err)
+	}
+
+	ctx = withCall(ctx, pooled, "test.pooled.a")
+	if err := e.checkChain(ctx, other, "test.other.a"); !errors.Is(err, ErrCallDepthExceeded) {
+		t.Errorf("Expected a call deeper than the max to be rejected, got %v", err)
+	}
+	if len(chainOf(ctx)) != 3 {
+		t.Errorf("Expected three calls in the chain, got %d", len(chainOf(ctx)))
+	}
+}
@@ -0,0 +1,46 @@
+package pluginengine
+
+import "time"
+
+// Clock
+//
+// The source of time for the engine's timers, such as the scheduler. Tests set a fake clock with Engine.SetClock to
+// control when schedules fire.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives the time once d has passed, as time.After.
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the Clock of the real time
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// SetClock
+//
+// Sets the clock the engine's timers use, the system clock by default. It should be set before the scheduler is
+// started, timers already waiting keep the clock they were started with.
+func (e *Engine) SetClock(c Clock) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if nil == c {
+		c = systemClock{}
+	}
+	e.clock = c
+}
+
+// getClock returns the engine's clock
+func (e *Engine) getClock() Clock {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.clock
+}
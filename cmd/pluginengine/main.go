@@ -0,0 +1,315 @@
+// Command pluginengine validates, packs, inspects and runs plugins with the plugin engine.
+//
+// Usage:
+//
+//	pluginengine validate <path>
+//	pluginengine pack -manifest plugin.yaml -module plugin.wasm -o plugin.zip [resource ...]
+//	pluginengine inspect <archive>
+//	pluginengine graph [-format dot|json] <path>
+//	pluginengine call <path> <hook id> < payload
+//	pluginengine events [-data payload] <path> <event>
+//
+// A path is anything the engine can load: a directory of plugins, an archive, an unpacked plugin directory or a bare
+// .wasm module.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	pluginengine "github.com/spirefyio/pluginengine-go"
+)
+
+// errUsage is returned for bad command lines, the usage has already been printed
+var errUsage = errors.New("usage")
+
+// where results are written and payloads read from. main sends the engine's logs to standard error, keeping the output
+// of a command clean enough to pipe.
+var (
+	out io.Writer = os.Stdout
+	in  io.Reader = os.Stdin
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"validate": {"validate <path>", validate},
+	"pack":     {"pack -manifest plugin.yaml -module plugin.wasm -o plugin.zip [resource ...]", pack},
+	"inspect":  {"inspect <archive>", inspect},
+	"graph":    {"graph [-format dot|json] <path>", graph},
+	"call":     {"call <path> <hook id> < payload", call},
+	"events":   {"events [-data payload] <path> <event>", events},
+}
+
+var order = []string{"validate", "pack", "inspect", "graph", "call", "events"}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", os.Args[1])
+		printUsage()
+		os.Exit(2)
+	}
+
+	pluginengine.SetLogOutput(os.Stderr)
+
+	if err := cmd.run(os.Args[2:]); nil != err {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, "usage: pluginengine", cmd.usage)
+			os.Exit(2)
+		}
+
+		fmt.Fprintln(os.Stderr, "pluginengine:", err)
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	for _, name := range order {
+		fmt.Fprintln(os.Stderr, "  pluginengine", commands[name].usage)
+	}
+}
+
+// parse
+//
+// Parses the flags of a command and checks it was given exactly n arguments.
+func parse(fs *flag.FlagSet, args []string, n int) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); nil != err || fs.NArg() != n {
+		return errUsage
+	}
+
+	return nil
+}
+
+// validate
+//
+// Checks every plugin found at a path, printing the problems with each. It fails if any plugin has a problem.
+func validate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	if err := parse(fs, args, 1); nil != err {
+		return err
+	}
+
+	results, err := pluginengine.Validate(fs.Arg(0))
+	if nil != err {
+		return err
+	}
+
+	if len(results) == 0 {
+		return fmt.Errorf("no plugins found at %s", fs.Arg(0))
+	}
+
+	invalid := 0
+	for _, result := range results {
+		name := result.Source
+		if result.Id != "" {
+			name = result.Id + "@" + result.Version + " (" + result.Source + ")"
+		}
+
+		if result.Valid() {
+			fmt.Fprintln(out, "ok", name)
+			continue
+		}
+
+		invalid++
+		fmt.Fprintln(out, "FAIL", name)
+		for _, problem := range result.Problems {
+			fmt.Fprintln(out, "    "+problem)
+		}
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d plugins failed validation", invalid, len(results))
+	}
+
+	return nil
+}
+
+// pack
+//
+// Packs a manifest, a module and any resources into an archive.
+func pack(args []string) error {
+	fs := flag.NewFlagSet("pack", flag.ContinueOnError)
+	manifest := fs.String("manifest", "plugin.yaml", "the plugin manifest")
+	module := fs.String("module", "", "the plugin's .wasm module")
+	output := fs.String("o", "", "the archive to write, .zip, .tar.gz or .tgz")
+
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); nil != err || *module == "" || *output == "" {
+		return errUsage
+	}
+
+	err := pluginengine.PackFile(*output, pluginengine.PackOptions{
+		Manifest:  *manifest,
+		Module:    *module,
+		Resources: fs.Args(),
+	})
+	if nil != err {
+		return err
+	}
+
+	fmt.Fprintln(out, *output)
+	return nil
+}
+
+// inspect
+//
+// Prints the manifests, files and checksums of an archive as JSON.
+func inspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	if err := parse(fs, args, 1); nil != err {
+		return err
+	}
+
+	info, err := pluginengine.InspectFile(fs.Arg(0))
+	if nil != err {
+		return err
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(info)
+}
+
+// graph
+//
+// Loads a path and prints the anchor and hook topology of its plugins.
+func graph(args []string) error {
+	fs := flag.NewFlagSet("graph", flag.ContinueOnError)
+	format := fs.String("format", "dot", "dot or json")
+	if err := parse(fs, args, 1); nil != err {
+		return err
+	}
+
+	// checked before anything is loaded
+	if *format != "dot" && *format != "json" {
+		return errUsage
+	}
+
+	return withEngine(fs.Arg(0), func(engine *pluginengine.Engine) error {
+		g := engine.Graph()
+		if *format == "json" {
+			return g.EncodeJSON(out)
+		}
+		return g.EncodeDOT(out)
+	})
+}
+
+// call
+//
+// Loads a path, starts the engine and calls a hook with standard input as its payload, writing the response to
+// standard output.
+func call(args []string) error {
+	fs := flag.NewFlagSet("call", flag.ContinueOnError)
+	if err := parse(fs, args, 2); nil != err {
+		return err
+	}
+
+	payload, err := io.ReadAll(in)
+	if nil != err {
+		return err
+	}
+
+	return withEngine(fs.Arg(0), func(engine *pluginengine.Engine) error {
+		hookId := fs.Arg(1)
+		if nil == engine.GetHookForId(hookId) {
+			return fmt.Errorf("no plugin at %s provides hook %s", fs.Arg(0), hookId)
+		}
+
+		if err := engine.Start(); nil != err {
+			return err
+		}
+
+		response, err := engine.CallHookFunc(hookId, payload)
+		if nil != err {
+			return err
+		}
+
+		_, err = out.Write(response)
+		return err
+	})
+}
+
+// events
+//
+// Loads a path, starts the engine and publishes an event, printing the response of each plugin listener.
+func events(args []string) error {
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	data := fs.String("data", "{}", "the event payload, - to read it from standard input")
+	if err := parse(fs, args, 2); nil != err {
+		return err
+	}
+
+	payload := []byte(*data)
+	if *data == "-" {
+		var err error
+		if payload, err = io.ReadAll(in); nil != err {
+			return err
+		}
+	}
+
+	return withEngine(fs.Arg(0), func(engine *pluginengine.Engine) error {
+		if err := engine.Start(); nil != err {
+			return err
+		}
+
+		responses := engine.Publish(fs.Arg(1), payload)
+		if len(responses) == 0 {
+			fmt.Fprintln(out, "no plugin listens for", fs.Arg(1))
+			return nil
+		}
+
+		failed := 0
+		for _, response := range responses {
+			if nil != response.Err {
+				failed++
+				fmt.Fprintf(out, "%s: error: %v\n", response.Listener, response.Err)
+				continue
+			}
+
+			fmt.Fprintf(out, "%s: %s\n", response.Listener, response.Response)
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d listeners failed", failed, len(responses))
+		}
+
+		return nil
+	})
+}
+
+// withEngine
+//
+// Loads path into a new engine, extracting archives to a temporary directory that is removed afterwards, and runs fn.
+func withEngine(path string, fn func(engine *pluginengine.Engine) error) error {
+	tmp, err := os.MkdirTemp("", "pluginengine-")
+	if nil != err {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	engine, err := pluginengine.NewPluginEngine(nil, tmp)
+	if nil != err {
+		return err
+	}
+
+	if err := engine.Load(path); nil != err {
+		return err
+	}
+
+	return fn(engine)
+}
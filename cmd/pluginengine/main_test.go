@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a minimal wasm module exporting a single function named run
+const exportingModule = "\x00asm\x01\x00\x00\x00" +
+	"\x01\x04\x01\x60\x00\x00" +
+	"\x03\x02\x01\x00" +
+	"\x07\x07\x01\x03run\x00\x00" +
+	"\x0a\x04\x01\x02\x00\x0b"
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "good", "plugin.yaml"), "id: test.good\nversion: 1.0.0\n"+
+		"hooks:\n  - id: test.good.hook\n    anchor: test.menu.items\n    func: run\n")
+	mustWrite(t, filepath.Join(dir, "good", "good.wasm"), exportingModule)
+	mustWrite(t, filepath.Join(dir, "broken", "plugin.yaml"), "id: test.broken\n")
+	mustWrite(t, filepath.Join(dir, "broken", "broken.wasm"), exportingModule)
+	mustWrite(t, filepath.Join(dir, "listening", "plugin.yaml"), "id: test.listening\nversion: 1.0.0\n"+
+		"anchors:\n  - id: test.listening.menu\n"+
+		"hooks:\n  - id: test.listening.hook\n    anchor: test.listening.menu\n    func: run\n"+
+		"listeners:\n"+
+		"  - id: test.listening.saved\n    event: test.saved\n    func: run\n"+
+		"  - id: test.listening.failing\n    event: test.failing\n    func: missing\n")
+	mustWrite(t, filepath.Join(dir, "listening", "listening.wasm"), exportingModule)
+
+	good, broken, listening := filepath.Join(dir, "good"), filepath.Join(dir, "broken"), filepath.Join(dir, "listening")
+	archive := filepath.Join(dir, "good.zip")
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name     string
+		run      func(args []string) error
+		args     []string
+		stdin    string
+		err      string
+		expected string
+	}{
+		{"validate", validate, []string{good}, "", "", "ok test.good@1.0.0"},
+		{"validate invalid", validate, []string{broken}, "", "1 of 1 plugins failed validation", "FAIL"},
+		{"validate without a path", validate, nil, "", errUsage.Error(), ""},
+		{"pack", pack, []string{"-manifest", filepath.Join(good, "plugin.yaml"), "-module", filepath.Join(good, "good.wasm"), "-o", archive}, "", "", archive},
+		{"pack without a module", pack, []string{"-o", archive}, "", errUsage.Error(), ""},
+		{"inspect", inspect, []string{archive}, "", "", `"test.good"`},
+		{"inspect a missing archive", inspect, []string{missing}, "", "missing", ""},
+		{"graph", graph, []string{"-format", "json", good}, "", "", "test.good.hook"},
+		// the format is refused before the missing path is loaded
+		{"graph with a bad format", graph, []string{"-format", "bogus", missing}, "", errUsage.Error(), ""},
+		{"call", call, []string{listening, "test.listening.hook"}, `{"payload": true}`, "", ""},
+		{"call a missing hook", call, []string{listening, "test.missing.hook"}, "", "provides hook test.missing.hook", ""},
+		{"call without a hook", call, []string{listening}, "", errUsage.Error(), ""},
+		{"events", events, []string{listening, "test.saved"}, "", "", "test.listening@1.0.0/test.listening.saved: "},
+		{"events from stdin", events, []string{"-data", "-", listening, "test.saved"}, `{"saved": true}`, "", "test.listening.saved: "},
+		{"events nobody listens for", events, []string{listening, "test.other"}, "", "", "no plugin listens for test.other"},
+		{"events with a failing listener", events, []string{listening, "test.failing"}, "", "1 of 1 listeners failed", "test.listening.failing: error: "},
+	}
+
+	defer func() { out, in = os.Stdout, os.Stdin }()
+
+	for _, test := range tests {
+		buf := &bytes.Buffer{}
+		stdin := strings.NewReader(test.stdin)
+		out, in = buf, stdin
+
+		err := test.run(test.args)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: expected an error containing %q, got %v", test.name, test.err, err)
+		}
+
+		if !strings.Contains(buf.String(), test.expected) {
+			t.Errorf("%s: expected the output to contain %q, got:\n%s", test.name, test.expected, buf.String())
+		}
+		if stdin.Len() != 0 {
+			t.Errorf("%s: expected the payload on standard input to be read", test.name)
+		}
+	}
+}
+
+func mustWrite(t *testing.T, path, contents string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,175 @@
+package pluginengine
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// the optional export called on an instantiated plugin when its config changes
+const configChangedFunc = "configChanged"
+
+// ConfigFile
+//
+// The YAML file hosts can keep plugin settings in, see LoadConfigFile. Values are keyed by plugin id and then setting
+// name, and can be written as any YAML scalar:
+//
+//	plugins:
+//	  mycompany.plugins.MyPlugin:
+//	    endpoint: https://example.com
+//	    retries: 3
+type ConfigFile struct {
+	Plugins map[string]map[string]interface{} `json:"plugins" yaml:"plugins"`
+}
+
+// SetPluginConfig
+//
+// This method replaces the host supplied setting values of every version of the plugin with the given id. Values are
+// checked against the type of the setting in each loaded version that declares it and nothing is changed when one does
+// not parse. Values for settings a version does not declare are not passed to it, so config can be set before the
+// plugin is loaded. Idle instances get their new config straight away, instances running a call before their next one,
+// and if they export configChanged it is called with the new config as a JSON object.
+func (e *Engine) SetPluginConfig(id string, values map[string]string) error {
+	overrides := make(map[string]string, len(values))
+	for k, v := range values {
+		overrides[k] = v
+	}
+
+	e.mu.Lock()
+	plugs := make([]*plugin, 0)
+	for _, p := range e.plugins[id] {
+		for _, setting := range p.Details.Settings {
+			if v, ok := overrides[setting.Name]; ok {
+				if err := checkSettingValue(setting, v); nil != err {
+					e.mu.Unlock()
+					return fmt.Errorf("%s@%s setting %s: %w", id, p.Details.Version, setting.Name, err)
+				}
+			}
+		}
+		plugs = append(plugs, p)
+	}
+	e.config[id] = overrides
+	e.mu.Unlock()
+
+	// instances are configured after releasing the engine lock, configChanged may call back into the engine
+	for _, p := range plugs {
+		e.applyConfig(p)
+	}
+
+	return nil
+}
+
+// LoadConfigFile
+//
+// Reads a ConfigFile and sets the config of each plugin in it, see SetPluginConfig.
+func (e *Engine) LoadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if nil != err {
+		return err
+	}
+
+	cf := ConfigFile{}
+	if err := yaml.Unmarshal(data, &cf); nil != err {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	ids := make([]string, 0, len(cf.Plugins))
+	for id := range cf.Plugins {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		values := make(map[string]string)
+		for k, v := range cf.Plugins[id] {
+			values[k] = fmt.Sprint(v)
+		}
+
+		if err := e.SetPluginConfig(id, values); nil != err {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// PluginConfig
+//
+// Returns the config a plugin version gets: the defaults of its settings overridden by host supplied values.
+func (e *Engine) PluginConfig(id, version string) (map[string]string, error) {
+	e.mu.RLock()
+	p := e.plugins[id][version]
+	e.mu.RUnlock()
+
+	if nil == p {
+		return nil, fmt.Errorf("plugin %s@%s is not loaded", id, version)
+	}
+
+	return e.pluginConfig(p), nil
+}
+
+// pluginConfig
+//
+// Returns the effective config of a plugin. Host supplied values that no longer parse, because the plugin was reloaded
+// with a different setting type, are logged and the default is used.
+func (e *Engine) pluginConfig(p *plugin) map[string]string {
+	e.mu.RLock()
+	overrides := e.config[p.Details.Id]
+	e.mu.RUnlock()
+
+	config := make(map[string]string)
+	for _, setting := range p.Details.Settings {
+		if nil != setting.Default {
+			config[setting.Name] = fmt.Sprint(setting.Default)
+		}
+
+		if v, ok := overrides[setting.Name]; ok {
+			if err := checkSettingValue(setting, v); nil != err {
+				logln("Ignoring config for ", p.Details.Id, "@", p.Details.Version, setting.Name, err)
+				continue
+			}
+			config[setting.Name] = v
+		}
+	}
+
+	return config
+}
+
+// applyConfig
+//
+// Gives the plugin's idle instances their current config, calling their configChanged export if they have one. Instances
+// running a call get it before their next one.
+func (e *Engine) applyConfig(p *plugin) {
+	p.mu.Lock()
+	pool := p.pool
+	p.mu.Unlock()
+
+	if nil != pool {
+		pool.reconfigure()
+	}
+}
+
+// checkSettingValue
+//
+// Returns an error when value does not parse as the type of setting.
+func checkSettingValue(setting Setting, value string) error {
+	var err error
+
+	switch setting.Type {
+	case SettingInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case SettingFloat:
+		_, err = strconv.ParseFloat(value, 64)
+	case SettingBool:
+		_, err = strconv.ParseBool(value)
+	}
+
+	if nil != err {
+		return fmt.Errorf("%q is not a valid %s", value, setting.Type)
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package pluginengine
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPluginConfig(t *testing.T) {
+	e := newTestEngine(t)
+
+	details, err := ParseManifest("plugin.yaml", []byte(`
+id: test.configured
+version: 1.0.0
+settings:
+  - name: endpoint
+    default: https://example.com
+    description: where to send reports
+  - name: retries
+    type: int
+    default: 3
+  - name: verbose
+    type: bool
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.addPlugin(&plugin{}, details)
+
+	config, err := e.PluginConfig("test.configured", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config) != 2 || config["endpoint"] != "https://example.com" || config["retries"] != "3" {
+		t.Errorf("Expected the defaults, got %v", config)
+	}
+
+	if err := e.SetPluginConfig("test.configured", map[string]string{"retries": "many"}); err == nil {
+		t.Error("Expected an error for a value that is not an int")
+	}
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	mustWrite(t, file, "plugins:\n  test.configured:\n    retries: 5\n    verbose: true\n    undeclared: x\n")
+	if err := e.LoadConfigFile(file); err != nil {
+		t.Fatal(err)
+	}
+
+	config, _ = e.PluginConfig("test.configured", "1.0.0")
+	expected := map[string]string{"endpoint": "https://example.com", "retries": "5", "verbose": "true"}
+	if len(config) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, config)
+	}
+	for k, v := range expected {
+		if config[k] != v {
+			t.Errorf("Expected %s to be %s, got %s", k, v, config[k])
+		}
+	}
+
+	if _, err := ParseManifest("plugin.yaml", []byte("id: test.bad\nversion: 1.0.0\nsettings:\n  - name: retries\n    type: int\n    default: lots\n")); err == nil {
+		t.Error("Expected an error for a default that does not match its type")
+	}
+}
@@ -0,0 +1,59 @@
+package pluginengine
+
+import "sort"
+
+// PluginDiagnostic
+//
+// A snapshot of the state of a single loaded plugin version, for hosts to show or log when investigating problems.
+type PluginDiagnostic struct {
+	Id           string             `json:"id" yaml:"id"`
+	Version      string             `json:"version" yaml:"version"`
+	Source       string             `json:"source,omitempty" yaml:"source,omitempty"`
+	SourceHash   string             `json:"sourceHash,omitempty" yaml:"sourceHash,omitempty"`
+	ModuleHash   string             `json:"moduleHash,omitempty" yaml:"moduleHash,omitempty"`
+	Resolved     bool               `json:"resolved" yaml:"resolved"`
+	Instantiated bool               `json:"instantiated" yaml:"instantiated"`
+	Instances    int                `json:"instances" yaml:"instances"`
+	Verification VerificationStatus `json:"verification" yaml:"verification"`
+	Publisher    string             `json:"publisher,omitempty" yaml:"publisher,omitempty"`
+}
+
+// Diagnostics
+//
+// This method returns a diagnostic snapshot of every loaded plugin version, sorted by id and version.
+func (e *Engine) Diagnostics() []PluginDiagnostic {
+	e.mu.RLock()
+	plugs := make([]*plugin, 0)
+	diags := make([]PluginDiagnostic, 0)
+	for _, pv := range e.plugins {
+		for _, p := range pv {
+			plugs = append(plugs, p)
+			diags = append(diags, PluginDiagnostic{
+				Id:           p.Details.Id,
+				Version:      p.Details.Version,
+				Source:       p.Source,
+				SourceHash:   p.SourceHash,
+				ModuleHash:   p.ModuleHash,
+				Resolved:     p.Resolved,
+				Verification: p.Verification,
+				Publisher:    p.Publisher,
+			})
+		}
+	}
+	e.mu.RUnlock()
+
+	// the plugin and pool locks are taken after releasing the engine lock, as elsewhere
+	for i, p := range plugs {
+		diags[i].Instances = p.instances()
+		diags[i].Instantiated = diags[i].Instances > 0
+	}
+
+	sort.Slice(diags, func(i, j int) bool {
+		if diags[i].Id != diags[j].Id {
+			return diags[i].Id < diags[j].Id
+		}
+		return diags[i].Version < diags[j].Version
+	})
+
+	return diags
+}
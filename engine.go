@@ -5,16 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 
+	observe "github.com/dylibso/observe-sdk/go"
 	extism "github.com/extism/go-sdk"
 	pdk "github.com/spirefyio/plugin-go-pdk"
 	"github.com/tetratelabs/wazero"
-	"gopkg.in/yaml.v3"
 )
 
 type (
@@ -25,23 +28,51 @@ type (
 		// actual Go function provided by the host to be called
 		Func   func([]*hook) error
 		Hooks  []*hook `json:"hooks" yaml:"hooks"`
-		Plugin plugin  `json:"plugin" yaml:"plugin"`
+		Plugin *plugin `json:"plugin" yaml:"plugin"` // nil for anchors registered by the host
 	}
 
 	hook struct {
 		pdk.Hook `json:"hook" yaml:"hook"`
-		Plugin   plugin `json:"plugin" yaml:"plugin"`
-		Resolved bool   `json:"resolved" yaml:"resolved"`
+		Plugin   *plugin `json:"plugin" yaml:"plugin"`
+		Resolved bool    `json:"resolved" yaml:"resolved"`
+	}
+
+	// linkedModule
+	//
+	// A module of a plugin other than its main module. Data is set instead of Path for plugins not loaded from disk.
+	linkedModule struct {
+		Name string `json:"name" yaml:"name"`
+		Path string `json:"path" yaml:"path"`
+		Data []byte `json:"-" yaml:"-"`
 	}
 
 	plugin struct {
-		Plugin       *extism.Plugin `json:"plugin" yaml:"plugin"`
+		Details      Plugin         `json:"details" yaml:"details"`
 		PathToModule string         `json:"pathToModule" yaml:"pathToModule"`
+		ModuleData   []byte         `json:"-" yaml:"-"`                   // module bytes as read when loaded, used instead of PathToModule
+		Linked       []linkedModule `json:"linked" yaml:"linked"`         // the plugin's other modules, linked with the main one
+		Source       string         `json:"source" yaml:"source"`         // the archive, directory or module this plugin was loaded from
+		SourceHash   string         `json:"sourceHash" yaml:"sourceHash"` // sha256 of the archive contents
+		ModuleHash   string         `json:"moduleHash" yaml:"moduleHash"` // sha256 of the wasm module
+		ExtractDir   string         `json:"extractDir" yaml:"extractDir"` // where the archive was extracted to, if it was
 		Resolved     bool           `json:"resolved" yaml:"resolved"`
 		LoadOnStart  bool           `json:"loadOnStart" yaml:"loadOnStart"`
+
+		Verification VerificationStatus `json:"verification" yaml:"verification"`
+		Publisher    string             `json:"publisher" yaml:"publisher"` // the signature's publisher, if signed
+
+		// the plugin's extism instances, created on first use. mu guards the pointer, the pool guards itself.
+		pool *instancePool
+		mu   sync.Mutex
+		// hook calls currently running against this plugin's instance. Unload and Reload wait on this before closing
+		// the instance so in flight calls are never dropped.
+		inflight sync.WaitGroup
 	}
 
 	Engine struct {
+		// guards plugins, anchors, hooks, unresolved and callableHooks. Hook calls only hold it while looking up the
+		// plugin to call, never for the duration of the call itself, as a hook may call back into the engine.
+		mu         sync.RWMutex
 		context    context.Context
 		logLevel   extism.LogLevel
 		plugins    map[string]map[string]*plugin
@@ -49,15 +80,81 @@ type (
 		hooks      map[string]*hook
 		unresolved []*hook
 		hostFuncs  []extism.HostFunction
-		pluginPath string // path where .tar.gz and .zip plugins will be extracted to (overwrite every time)
+		pluginPath string // path where .tar.gz and .zip plugins will be extracted to, one directory per archive hash
+		events     *EventBus
+
+		// the plugin each resolved hook id calls into, kept in step with hooks. When several loaded versions provide a
+		// hook id the most recently resolved one is called.
+		callableHooks map[string]*plugin
+
+		// serializes extraction and GC so GC never removes a directory that is being extracted. extracting counts the
+		// extractions of each directory whose plugins are not registered or rejected yet, GC leaves those alone too.
+		extractMu  sync.Mutex
+		extracting map[string]int
+
+		trustStore   *TrustStore
+		verifyPolicy Policy
+		lockfile     *Lockfile
+		lockPolicy   Policy
+
+		// host supplied setting values keyed by plugin id, then setting name
+		config  map[string]map[string]string
+		secrets SecretProvider
+
+		// plugin key/value storage and the quotas keyed by plugin id, "" being the default. storeMu serializes writes so
+		// quota checks and the writes they allow are atomic.
+		store       Store
+		storeQuotas map[string]StoreQuota
+		storeMu     sync.Mutex
+
+		// approves the hosts plugins declare and the transport of their HTTPRequest calls
+		hostPolicy HostPolicy
+		transport  http.RoundTripper
+
+		// body size limits of HTTPHandler
+		routeLimits RouteLimits
+
+		clock     Clock
+		scheduler *scheduler
+
+		// instance pool sizes keyed by plugin id, "" being the default
+		poolConfigs map[string]PoolConfig
+
+		// how many modules Load compiles at once, 0 to compile them when first instantiated
+		precompileWorkers int
+
+		// circuit breakers keyed by plugin version and, with per-hook breakers, hook. healthMu guards them along with the
+		// restart policy and state listener
+		healthMu      sync.Mutex
+		restartPolicy RestartPolicy
+		breakers      map[string]*breaker
+		stateListener func(PluginStateEvent)
+
+		// how deep the chain of CallHook calls may go, 0 for the default
+		maxCallDepth int
+
+		// tracing, off while tracer is nil, and the observe-sdk adapter instances are created with
+		traceMu        sync.RWMutex
+		tracer         Tracer
+		observeAdapter *observe.AdapterBase
+		observeOptions *observe.Options
+
+		// where metrics are recorded, off while nil
+		metricsMu sync.RWMutex
+		metrics   Metrics
+
+		// hook call limits and their token buckets keyed by scope and id, see acquireLimits
+		limitMu sync.Mutex
+		limits  map[string]Limit
+		buckets map[string]*bucket
+
+		// directories passed to Load and the archives loaded from them, used by the watcher to detect changes
+		loadPaths []string
+		archives  map[string]*archiveRecord
+		watcher   *watcher
 	}
 )
 
-// This variable will hold pointers to plugins keyed on an hook ID. This can be used
-// by CallHook to quickly access the hook to make a call to and check if its instantiated
-// or not and resolved.
-var callableHooks = make(map[string]*plugin)
-
 func findFilesWithExtensions(root string, extensions []string) ([]string, error) {
 	var matchingFiles []string
 
@@ -87,10 +184,15 @@ func findFilesWithExtensions(root string, extensions []string) ([]string, error)
 //
 // This method will add the plugin passed in to the engine's plugins property. It will ensure that if a plugin
 // at the name and version provided does not yet exist, the map of internalPlugin objects is created.
-// It's important to note that if a plugin already exists at the name and version intersection, it is replaced. This
-// should allow for reloading (and eventual GC of old plugins as they are replaced) if need be.
+// It's important to note that if a plugin already exists at the name and version intersection, it is replaced. The
+// replaced plugin's hooks and anchors are detached first so nothing keeps pointing at the old copy. The caller must
+// hold the engine lock and is responsible for stopping the replaced plugin's instance.
 func (e *Engine) addPlugin(p *plugin, plug Plugin) {
 	if nil != e.plugins && nil != p {
+		if old := e.plugins[plug.Id][plug.Version]; nil != old && old != p {
+			e.removePlugin(old)
+		}
+
 		pv := e.plugins[plug.Id]
 
 		if nil == pv {
@@ -99,6 +201,7 @@ func (e *Engine) addPlugin(p *plugin, plug Plugin) {
 		}
 
 		pv[plug.Version] = p
+		p.Details = plug
 		p.LoadOnStart = plug.LoadOnStart
 
 		// now add all of this plugins hooks to the unresolved list... a call to engine.resolve() will then try to
@@ -107,18 +210,10 @@ func (e *Engine) addPlugin(p *plugin, plug Plugin) {
 			for _, ex := range plug.Hooks {
 				hk := &hook{
 					Hook:     ex,
-					Plugin:   *p,
+					Plugin:   p,
 					Resolved: false,
 				}
 
-				// for each hook, add a reference pointer to THIS plugin so that when calling any extension
-				// that is part of the same plugin owner, the pointer to the extism.Plugin instance can be used.
-				if callableHooks[ex.Id] != nil {
-					fmt.Println("It appears an extension is already added to the callableExtensions at id: ", ex.Id)
-				} else {
-					callableHooks[ex.Id] = p
-				}
-
 				e.unresolved = append(e.unresolved, hk)
 			}
 		}
@@ -131,7 +226,7 @@ func (e *Engine) addPlugin(p *plugin, plug Plugin) {
 					Anchor: ep,
 					Func:   nil,
 					Hooks:  nil,
-					Plugin: *p,
+					Plugin: p,
 				}
 
 				eps := e.anchors[ep.Id]
@@ -189,6 +284,9 @@ func isValidNumber(str string) bool {
 //
 // This function will look for a single extension based on it's id (and version?) and return it if found, nil otherwise
 func (e *Engine) GetHookForId(eid string) *pdk.Hook {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
 	hk := e.hooks[eid]
 
 	if nil != hk && hk.Resolved {
@@ -204,6 +302,9 @@ func (e *Engine) GetHookForId(eid string) *pdk.Hook {
 // nil, look for a matching version (TODO: version range may be added in future). If version is nil, the first
 // anchr's hooks are returned.
 func (e *Engine) GetHooksForAnchor(anchorId string) ([]*pdk.Hook, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
 	anchrs := e.anchors[anchorId]
 
 	if nil != anchrs && len(anchrs) > 0 {
@@ -222,133 +323,405 @@ func (e *Engine) GetHooksForAnchor(anchorId string) ([]*pdk.Hook, error) {
 }
 
 // getPluginName
-// the source will be a .zip or .tar.gz so we'll remove those first, then look for the first / and get the name from
-// everything past the / to the end
+// the source will be a .zip, .tar.gz or .tgz so we'll remove the suffix first, then take the file name that is left.
+// It is only used to make staging directories recognisable, extractions are named by the archive hash.
 func getPluginName(source string) string {
-	var name string
-	if strings.HasSuffix(source, ".tar.gz") {
-		name = source[:len(source)-7]
-	} else if strings.HasSuffix(source, ".zip") {
-		name = source[:len(source)-4]
-	} else {
+	suffix := archiveSuffix(source)
+	if suffix == "" {
 		// TODO: Log that the source file is NOT a .zip or .tar.gz
 		return ""
 	}
 
-	// make sure we got a valid string still after removal of suffix
-	if len(name) > 0 {
-		indx := strings.LastIndex(name, string(filepath.Separator))
-		if indx >= 0 {
-			name = name[indx+1:]
-			return name
-		}
-	}
-
-	return ""
+	return filepath.Base(source[:len(source)-len(suffix)])
 }
 
 // loadPluginManifests
 //
-// This receiver function will be called to find all .tar.gz and .zip plugins at the provided path. It will determine if
-// it's a .tar.gz or .zip and use the appropriate helper func to untar/unzip to the engine's pluginPath output location
-// on the local file system. This extraction is necessary so that the .yaml (or .json (tbd)) can be parsed to pull the
-// plugin details, as well as record the location of the .wasm plugin for later use when the plugin is instantiated.
-func (e *Engine) loadPluginManifests(path, ext string) error {
-	// Hardcode WASM extension as it's the only plugin module format supported.
-	files, err := findFilesWithExtensions(path, []string{".gz", ".zip"})
+// This receiver function will be called to find all plugin sources at the provided path, see findPluginSources for the
+// kinds of sources and the precedence between them. Each source is loaded and the plugins it contains are registered
+// with the engine. A source that fails to load is logged and skipped so the remaining plugins can still be loaded.
+func (e *Engine) loadPluginManifests(ctx context.Context, path string) error {
+	sources, err := findPluginSources(path)
 
 	if err != nil {
 		// Handle error
-		fmt.Println("Some sort of error looking for .tar.gz or .zip plugin archive files: ", err)
+		logln("Some sort of error looking for plugins: ", err)
 		return err
 	}
 
-	// we need to extract the plugin archives to the plugin engine provided output path
-	for _, file := range files {
-		f := getPluginName(file)
+	// id@version of the plugins loaded so far, sources are in precedence order so the first one found wins
+	loaded := make(map[string]string)
+	all := make([]*plugin, 0)
 
-		outputPath := filepath.Join(e.pluginPath, f)
+	for _, src := range sources {
+		plugs, err := e.loadSource(src)
+		if nil != err {
+			// TODO: Log error.. but do NOT return because other plugins can still be extracted/loaded and work fine
+			logln("Error loading plugin: ", src.Path, err)
+			continue
+		}
 
-		if strings.HasSuffix(file, ".tar.gz") {
-			err = Untar(file, outputPath)
-			if err != nil {
-				// TODO: Log error.. but do NOT return because other plugins can still be extracted/loaded and work fine
-				fmt.Println("Error unzipping .tar.gz plugin: ", file, err)
+		keep := make([]*plugin, 0, len(plugs))
+		for _, plug := range plugs {
+			key := plug.Details.Id + "@" + plug.Details.Version
+			if first, ok := loaded[key]; ok {
+				logln("Skipping plugin ", key, " from ", src.Path, ", already loaded from ", first)
+				continue
 			}
-		} else if strings.HasSuffix(file, ".zip") {
-			err = Unzip(file, outputPath)
-			if err != nil {
-				// TODO: Log error.. but do NOT return because other plugins can still be extracted/loaded and work fine
-				fmt.Println("Error unzipping zip plugin: ", file, err)
+
+			loaded[key] = src.Path
+			keep = append(keep, plug)
+		}
+		all = append(all, keep...)
+
+		e.mu.Lock()
+		for _, plug := range keep {
+			// register plugin, extension points and extensions
+			e.addPlugin(plug, plug.Details)
+		}
+		if src.Kind == sourceArchive {
+			e.recordArchive(src.Path, keep)
+		}
+		e.mu.Unlock()
+
+		e.settle(plugs)
+	}
+
+	e.precompile(ctx, all)
+
+	return nil
+}
+
+// loadArchive
+//
+// This receiver function reads the plugins in the archive and runs them through admit straight from the archive, then
+// extracts it (see extractArchive) to the engine's pluginPath output location on the local file system. Nothing is
+// written to disk for an archive that fails its signature or lockfile checks. The plugins keep the module bytes that
+// were checked, so an extraction that is changed on disk afterwards is never run. The plugins found are returned
+// without being registered so the caller decides whether to add them or swap them in for an already loaded version, the
+// caller then hands them to settle so GC may remove their extraction once they are unloaded.
+func (e *Engine) loadArchive(file string) ([]*plugin, error) {
+	if archiveSuffix(file) == "" {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedArchive, file)
+	}
+
+	hash, err := hashFile(file)
+	if nil != err {
+		return nil, err
+	}
+
+	plugs, err := e.admitArchive(file, hash)
+	if nil != err {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	outputPath, err := e.extractArchive(file, hash)
+	if nil != err {
+		return nil, err
+	}
+
+	for _, plug := range plugs {
+		// module paths come back relative to the archive
+		plug.rebase(outputPath)
+		plug.ExtractDir = outputPath
+	}
+
+	if len(plugs) == 0 {
+		e.settleExtraction(outputPath)
+	}
+
+	return plugs, nil
+}
+
+// admitArchive
+//
+// This method reads the plugins in an archive whose sha256 is hash, along with their module bytes, and runs them through
+// admit without extracting anything.
+func (e *Engine) admitArchive(file, hash string) ([]*plugin, error) {
+	f, err := os.Open(file)
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if nil != err {
+		return nil, err
+	}
+
+	fsys, err := archiveFS(f, info.Size())
+	if nil != err {
+		return nil, err
+	}
+
+	plugs, err := loadPluginsFS(fsys)
+	if nil != err {
+		return nil, err
+	}
+
+	for _, plug := range plugs {
+		plug.Source = file
+		plug.SourceHash = hash
+	}
+
+	if err := e.admit(fsys, file+".sig", plugs); nil != err {
+		return nil, err
+	}
+
+	return plugs, nil
+}
+
+// admit
+//
+// This method runs the checks plugins have to pass before they are registered: it records the digest of each plugin's
+// module, verifies their signature (see verify) and checks them against the lockfile (see checkLock).
+func (e *Engine) admit(fsys fs.FS, detached string, plugs []*plugin) error {
+	for _, plug := range plugs {
+		hash, err := moduleHash(plug)
+		if nil != err {
+			return err
+		}
+		plug.ModuleHash = hash
+	}
+
+	if err := e.verify(fsys, detached, plugs); nil != err {
+		return err
+	}
+
+	return e.checkLock(plugs)
+}
+
+// removePlugin
+//
+// This method detaches everything a plugin registered with the engine. Its hooks are removed from the anchors they
+// attached to and from the resolved and unresolved lists. Its anchors are removed, and hooks of other plugins that were
+// attached only to those anchors go back to unresolved, which in turn makes their plugins unresolved. The caller must
+// hold the engine lock and is responsible for stopping the plugin instance.
+func (e *Engine) removePlugin(p *plugin) {
+	id, version := p.Details.Id, p.Details.Version
+
+	if pv := e.plugins[id]; nil != pv && pv[version] == p {
+		delete(pv, version)
+		if len(pv) == 0 {
+			delete(e.plugins, id)
+		}
+	}
+
+	// drop this plugin's hooks
+	dropped := make([]*hook, 0)
+	for hid, hk := range e.hooks {
+		if hk.Plugin == p {
+			delete(e.hooks, hid)
+			delete(e.callableHooks, hid)
+			dropped = append(dropped, hk)
+		}
+	}
+
+	leftover := make([]*hook, 0, len(e.unresolved))
+	for _, hk := range e.unresolved {
+		if hk.Plugin != p {
+			leftover = append(leftover, hk)
+		}
+	}
+	e.unresolved = leftover
+
+	// drop this plugin's anchors, and detach its hooks from anchors owned by other plugins
+	orphaned := make([]*hook, 0)
+	for aid, achrs := range e.anchors {
+		remaining := make([]*anchor, 0, len(achrs))
+		for _, achr := range achrs {
+			if achr.Plugin == p {
+				orphaned = append(orphaned, achr.Hooks...)
+				continue
 			}
-		} else if strings.HasSuffix(file, ext) {
-			err = Unzip(file, outputPath)
-			if err != nil {
-				// TODO: Log error.. but do NOT return because other plugins can still be extracted/loaded and work fine
-				fmt.Println("Error unzipping zip plugin: ", file, err)
+
+			hks := make([]*hook, 0, len(achr.Hooks))
+			for _, hk := range achr.Hooks {
+				if hk.Plugin != p {
+					hks = append(hks, hk)
+				}
 			}
+			achr.Hooks = hks
+			remaining = append(remaining, achr)
 		}
 
-		// Because an error could occur, but we're in a loop that needs to process potentially multiple plugins, we're
-		// checking if the error is nil
-		if nil == err {
-			// looking for the extracted yaml plugin descriptor manifest file
-			files, err := findFilesWithExtensions(outputPath, []string{".yaml"})
-			if nil != err {
-				fmt.Println("Error trying to find YAML plugin manifest files")
-			} else if len(files) > 0 {
-				for _, f := range files {
-					// grab the base path where the plugin was extracted
-					base, _ := filepath.Split(f)
-
-					// get the WASM file
-					wasm, err2 := findFilesWithExtensions(base, []string{".wasm"})
-					if nil != err2 {
-						fmt.Println("Error walking base path: ", err2)
-					}
+		if len(remaining) == 0 {
+			delete(e.anchors, aid)
+		} else {
+			e.anchors[aid] = remaining
+		}
+	}
 
-					// read the bytes of the configuration file in
-					data, err := os.ReadFile(f)
-					if err != nil {
-						fmt.Println("Error reading file:", err)
-					}
+	for _, hk := range orphaned {
+		if hk.Plugin == p || len(e.anchors[hk.Anchor]) > 0 {
+			// still attached to another version of the anchor
+			continue
+		}
 
-					p := Plugin{}
-					err = yaml.Unmarshal(data, &p)
+		hk.Resolved = false
+		if e.hooks[hk.Id] == hk {
+			delete(e.hooks, hk.Id)
+			delete(e.callableHooks, hk.Id)
+			dropped = append(dropped, hk)
+		}
+		e.unresolved = append(e.unresolved, hk)
+	}
 
-					if nil != err {
-						fmt.Println("Got error unmarshalling: ", err)
-					} else {
-						plug := &plugin{
-							PathToModule: wasm[0],
-							Plugin:       nil,
-							Resolved:     false,
-						}
+	// another loaded version may still provide a dropped hook
+	for _, hk := range dropped {
+		if nil == e.hooks[hk.Id] {
+			e.rehook(hk.Id, hk.Anchor)
+		}
+	}
 
-						// register plugin, extension points and extensions
-						e.addPlugin(plug, p)
-					}
-				}
+	e.resolve()
+}
+
+// rehook
+//
+// This method points a hook id at the most recently resolved hook with that id still attached to the anchor, if there is
+// one, after the hook it pointed at was removed. The caller must hold the engine lock.
+func (e *Engine) rehook(hookId, anchorId string) {
+	for _, achr := range e.anchors[anchorId] {
+		for _, hk := range achr.Hooks {
+			if hk.Id == hookId && hk.Resolved {
+				e.hooks[hookId] = hk
+				e.callableHooks[hookId] = hk.Plugin
 			}
 		}
 	}
+}
+
+// stopPlugin
+//
+// This method waits for any hook calls running against the plugin's instances to finish, then closes its instance
+// pool, calling the plugin's stop lifecycle exported function on each instance if it has one.
+func (e *Engine) stopPlugin(p *plugin) error {
+	p.inflight.Wait()
+
+	p.mu.Lock()
+	pool := p.pool
+	p.pool = nil
+	p.mu.Unlock()
+
+	if nil != pool {
+		pool.close()
+	}
 
 	return nil
 }
 
+// Unload
+//
+// This method removes the plugin at the id and version provided from the engine. Its hooks and anchors are detached and
+// resolution is run again so plugins with hooks that depended on its anchors become unresolved. The plugin's instance,
+// if any, is stopped once in flight hook calls against it have finished.
+func (e *Engine) Unload(id, version string) error {
+	e.mu.RLock()
+	p := e.plugins[id][version]
+	e.mu.RUnlock()
+
+	if nil == p {
+		return fmt.Errorf("plugin %s@%s is not loaded", id, version)
+	}
+
+	return e.unloadPlugin(p)
+}
+
+// unloadPlugin
+//
+// This method removes and stops p unless it has already been replaced or unloaded.
+func (e *Engine) unloadPlugin(p *plugin) error {
+	e.mu.Lock()
+	if e.plugins[p.Details.Id][p.Details.Version] != p {
+		e.mu.Unlock()
+		return nil
+	}
+
+	e.removePlugin(p)
+	e.mu.Unlock()
+
+	return e.stopPlugin(p)
+}
+
+// Reload
+//
+// This method replaces the plugin at the id and version provided with the plugin of the same id found in the archive.
+// The archive may contain a newer version. If the old plugin was instantiated the new one is instantiated before the
+// swap, so the swap itself is a single step under the engine lock: calls that start after it go to the new plugin,
+// and calls already running against the old instance finish before that instance is stopped.
+func (e *Engine) Reload(id, version, archive string) error {
+	plugs, err := e.loadArchive(archive)
+	if nil != err {
+		return err
+	}
+	defer e.settle(plugs)
+
+	var np *plugin
+	for _, plug := range plugs {
+		if plug.Details.Id == id {
+			np = plug
+		} else {
+			logln("Ignoring plugin in reload archive: ", plug.Details.Id)
+		}
+	}
+
+	if nil == np {
+		return fmt.Errorf("archive %s does not contain plugin %s", archive, id)
+	}
+
+	e.mu.RLock()
+	old := e.plugins[id][version]
+	e.mu.RUnlock()
+
+	if nil == old {
+		return fmt.Errorf("plugin %s@%s is not loaded", id, version)
+	}
+
+	return e.swapPlugin(old, np)
+}
+
+// swapPlugin
+//
+// This method replaces old with np. If old was instantiated np is instantiated first, then the two are swapped under
+// the engine lock and old is stopped once its in flight hook calls have finished. When np fails to instantiate it is
+// stopped and old is left in place.
+func (e *Engine) swapPlugin(old, np *plugin) error {
+	if old.instances() > 0 {
+		if err := e.ensureInstance(e.context, np); nil != err {
+			_ = e.stopPlugin(np)
+			return err
+		}
+	}
+
+	e.mu.Lock()
+	if e.plugins[old.Details.Id][old.Details.Version] != old {
+		e.mu.Unlock()
+		_ = e.stopPlugin(np)
+		return fmt.Errorf("plugin %s@%s changed while reloading", old.Details.Id, old.Details.Version)
+	}
+
+	e.removePlugin(old)
+	e.addPlugin(np, np.Details)
+	e.mu.Unlock()
+
+	return e.stopPlugin(old)
+}
+
 // instantiate
 //
-// this function will create the plugin instance and call the plugin's start lifecycle exported function. This
-// function should be called when another plugin's extension function is to be called and the plugin is not yet created
-func (e *Engine) instantiate(plugin *plugin) error {
-	ctx := e.context
-	compilationCache := wazero.NewCompilationCache()
-	defer func(cache wazero.CompilationCache, ctx context.Context) {
-		err := cache.Close(ctx)
-		if err != nil {
-			fmt.Println("Error closing cache: ", err)
+// this function will create a plugin instance and call the plugin's start lifecycle exported function. It is called by
+// the plugin's instance pool, which passes the compilation cache its instances share.
+func (e *Engine) instantiate(ctx context.Context, plugin *plugin, compilationCache wazero.CompilationCache) (_ *extism.Plugin, err error) {
+	ctx, span := e.startSpan(ctx, "pluginengine.instantiate", pluginAttrs(plugin)...)
+	defer func() { endSpan(span, err) }()
+
+	started := time.Now()
+	defer func() {
+		if nil == err {
+			e.recordInstantiate(plugin, time.Since(started))
 		}
-	}(compilationCache, ctx)
+	}()
 
 	config := extism.PluginConfig{
 		EnableWasi:    true,
@@ -356,33 +729,75 @@ func (e *Engine) instantiate(plugin *plugin) error {
 		RuntimeConfig: wazero.NewRuntimeConfig().WithCompilationCache(compilationCache),
 	}
 
+	e.traceMu.RLock()
+	config.ObserveAdapter = e.observeAdapter
+	config.ObserveOptions = e.observeOptions
+	e.traceMu.RUnlock()
+
+	// linked modules go first under their names, extism takes the last module as the main one
+	wasms := make([]extism.Wasm, 0, len(plugin.Linked)+1)
+	for _, m := range plugin.Linked {
+		wasms = append(wasms, wasmFor(m.Name, m.Path, m.Data))
+	}
+	wasms = append(wasms, wasmFor("", plugin.PathToModule, plugin.ModuleData))
+
+	allowedHosts := e.approvedHosts(plugin)
+
 	manifest := extism.Manifest{
-		Wasm: []extism.Wasm{
-			extism.WasmFile{
-				Path: plugin.PathToModule,
-			},
-		},
+		Wasm:         wasms,
+		Config:       e.pluginConfig(plugin),
+		AllowedHosts: allowedHosts,
 	}
 
 	extism.SetLogLevel(extism.LogLevelDebug)
-	pluginInstance, err := extism.NewPlugin(ctx, manifest, config, e.hostFuncs)
+	// the engine wide host functions plus the ones scoped to this plugin
+	hostFuncs := make([]extism.HostFunction, 0, len(e.hostFuncs)+1)
+	hostFuncs = append(hostFuncs, e.hostFuncs...)
+	hostFuncs = append(hostFuncs, e.pluginHostFuncs(plugin, allowedHosts)...)
+
+	pluginInstance, err := extism.NewPlugin(ctx, manifest, config, hostFuncs)
 
 	if err != nil {
-		fmt.Printf("Failed to initialize plugin: %v\n", err)
+		logf("Failed to initialize plugin: %v\n", err)
+		return nil, err
 	}
 
-	plugin.Plugin = pluginInstance
-
-	_, _, err = pluginInstance.Call("start", nil)
+	// plugin logs go through the engine's log funnel so secrets are redacted from them too
+	name := plugin.Details.Id + "@" + plugin.Details.Version
+	pluginInstance.SetLogger(func(level extism.LogLevel, message string) {
+		logln("[plugin "+name+"]", level.String()+":", message)
+	})
 
-	if nil != err {
-		fmt.Println("Error calling plugin: ", err)
+	if _, _, err := pluginInstance.CallWithContext(withCall(ctx, plugin, "start"), "start", nil); nil != err {
+		logln("Error calling plugin: ", err)
 	}
 	//} else {
 	//	return errors.New("can not instantiate a plugin that is not yet resolved: " + plugin.Details.Id)
 	// }
 
-	return nil
+	return pluginInstance, nil
+}
+
+// wasmFor
+//
+// Returns the extism module for a file, or for its bytes when data is set. Plugins loaded from an fs.FS or an in memory
+// archive carry their module bytes and never touch the disk.
+func wasmFor(name, path string, data []byte) extism.Wasm {
+	if nil != data {
+		return extism.WasmData{Data: data, Name: name}
+	}
+
+	return extism.WasmFile{Path: path, Name: name}
+}
+
+// rebase
+//
+// Joins dir onto the module paths of a plugin read from an fs.FS rooted at dir.
+func (p *plugin) rebase(dir string) {
+	p.PathToModule = filepath.Join(dir, filepath.FromSlash(p.PathToModule))
+	for i := range p.Linked {
+		p.Linked[i].Path = filepath.Join(dir, filepath.FromSlash(p.Linked[i].Path))
+	}
 }
 
 // Start
@@ -390,22 +805,43 @@ func (e *Engine) instantiate(plugin *plugin) error {
 // This method is called by an application to start the engine. This should occur after the Load() has finished and all
 // plugins are found/parsed/resolved. Start will cycle through all plugins to find any with a startOnLoad flag which
 // would indicate the plugin should be instantiated. For plugins that do not have startOnLoad set, they will be
-// instantiated when first used via a call to an extension.
+// instantiated when first used via a call to an extension. Plugins are instantiated concurrently, level by level, so
+// plugins defining anchors are started before the plugins whose hooks attach to them (see startLevels). Nothing is
+// started when plugin versions pinned by an enforced lockfile are not loaded (see checkLockLoaded).
 func (e *Engine) Start() error {
+	// collect under the lock, instantiate without it as a plugin's start function may call back into the engine
+	toStart := make([]*plugin, 0)
+
+	e.mu.RLock()
 	for _, plugin := range e.plugins {
 		if len(plugin) > 0 {
 			for _, verPlugin := range plugin {
 				if verPlugin.LoadOnStart {
-					fmt.Println("Instantiating plugin: ", verPlugin.PathToModule)
-					err := e.instantiate(verPlugin)
-
-					if nil != err {
-						fmt.Println("Error instantiating plugin: ", err)
-					}
+					toStart = append(toStart, verPlugin)
 				}
 			}
 		}
 	}
+	levels := e.startLevels(toStart)
+	e.mu.RUnlock()
+
+	if err := e.checkLockLoaded(); nil != err {
+		return err
+	}
+
+	ctx, span := e.startSpan(e.context, "pluginengine.start")
+	defer span.End()
+
+	for _, level := range levels {
+		forEachConcurrently(level, startWorkers(), func(verPlugin *plugin) {
+			logln("Instantiating plugin: ", verPlugin.PathToModule)
+			err := e.ensureInstance(ctx, verPlugin)
+
+			if nil != err {
+				logln("Error instantiating plugin: ", err)
+			}
+		})
+	}
 
 	return nil
 }
@@ -413,35 +849,45 @@ func (e *Engine) Start() error {
 // Load
 //
 // This recv/func is going to load plugins found in the provided path on the local filesystem. This path should be an
-// absolute path on a local file system or a URL to an archived plugin file. The archive needs to be in a .tar.gz or
-// .zip format. If the path provided is an http/https location, it will download the plugin to the engine plugin path
-// and then unzip/untar it there.
-func (e *Engine) Load(path string) error {
+// absolute path on a local file system or a URL to an archived plugin file. The path may be a directory of plugins, a
+// single .zip, .tar.gz or .tgz archive, an unpacked plugin directory holding a plugin.yaml, or a bare .wasm module
+// (see findPluginSources for the precedence between them). Any other kind of archive is rejected with
+// ErrUnsupportedArchive. If the path provided is an http/https location, it will download the plugin to the engine
+// plugin path and then unzip/untar it there.
+func (e *Engine) Load(path string) (err error) {
+	ctx, span := e.startSpan(e.context, "pluginengine.load", Attribute{AttrLoadPath, path})
+	defer func() { endSpan(span, err) }()
+
 	// First make sure that path is NOT a URL to a single plugin file
 	lower := strings.ToLower(path)
 	if strings.HasPrefix(lower, "http") {
 		// This is a URL
 		u, err := url.Parse(lower)
-		fmt.Println("u, err: ", u, err)
+		logln("u, err: ", u, err)
 		// return for now as nil since we're not doing URLs yet
 		// TODO: FIX THIS
 		return nil
 	}
 
-	base, err := os.Getwd()
+	// relative paths are resolved against the working directory, absolute paths are used as is
+	newPath, err := filepath.Abs(path)
 	if err != nil {
 		return err
 	}
 
-	newPath := filepath.Join(base, lower)
+	e.mu.Lock()
+	e.addLoadPath(newPath)
+	e.mu.Unlock()
 
-	err = e.loadPluginManifests(newPath, "")
+	err = e.loadPluginManifests(ctx, newPath)
 	if nil != err {
-		fmt.Println("Error loading plugins: ", err)
+		logln("Error loading plugins: ", err)
 	}
 
+	e.mu.Lock()
 	e.resolve()
-	return nil
+	e.mu.Unlock()
+	return err
 }
 
 // resolve
@@ -463,11 +909,16 @@ func (e *Engine) resolve() {
 							achr.Hooks = append(achr.Hooks, v)
 							v.Resolved = true
 							e.hooks[v.Id] = v
+							e.callableHooks[v.Id] = v.Plugin
 						} else {
 							// not found, append to leftover
 							leftover = append(leftover, v)
 						}
 					}
+				} else {
+					// no anchor with this id is registered (yet).. keep it around so a later plugin load or host
+					// anchor registration can still resolve it
+					leftover = append(leftover, v)
 				}
 			}
 		}
@@ -475,6 +926,20 @@ func (e *Engine) resolve() {
 		// set the leftover unresolved
 		e.unresolved = leftover
 	}
+
+	// a plugin is resolved once every one of its hooks is
+	for _, pv := range e.plugins {
+		for _, p := range pv {
+			p.Resolved = true
+			for _, hk := range p.Details.Hooks {
+				if rh := e.hooks[hk.Id]; nil == rh || !rh.Resolved {
+					p.Resolved = false
+				}
+			}
+		}
+	}
+
+	e.scheduler.schedulesChanged()
 }
 
 // RegisterHostExtensionPoint
@@ -492,6 +957,9 @@ func (e *Engine) RegisterHostExtensionPoint(id, name, version, description strin
 		},
 	}
 
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
 	achrs := e.anchors[id]
 	if nil == achrs {
 		achrs = make([]*anchor, 0)
@@ -507,23 +975,60 @@ func (e *Engine) GetPlugins() map[string]map[string]*plugin {
 	return e.plugins
 }
 
+// CallHookFunc
+//
+// This method calls the function of a hook with data and returns its output. The call is rejected with an error wrapping
+// ErrRateLimited or ErrTooManyInFlight when it is over a limit, see SetPluginLimit, SetHookLimit and SetCallerLimit.
 func (e *Engine) CallHookFunc(hookId string, data []byte) ([]byte, error) {
-	callable := callableHooks[hookId]
+	return e.callHook(e.context, hookId, data)
+}
 
+// callHook
+//
+// Calls a hook for the plugin making the call in ctx, or for the host. Calls made from within a plugin are checked
+// against the call chain in ctx, see checkChain.
+func (e *Engine) callHook(ctx context.Context, hookId string, data []byte) (_ []byte, err error) {
+	e.mu.RLock()
+	callable := e.callableHooks[hookId]
+	hook := e.hooks[hookId]
 	if nil != callable {
-		hook := e.hooks[hookId]
-		if nil == callable.Plugin {
-			if err := e.instantiate(callable); err != nil {
-				fmt.Println("Problem instantiating callable plugin: ", hook.Func)
-			}
+		// registered while holding the lock so Unload/Reload can not stop the instance out from under this call
+		callable.inflight.Add(1)
+	}
+	e.mu.RUnlock()
+
+	if nil != callable {
+		defer callable.inflight.Done()
+
+		attrs := append(pluginAttrs(callable), Attribute{AttrHookId, hookId})
+		if nil != hook {
+			attrs = append(attrs, Attribute{AttrAnchorId, hook.Anchor})
+		}
+		var span Span
+		ctx, span = e.startSpan(ctx, "pluginengine.call", attrs...)
+		defer func() { endSpan(span, err) }()
+
+		if nil == hook || hook.Plugin != callable {
+			return nil, fmt.Errorf("hook %s is not resolved", hookId)
+		}
+
+		if err := e.checkChain(ctx, callable, hookId); nil != err {
+			return nil, err
 		}
 
-		_, d, err := callable.Plugin.Call(hook.Func, data)
+		caller := ""
+		if p := callerOf(ctx); nil != p {
+			caller = p.Details.Id
+			span.SetAttributes(Attribute{AttrCallerId, caller})
+		}
+
+		release, err := e.acquireLimits(caller, callable.Details.Id, hookId)
 		if nil != err {
 			return nil, err
 		}
+		defer release()
 
-		return d, nil
+		return e.callPlugin(ctx, callable, hookId, hook.Func, data)
 	}
 
 	return nil, nil
@@ -544,25 +1049,43 @@ func NewPluginEngineWithLogging(hostFuncs []extism.HostFunction, logLevel extism
 	anchors := make(map[string][]*anchor)
 	hooks := make(map[string]*hook)
 
-	// verify that the pluginPath exists and/or if not created.. is created
-	err := os.MkdirAll(pluginOutputPath, 0660)
-	if err != nil {
-		return nil, errors.New("a problem trying to create the plugin output path (" + pluginOutputPath + ") : " + err.Error())
+	// verify that the pluginPath exists and/or if not created.. is created. An empty path means the engine never writes
+	// to disk, plugins can then only be loaded with LoadFS and LoadArchive.
+	if pluginOutputPath != "" {
+		err := os.MkdirAll(pluginOutputPath, 0660)
+		if err != nil {
+			return nil, errors.New("a problem trying to create the plugin output path (" + pluginOutputPath + ") : " + err.Error())
+		}
 	}
 
 	// instantiate as we need this in the host functions
 	engine := &Engine{
-		context:    context.Background(),
-		logLevel:   logLevel,
-		plugins:    plugins,
-		unresolved: unresolved,
-		hooks:      hooks,
-		anchors:    anchors,
-		pluginPath: pluginOutputPath,
+		context:       context.Background(),
+		logLevel:      logLevel,
+		plugins:       plugins,
+		unresolved:    unresolved,
+		hooks:         hooks,
+		callableHooks: make(map[string]*plugin),
+		anchors:       anchors,
+		pluginPath:    pluginOutputPath,
+		events:        NewEventBus(),
+		archives:      make(map[string]*archiveRecord),
+		extracting:    make(map[string]int),
+		config:        make(map[string]map[string]string),
+		store:         NewMemoryStore(),
+		storeQuotas:   make(map[string]StoreQuota),
+		clock:         systemClock{},
+		scheduler:     newScheduler(),
+		poolConfigs:   make(map[string]PoolConfig),
+		breakers:      make(map[string]*breaker),
+		limits:        make(map[string]Limit),
+		buckets:       make(map[string]*bucket),
 	}
 
 	hfs := append(hostFuncs, engine.GetHostFuncs()...)
 	engine.hostFuncs = hfs
 
+	engine.RegisterHostExtensionPoint(HTTPRoutesAnchor, "HTTP routes", "", "Hooks serving the HTTP routes their plugin declares, see HTTPHandler")
+
 	return engine, nil
 }
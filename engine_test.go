@@ -0,0 +1,199 @@
+package pluginengine
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	extism "github.com/extism/go-sdk"
+	pdk "github.com/spirefyio/plugin-go-pdk"
+)
+
+func TestUnload(t *testing.T) {
+	e := newTestEngine(t)
+
+	e.addPlugin(&plugin{}, Plugin{
+		Id:      "test.unload.menu",
+		Version: "1.0.0",
+		Anchors: []pdk.Anchor{{Id: "test.unload.menu.items"}},
+	})
+	e.addPlugin(&plugin{}, Plugin{
+		Id:      "test.unload.file",
+		Version: "1.0.0",
+		Hooks:   []pdk.Hook{{Id: "test.unload.file.open", Anchor: "test.unload.menu.items", Func: "open"}},
+	})
+
+	dependent := e.plugins["test.unload.file"]["1.0.0"]
+	if !dependent.Resolved {
+		t.Fatal("Expected dependent plugin to be resolved before unload")
+	}
+
+	if err := e.Unload("test.unload.menu", "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if nil != e.plugins["test.unload.menu"] {
+		t.Errorf("Expected unloaded plugin to be removed")
+	}
+	if nil != e.anchors["test.unload.menu.items"] {
+		t.Errorf("Expected unloaded plugin's anchor to be removed")
+	}
+	if dependent.Resolved {
+		t.Errorf("Expected dependent plugin to become unresolved")
+	}
+	if nil != e.GetHookForId("test.unload.file.open") {
+		t.Errorf("Expected dependent hook to become unresolved")
+	}
+
+	// loading the anchor again resolves the dependent without reloading it
+	e.addPlugin(&plugin{}, Plugin{
+		Id:      "test.unload.menu",
+		Version: "1.0.1",
+		Anchors: []pdk.Anchor{{Id: "test.unload.menu.items"}},
+	})
+	if !dependent.Resolved {
+		t.Errorf("Expected dependent plugin to resolve against the new anchor")
+	}
+
+	if err := e.Unload("test.unload.menu", "1.0.0"); err == nil {
+		t.Errorf("Expected error unloading a plugin that is not loaded")
+	}
+}
+
+func TestHookVersions(t *testing.T) {
+	e := newTestEngine(t)
+	e.RegisterHostExtensionPoint("test.versions.anchor", "Versions", "1.0.0", "")
+
+	versions := make(map[string]*plugin)
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		versions[version] = &plugin{ModuleData: []byte(exportingModule)}
+		e.mu.Lock()
+		e.addPlugin(versions[version], Plugin{
+			Id:        "test.versions",
+			Version:   version,
+			Stateless: true,
+			Hooks:     []pdk.Hook{{Id: "test.versions.run", Anchor: "test.versions.anchor", Func: "run"}},
+		})
+		e.mu.Unlock()
+	}
+
+	// the most recently loaded version is called
+	if _, err := e.CallHookFunc("test.versions.run", nil); err != nil {
+		t.Fatal(err)
+	}
+	if versions["1.1.0"].instances() != 1 || versions["1.0.0"].instances() != 0 {
+		t.Fatal("Expected the call to go to version 1.1.0")
+	}
+
+	// and the other version once it is unloaded
+	if err := e.Unload("test.versions", "1.1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.CallHookFunc("test.versions.run", nil); err != nil {
+		t.Fatal(err)
+	}
+	if versions["1.0.0"].instances() != 1 {
+		t.Fatal("Expected the call to go to version 1.0.0")
+	}
+
+	if err := e.Unload("test.versions", "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if nil != e.GetHookForId("test.versions.run") {
+		t.Error("Expected the hook to be gone with both versions unloaded")
+	}
+}
+
+// a module exporting a "run" function that calls the "block" host function
+const blockingModule = "\x00asm\x01\x00\x00\x00" +
+	"\x01\x04\x01\x60\x00\x00" +
+	"\x02\x1a\x01\x10extism:host/user\x05block\x00\x00" +
+	"\x03\x02\x01\x00" +
+	"\x07\x07\x01\x03run\x00\x01" +
+	"\x0a\x06\x01\x04\x00\x10\x00\x0b"
+
+func TestReloadDuringCall(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	block := extism.NewHostFunctionWithStack("block", func(context.Context, *extism.CurrentPlugin, []uint64) {
+		entered <- struct{}{}
+		<-release
+	}, nil, nil)
+
+	e, err := NewPluginEngine([]extism.HostFunction{block}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.RegisterHostExtensionPoint("test.reload.anchor", "Reload", "1.0.0", "")
+
+	old := &plugin{ModuleData: []byte(blockingModule)}
+	e.mu.Lock()
+	e.addPlugin(old, Plugin{
+		Id:        "test.reload",
+		Version:   "1.0.0",
+		Stateless: true,
+		Hooks:     []pdk.Hook{{Id: "test.reload.run", Anchor: "test.reload.anchor", Func: "run"}},
+		Anchors:   []pdk.Anchor{{Id: "test.reload.menu"}},
+	})
+	e.addPlugin(&plugin{}, Plugin{
+		Id:      "test.reload.dependent",
+		Version: "1.0.0",
+		Hooks:   []pdk.Hook{{Id: "test.reload.dependent.item", Anchor: "test.reload.menu", Func: "item"}},
+	})
+	e.mu.Unlock()
+
+	archive := filepath.Join(t.TempDir(), "reload.zip")
+	writeTestZip(t, archive, map[string]string{
+		"plugin.yaml": "id: test.reload\nversion: 1.0.1\nstateless: true\n" +
+			"hooks:\n  - id: test.reload.run\n    anchor: test.reload.anchor\n    func: run\n" +
+			"anchors:\n  - id: test.reload.menu\n",
+		"plugin.wasm": exportingModule,
+	})
+
+	called := make(chan error, 1)
+	go func() {
+		_, err := e.CallHookFunc("test.reload.run", nil)
+		called <- err
+	}()
+	<-entered
+
+	reloaded := make(chan error, 1)
+	go func() { reloaded <- e.Reload("test.reload", "1.0.0", archive) }()
+
+	waitFor(t, "the reload to swap versions", func() bool {
+		e.mu.RLock()
+		defer e.mu.RUnlock()
+		return nil != e.plugins["test.reload"]["1.0.1"]
+	})
+
+	// the new version takes calls while the old one finishes its call
+	if _, err := e.CallHookFunc("test.reload.run", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-reloaded:
+		t.Fatalf("Expected the reload to wait for the running call, got %v", err)
+	default:
+	}
+
+	close(release)
+	if err := <-called; err != nil {
+		t.Errorf("Expected the running call to complete on the old version, got %v", err)
+	}
+	if err := <-reloaded; err != nil {
+		t.Fatal(err)
+	}
+	if old.instances() != 0 {
+		t.Error("Expected the old version to be stopped")
+	}
+
+	dependent := e.plugins["test.reload.dependent"]["1.0.0"]
+	if !dependent.Resolved {
+		t.Fatal("Expected the dependent to resolve against the reloaded anchor")
+	}
+	if err := e.Unload("test.reload", "1.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if dependent.Resolved {
+		t.Error("Expected the dependent to become unresolved once its anchor is unloaded")
+	}
+}
@@ -0,0 +1,91 @@
+package pluginengine
+
+import (
+	"context"
+	"sort"
+)
+
+// EventResponse
+//
+// What a plugin listener returned for a published event. Listener is <plugin id>@<version>/<listener>, where the
+// listener is named by its id or, if it has none, its function.
+type EventResponse struct {
+	Listener string
+	Response []byte
+	Err      error
+}
+
+// a plugin listener an event is delivered to
+type listenerTarget struct {
+	name     string
+	plugin   *plugin
+	listener EventListener
+}
+
+// Publish
+//
+// This method publishes an event. Host listeners registered on the event bus are dispatched to asynchronously and their
+// responses are ignored, the same as engine events. The listeners that resolved plugins declare in their manifest for
+// the event are then called one after another, instantiating their plugin if needed, and their responses are returned
+// in listener order.
+func (e *Engine) Publish(name string, payload []byte) []EventResponse {
+	ctx, span := e.startSpan(e.context, "pluginengine.event", Attribute{AttrEventName, name})
+	defer span.End()
+
+	e.events.DispatchEvent(Event{Name: name, Payload: payload}, func(response []byte, err error) {})
+
+	targets := make([]listenerTarget, 0)
+
+	e.mu.RLock()
+	for _, pv := range e.plugins {
+		for _, p := range pv {
+			if !p.Resolved {
+				continue
+			}
+
+			for _, l := range p.Details.Listeners {
+				if l.Event != name {
+					continue
+				}
+
+				listenerName := l.Id
+				if listenerName == "" {
+					listenerName = l.Func
+				}
+
+				// registered while holding the lock so Unload/Reload wait for the listener to finish
+				p.inflight.Add(1)
+				targets = append(targets, listenerTarget{
+					name:     p.Details.Id + "@" + p.Details.Version + "/" + listenerName,
+					plugin:   p,
+					listener: l,
+				})
+			}
+		}
+	}
+	e.mu.RUnlock()
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].name < targets[j].name
+	})
+
+	responses := make([]EventResponse, 0, len(targets))
+	for _, target := range targets {
+		response, err := e.callListener(ctx, target, payload)
+		responses = append(responses, EventResponse{Listener: target.name, Response: response, Err: err})
+	}
+
+	return responses
+}
+
+// callListener
+//
+// Calls a plugin listener with the event payload. The caller must have registered the call as in flight on the plugin.
+func (e *Engine) callListener(ctx context.Context, target listenerTarget, payload []byte) (_ []byte, err error) {
+	defer target.plugin.inflight.Done()
+
+	ctx, span := e.startSpan(ctx, "pluginengine.listener", append(pluginAttrs(target.plugin), Attribute{AttrListener, target.name})...)
+	defer func() { endSpan(span, err) }()
+
+	return e.callPlugin(ctx, target.plugin, target.name, target.listener.Func, payload)
+}
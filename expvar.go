@@ -0,0 +1,47 @@
+package pluginengine
+
+import (
+	"expvar"
+	"sync"
+)
+
+// ExpvarMetrics
+//
+// Metrics published with the expvar package, so they are served on /debug/vars with the process's other variables.
+// Every metric and set of label values is a float in one expvar.Map, keyed by the metric name followed by its labels
+// as Prometheus writes them. Histograms are kept as their _count and _sum.
+type ExpvarMetrics struct {
+	mu   sync.Mutex
+	vars *expvar.Map
+}
+
+// NewExpvarMetrics
+//
+// Returns ExpvarMetrics published under name. Like expvar.Publish it panics if name is already in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	return &ExpvarMetrics{vars: expvar.NewMap(name)}
+}
+
+func (m *ExpvarMetrics) Add(name string, labels Labels, delta float64) {
+	m.vars.AddFloat(name+formatPairs(labelPairs(labels)), delta)
+}
+
+func (m *ExpvarMetrics) Set(name string, labels Labels, value float64) {
+	key := name + formatPairs(labelPairs(labels))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.vars.Get(key).(*expvar.Float)
+	if !ok {
+		v = new(expvar.Float)
+		m.vars.Set(key, v)
+	}
+	v.Set(value)
+}
+
+func (m *ExpvarMetrics) Observe(name string, labels Labels, value float64) {
+	pairs := formatPairs(labelPairs(labels))
+	m.vars.AddFloat(name+"_count"+pairs, 1)
+	m.vars.AddFloat(name+"_sum"+pairs, value)
+}
@@ -0,0 +1,196 @@
+package pluginengine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// prefix of the directories archives are extracted into before being renamed to their hash
+const stagingPrefix = ".staging-"
+
+// ErrUnsafeArchiveEntry is returned for archive entries that could be written outside of the directory the archive is
+// extracted into: absolute names, names with a .. element, and symbolic or hard links.
+var ErrUnsafeArchiveEntry = errors.New("unsafe archive entry")
+
+// extractArchive
+//
+// This method extracts the archive into the engine's plugin output path, in a directory named by the archive hash.
+// The archive is first extracted into a staging directory which is then renamed into place, so a directory named by a
+// hash is always a complete extraction: a crash part way through only leaves a staging directory behind for GC to
+// clean up. If the hash has been extracted before the existing directory is reused. Two archives with the same name
+// but different contents never collide, and nothing from an older version of an archive lingers in the new extraction.
+func (e *Engine) extractArchive(file, hash string) (string, error) {
+	if e.pluginPath == "" {
+		return "", errors.New("the engine has no plugin output path to extract " + file + " to, use LoadArchive instead")
+	}
+
+	e.extractMu.Lock()
+	defer e.extractMu.Unlock()
+
+	outputPath := filepath.Join(e.pluginPath, hash)
+	if info, err := os.Stat(outputPath); nil == err && info.IsDir() {
+		e.extracting[outputPath]++
+		return outputPath, nil
+	}
+
+	staging, err := os.MkdirTemp(e.pluginPath, stagingPrefix+getPluginName(file)+"-")
+	if nil != err {
+		return "", err
+	}
+
+	switch archiveSuffix(file) {
+	case ".tar.gz", ".tgz":
+		err = Untar(file, staging)
+	case ".zip":
+		err = Unzip(file, staging)
+	default:
+		err = fmt.Errorf("%w: %s", ErrUnsupportedArchive, file)
+	}
+
+	if nil == err {
+		// MkdirTemp creates the directory 0700, open it up like the rest of the extraction
+		err = os.Chmod(staging, 0755)
+	}
+
+	if nil == err {
+		err = os.Rename(staging, outputPath)
+		if nil != err {
+			// another engine sharing the output path may have extracted the same archive first
+			if info, statErr := os.Stat(outputPath); nil == statErr && info.IsDir() {
+				err = nil
+			}
+		}
+	}
+
+	// after a successful rename the staging directory no longer exists
+	_ = os.RemoveAll(staging)
+
+	if nil != err {
+		return "", err
+	}
+
+	e.extracting[outputPath]++
+	return outputPath, nil
+}
+
+// settle
+//
+// Ends the extraction of the directories the plugins were extracted to, once they have been registered or rejected.
+// Until then GC keeps the directories even though no loaded plugin refers to them. The plugins extracted by one call
+// to loadArchive must be settled together, as they share one extraction.
+func (e *Engine) settle(plugs []*plugin) {
+	dirs := make(map[string]bool)
+	for _, p := range plugs {
+		if p.ExtractDir != "" && !dirs[p.ExtractDir] {
+			dirs[p.ExtractDir] = true
+			e.settleExtraction(p.ExtractDir)
+		}
+	}
+}
+
+// settleExtraction ends one extraction of dir, see settle
+func (e *Engine) settleExtraction(dir string) {
+	e.extractMu.Lock()
+	defer e.extractMu.Unlock()
+
+	if e.extracting[dir]--; e.extracting[dir] <= 0 {
+		delete(e.extracting, dir)
+	}
+}
+
+// GC
+//
+// This method removes extraction directories from the engine's plugin output path that no loaded plugin was extracted
+// to, for example after a plugin has been unloaded or reloaded from a new archive. Directories extracted by a load that
+// has not registered its plugins yet are kept. Staging directories left behind by an interrupted extraction are removed
+// as well. The paths removed are returned.
+func (e *Engine) GC() ([]string, error) {
+	if e.pluginPath == "" {
+		return nil, nil
+	}
+
+	e.extractMu.Lock()
+	defer e.extractMu.Unlock()
+
+	// pending extractions are settled only once their plugins are registered, so with extractMu held a directory is
+	// either still extracting or referred to by a loaded plugin
+	inUse := make(map[string]bool)
+	for dir := range e.extracting {
+		inUse[filepath.Clean(dir)] = true
+	}
+
+	e.mu.RLock()
+	for _, pv := range e.plugins {
+		for _, p := range pv {
+			if p.ExtractDir != "" {
+				inUse[filepath.Clean(p.ExtractDir)] = true
+			}
+		}
+	}
+	e.mu.RUnlock()
+
+	entries, err := os.ReadDir(e.pluginPath)
+	if nil != err {
+		return nil, err
+	}
+
+	removed := make([]string, 0)
+	for _, entry := range entries {
+		dir := filepath.Join(e.pluginPath, entry.Name())
+		if !entry.IsDir() || inUse[dir] {
+			continue
+		}
+
+		// only touch directories the engine created
+		if !strings.HasPrefix(entry.Name(), stagingPrefix) && !isHexHash(entry.Name()) {
+			continue
+		}
+
+		if err := os.RemoveAll(dir); nil != err {
+			return removed, err
+		}
+		removed = append(removed, dir)
+	}
+
+	return removed, nil
+}
+
+// extractPath
+//
+// Returns where the archive entry name is extracted to under root, or an error wrapping ErrUnsafeArchiveEntry when the
+// name is absolute or has a .. element.
+func extractPath(root, name string) (string, error) {
+	slashed := filepath.ToSlash(name)
+	if path.IsAbs(slashed) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("%w: %s is absolute", ErrUnsafeArchiveEntry, name)
+	}
+
+	for _, elem := range strings.Split(slashed, "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("%w: %s leaves the extraction directory", ErrUnsafeArchiveEntry, name)
+		}
+	}
+
+	return filepath.Join(root, filepath.FromSlash(slashed)), nil
+}
+
+// isHexHash
+//
+// Returns true for a hex encoded sha256, the name of an extraction directory.
+func isHexHash(name string) bool {
+	if len(name) != 64 {
+		return false
+	}
+
+	for _, c := range name {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,197 @@
+package pluginengine
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractArchive(t *testing.T) {
+	e := newTestEngine(t)
+	archive := filepath.Join(t.TempDir(), "extract.zip")
+	writeTestPluginZip(t, archive, "id: test.extract\nversion: 1.0.0\n")
+
+	plugs, err := e.loadArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plugs) != 1 {
+		t.Fatalf("Expected one plugin, got %d", len(plugs))
+	}
+
+	dir := plugs[0].ExtractDir
+	if filepath.Base(dir) != plugs[0].SourceHash {
+		t.Errorf("Expected the archive to be extracted to a directory named by its hash, got %s", dir)
+	}
+
+	// a marker left in the extraction shows the second load reuses it rather than extracting again
+	mustWrite(t, filepath.Join(dir, "marker"), "")
+	again, err := e.loadArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[0].ExtractDir != dir {
+		t.Errorf("Expected the extraction to be reused, got %s", again[0].ExtractDir)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "marker")); err != nil {
+		t.Errorf("Expected the existing extraction to be left as is")
+	}
+	e.settle(again)
+
+	stale := filepath.Join(e.pluginPath, stagingPrefix+"extract-123")
+	unrelated := filepath.Join(e.pluginPath, "keep-me")
+	mustWrite(t, filepath.Join(stale, "plugin.yaml"), "")
+	mustWrite(t, filepath.Join(unrelated, "file"), "")
+
+	e.register(plugs)
+	removed, err := e.GC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != stale {
+		t.Errorf("Expected only the staging directory to be removed, got %v", removed)
+	}
+
+	if err := e.Unload("test.extract", "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	removed, err = e.GC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != dir {
+		t.Errorf("Expected the unreferenced extraction to be removed, got %v", removed)
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Errorf("Expected directories the engine did not create to be kept")
+	}
+}
+
+func TestGCDuringLoad(t *testing.T) {
+	e := newTestEngine(t)
+	archive := filepath.Join(t.TempDir(), "pending.zip")
+	writeTestPluginZip(t, archive, "id: test.pending\nversion: 1.0.0\n")
+
+	plugs, err := e.loadArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// extracted but not registered yet
+	if removed, err := e.GC(); err != nil || len(removed) != 0 {
+		t.Fatalf("Expected GC to keep an extraction whose plugins are not registered yet, removed %v, %v", removed, err)
+	}
+
+	e.register(plugs)
+	if removed, err := e.GC(); err != nil || len(removed) != 0 {
+		t.Fatalf("Expected GC to keep the extraction of a loaded plugin, removed %v, %v", removed, err)
+	}
+
+	// a load that is rejected settles its extraction as well
+	again, err := e.loadArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Unload("test.pending", "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if removed, _ := e.GC(); len(removed) != 0 {
+		t.Fatalf("Expected GC to keep the extraction while it is loaded again, removed %v", removed)
+	}
+	e.settle(again)
+	if removed, _ := e.GC(); len(removed) != 1 || removed[0] != plugs[0].ExtractDir {
+		t.Errorf("Expected GC to remove the settled extraction, removed %v", removed)
+	}
+}
+
+func TestExtractUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name   string
+		header tar.Header
+	}{
+		{"parent", tar.Header{Name: "../evil.txt", Typeflag: tar.TypeReg}},
+		{"nested parent", tar.Header{Name: "plugin/../../evil.txt", Typeflag: tar.TypeReg}},
+		{"absolute", tar.Header{Name: "/evil.txt", Typeflag: tar.TypeReg}},
+		{"symlink", tar.Header{Name: "link", Linkname: "../", Typeflag: tar.TypeSymlink}},
+		{"hardlink", tar.Header{Name: "link", Linkname: "/etc/passwd", Typeflag: tar.TypeLink}},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		output := filepath.Join(dir, "out")
+
+		var tarred bytes.Buffer
+		gw := gzip.NewWriter(&tarred)
+		tw := tar.NewWriter(gw)
+		for _, header := range []tar.Header{{Name: "plugin.yaml", Typeflag: tar.TypeReg}, test.header} {
+			header.Mode = 0644
+			if header.Typeflag == tar.TypeReg {
+				header.Size = 4
+			}
+			_ = tw.WriteHeader(&header)
+			if header.Typeflag == tar.TypeReg {
+				_, _ = tw.Write([]byte("evil"))
+			}
+		}
+		_ = tw.Close()
+		_ = gw.Close()
+		mustWrite(t, filepath.Join(dir, "unsafe.tar.gz"), tarred.String())
+
+		var zipped bytes.Buffer
+		zw := zip.NewWriter(&zipped)
+		header := &zip.FileHeader{Name: test.header.Name}
+		if test.header.Typeflag != tar.TypeReg {
+			header.SetMode(os.ModeSymlink | 0777)
+		}
+		w, _ := zw.CreateHeader(header)
+		_, _ = w.Write([]byte("evil"))
+		_ = zw.Close()
+		mustWrite(t, filepath.Join(dir, "unsafe.zip"), zipped.String())
+
+		if err := Untar(filepath.Join(dir, "unsafe.tar.gz"), output); !errors.Is(err, ErrUnsafeArchiveEntry) {
+			t.Errorf("%s: expected the tar entry to be refused, got %v", test.name, err)
+		}
+		if err := Unzip(filepath.Join(dir, "unsafe.zip"), output); !errors.Is(err, ErrUnsafeArchiveEntry) {
+			t.Errorf("%s: expected the zip entry to be refused, got %v", test.name, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "evil.txt")); err == nil {
+			t.Errorf("%s: expected nothing to be written outside of the output path", test.name)
+		}
+	}
+
+	// loading an unsafe archive leaves nothing behind
+	e := newTestEngine(t)
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "unsafe.zip")
+	writeTestZip(t, archive, map[string]string{
+		"plugin.yaml": "id: test.unsafe\nversion: 1.0.0\n", "plugin.wasm": "\x00asm", "../evil.txt": "evil",
+	})
+	if _, err := e.loadArchive(archive); err == nil {
+		t.Fatal("Expected the unsafe archive to be refused")
+	}
+	if entries, _ := os.ReadDir(e.pluginPath); len(entries) != 0 {
+		t.Errorf("Expected nothing to be extracted, got %v", entries)
+	}
+	if _, err := os.Stat(filepath.Join(e.pluginPath, "..", "evil.txt")); err == nil {
+		t.Error("Expected nothing to be written outside of the plugin path")
+	}
+}
+
+func TestVerifyBeforeExtract(t *testing.T) {
+	e := newTestEngine(t)
+	e.SetVerificationPolicy(PolicyEnforce)
+
+	archive := filepath.Join(t.TempDir(), "unsigned.zip")
+	writeTestPluginZip(t, archive, "id: test.unsigned\nversion: 1.0.0\n")
+	if _, err := e.loadArchive(archive); err == nil {
+		t.Fatal("Expected the unsigned archive to be refused")
+	}
+	if entries, _ := os.ReadDir(e.pluginPath); len(entries) != 0 {
+		t.Errorf("Expected nothing to be extracted for an archive that fails verification, got %v", entries)
+	}
+}
@@ -0,0 +1,259 @@
+package pluginengine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type (
+	GraphNodeKind string
+	GraphEdgeKind string
+
+	// GraphNode
+	//
+	// A single node in the extension topology. Id is unique across the graph and is prefixed with the kind so that an
+	// anchor and a hook sharing the same id do not collide. Resolved is false for hooks that did not attach to an anchor
+	// and for anchors that hooks reference but no plugin (or the host) defines.
+	GraphNode struct {
+		Id       string        `json:"id" yaml:"id"`
+		Kind     GraphNodeKind `json:"kind" yaml:"kind"`
+		Label    string        `json:"label" yaml:"label"`
+		Plugin   string        `json:"plugin,omitempty" yaml:"plugin,omitempty"`
+		Resolved bool          `json:"resolved" yaml:"resolved"`
+	}
+
+	GraphEdge struct {
+		From string        `json:"from" yaml:"from"`
+		To   string        `json:"to" yaml:"to"`
+		Kind GraphEdgeKind `json:"kind" yaml:"kind"`
+	}
+
+	// Graph
+	//
+	// A snapshot of the plugins, anchors, hooks, events and listeners known to an engine and how they relate to one
+	// another. Nodes and edges are sorted so encoding the same engine state always produces the same output.
+	Graph struct {
+		Nodes []GraphNode `json:"nodes" yaml:"nodes"`
+		Edges []GraphEdge `json:"edges" yaml:"edges"`
+	}
+)
+
+const (
+	NodeHost     GraphNodeKind = "host"
+	NodePlugin   GraphNodeKind = "plugin"
+	NodeAnchor   GraphNodeKind = "anchor"
+	NodeHook     GraphNodeKind = "hook"
+	NodeEvent    GraphNodeKind = "event"
+	NodeListener GraphNodeKind = "listener"
+
+	// EdgeDefines links a plugin (or the host) to an anchor it defines
+	EdgeDefines GraphEdgeKind = "defines"
+	// EdgeProvides links a plugin to a hook or listener it contributes
+	EdgeProvides GraphEdgeKind = "provides"
+	// EdgeAttaches links a hook to the anchor it attaches to
+	EdgeAttaches GraphEdgeKind = "attaches"
+	// EdgeDependsOn links a plugin to a plugin that defines an anchor one of its hooks attaches to
+	EdgeDependsOn GraphEdgeKind = "dependsOn"
+	// EdgePublishes links a plugin to an event it publishes
+	EdgePublishes GraphEdgeKind = "publishes"
+	// EdgeSubscribes links a listener to the event it listens for
+	EdgeSubscribes GraphEdgeKind = "subscribes"
+
+	hostNodeId = "host"
+)
+
+func pluginNodeId(id, version string) string {
+	return "plugin:" + id + "@" + version
+}
+
+// Graph
+//
+// This method builds a Graph of the current extension topology. Every loaded plugin version becomes a node, along with
+// the anchors, hooks, events and listeners its manifest declares. Host anchors registered through
+// RegisterHostExtensionPoint are attached to a single host node.
+func (e *Engine) Graph() *Graph {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	g := &Graph{}
+	nodes := make(map[string]*GraphNode)
+	edges := make(map[GraphEdge]bool)
+
+	addNode := func(n GraphNode) {
+		if existing := nodes[n.Id]; nil != existing {
+			// a node seen once as missing and later as defined is defined
+			existing.Resolved = existing.Resolved || n.Resolved
+			return
+		}
+		nodes[n.Id] = &n
+	}
+
+	addEdge := func(from, to string, kind GraphEdgeKind) {
+		if from != to {
+			edges[GraphEdge{From: from, To: to, Kind: kind}] = true
+		}
+	}
+
+	// owners of each anchor id so hooks can be turned into plugin dependencies
+	anchorOwners := make(map[string][]string)
+	// the hooks of each plugin version attached to an anchor, by hook id
+	attached := make(map[*plugin]map[string]bool)
+
+	for anchorId, achrs := range e.anchors {
+		for _, achr := range achrs {
+			for _, hk := range achr.Hooks {
+				if nil == attached[hk.Plugin] {
+					attached[hk.Plugin] = make(map[string]bool)
+				}
+				attached[hk.Plugin][hk.Id] = attached[hk.Plugin][hk.Id] || hk.Resolved
+			}
+
+			owner := hostNodeId
+			if nil != achr.Plugin {
+				owner = pluginNodeId(achr.Plugin.Details.Id, achr.Plugin.Details.Version)
+			} else {
+				addNode(GraphNode{Id: hostNodeId, Kind: NodeHost, Label: "host", Resolved: true})
+			}
+
+			addNode(GraphNode{Id: "anchor:" + anchorId, Kind: NodeAnchor, Label: labelFor(achr.Name, anchorId), Resolved: true})
+			addEdge(owner, "anchor:"+anchorId, EdgeDefines)
+			anchorOwners[anchorId] = append(anchorOwners[anchorId], owner)
+		}
+	}
+
+	for _, versions := range e.plugins {
+		for _, p := range versions {
+			plug := p.Details
+			pid := pluginNodeId(plug.Id, plug.Version)
+			addNode(GraphNode{Id: pid, Kind: NodePlugin, Label: labelFor(plug.Name, plug.Id) + " " + plug.Version, Plugin: pid, Resolved: p.Resolved})
+
+			for _, hk := range plug.Hooks {
+				// hooks are per plugin version, versions loaded side by side each have their own
+				hid := "hook:" + plug.Id + "@" + plug.Version + "/" + hk.Id
+				aid := "anchor:" + hk.Anchor
+
+				addNode(GraphNode{Id: hid, Kind: NodeHook, Label: labelFor(hk.Name, hk.Id), Plugin: pid, Resolved: attached[p][hk.Id]})
+				// an anchor that is referenced but never defined is still shown so the missing piece is visible
+				addNode(GraphNode{Id: aid, Kind: NodeAnchor, Label: hk.Anchor, Resolved: false})
+				addEdge(pid, hid, EdgeProvides)
+				addEdge(hid, aid, EdgeAttaches)
+
+				for _, owner := range anchorOwners[hk.Anchor] {
+					addEdge(pid, owner, EdgeDependsOn)
+				}
+			}
+
+			for _, evt := range plug.Events {
+				eid := "event:" + evt
+				addNode(GraphNode{Id: eid, Kind: NodeEvent, Label: evt, Resolved: true})
+				addEdge(pid, eid, EdgePublishes)
+			}
+
+			for _, l := range plug.Listeners {
+				name := l.Id
+				if name == "" {
+					name = l.Func
+				}
+
+				lid := "listener:" + plug.Id + "@" + plug.Version + "/" + name
+				eid := "event:" + l.Event
+				addNode(GraphNode{Id: lid, Kind: NodeListener, Label: name, Plugin: pid, Resolved: true})
+				addNode(GraphNode{Id: eid, Kind: NodeEvent, Label: l.Event, Resolved: true})
+				addEdge(pid, lid, EdgeProvides)
+				addEdge(lid, eid, EdgeSubscribes)
+			}
+		}
+	}
+
+	for _, n := range nodes {
+		g.Nodes = append(g.Nodes, *n)
+	}
+
+	for edge := range edges {
+		g.Edges = append(g.Edges, edge)
+	}
+
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		return g.Nodes[i].Id < g.Nodes[j].Id
+	})
+
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Kind < b.Kind
+	})
+
+	return g
+}
+
+func labelFor(name, id string) string {
+	if name != "" {
+		return name
+	}
+
+	return id
+}
+
+// EncodeJSON
+//
+// Writes the graph as indented JSON to w.
+func (g *Graph) EncodeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
+
+// EncodeDOT
+//
+// Writes the graph in Graphviz DOT format to w. Unresolved hooks and missing anchors are drawn dashed and red so
+// resolution problems stand out when rendered.
+func (g *Graph) EncodeDOT(w io.Writer) error {
+	var sb strings.Builder
+
+	sb.WriteString("digraph pluginengine {\n")
+	sb.WriteString("  rankdir=LR;\n")
+
+	for _, n := range g.Nodes {
+		attrs := fmt.Sprintf("label=%s, shape=%s", dotQuote(n.Label), dotShape(n.Kind))
+		if !n.Resolved && n.Kind != NodePlugin {
+			attrs += ", style=dashed, color=red"
+		}
+		sb.WriteString(fmt.Sprintf("  %s [%s];\n", dotQuote(n.Id), attrs))
+	}
+
+	for _, edge := range g.Edges {
+		sb.WriteString(fmt.Sprintf("  %s -> %s [label=%s];\n", dotQuote(edge.From), dotQuote(edge.To), dotQuote(string(edge.Kind))))
+	}
+
+	sb.WriteString("}\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func dotShape(kind GraphNodeKind) string {
+	switch kind {
+	case NodeHost:
+		return "box3d"
+	case NodePlugin:
+		return "box"
+	case NodeAnchor:
+		return "diamond"
+	case NodeEvent:
+		return "note"
+	default:
+		return "ellipse"
+	}
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
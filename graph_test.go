@@ -0,0 +1,136 @@
+package pluginengine
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	pdk "github.com/spirefyio/plugin-go-pdk"
+)
+
+func newTestEngine(t *testing.T) *Engine {
+	e, err := NewPluginEngine(nil, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+func TestGraph(t *testing.T) {
+	e := newTestEngine(t)
+
+	e.addPlugin(&plugin{}, Plugin{
+		Id:      "test.menu",
+		Version: "1.0.0",
+		Anchors: []pdk.Anchor{{Id: "test.menu.items"}},
+		Events:  []string{"test.menu.opened"},
+	})
+	e.addPlugin(&plugin{}, Plugin{
+		Id:      "test.file",
+		Version: "1.0.0",
+		Hooks: []pdk.Hook{
+			{Id: "test.file.open", Anchor: "test.menu.items", Func: "open"},
+			{Id: "test.file.missing", Anchor: "test.nowhere", Func: "missing"},
+		},
+		Listeners: []EventListener{{Id: "onOpened", Event: "test.menu.opened", Func: "opened"}},
+	})
+
+	g := e.Graph()
+
+	assertEdge(t, g, "plugin:test.menu@1.0.0", "anchor:test.menu.items", EdgeDefines)
+	assertEdge(t, g, "hook:test.file@1.0.0/test.file.open", "anchor:test.menu.items", EdgeAttaches)
+	assertEdge(t, g, "plugin:test.file@1.0.0", "plugin:test.menu@1.0.0", EdgeDependsOn)
+	assertEdge(t, g, "plugin:test.menu@1.0.0", "event:test.menu.opened", EdgePublishes)
+	assertEdge(t, g, "listener:test.file@1.0.0/onOpened", "event:test.menu.opened", EdgeSubscribes)
+
+	for _, n := range g.Nodes {
+		switch n.Id {
+		case "hook:test.file@1.0.0/test.file.open", "anchor:test.menu.items":
+			if !n.Resolved {
+				t.Errorf("Expected %s to be resolved", n.Id)
+			}
+		case "hook:test.file@1.0.0/test.file.missing", "anchor:test.nowhere":
+			if n.Resolved {
+				t.Errorf("Expected %s to be unresolved", n.Id)
+			}
+		}
+	}
+
+	var dot bytes.Buffer
+	if err := g.EncodeDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dot.String(), `"hook:test.file@1.0.0/test.file.open" -> "anchor:test.menu.items" [label="attaches"];`) {
+		t.Errorf("Expected attaches edge in DOT output, got:\n%s", dot.String())
+	}
+
+	var js bytes.Buffer
+	if err := g.EncodeJSON(&js); err != nil {
+		t.Fatal(err)
+	}
+	decoded := Graph{}
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Nodes) != len(g.Nodes) || len(decoded.Edges) != len(g.Edges) {
+		t.Errorf("Expected JSON round trip to keep %d nodes and %d edges", len(g.Nodes), len(g.Edges))
+	}
+}
+
+func TestGraph_Versions(t *testing.T) {
+	e := newTestEngine(t)
+	e.RegisterHostExtensionPoint("test.menu.items", "Items", "1.0.0", "")
+
+	// the second version moves its hook to an anchor nobody defines
+	e.addPlugin(&plugin{}, Plugin{
+		Id:      "test.file",
+		Version: "1.0.0",
+		Hooks:   []pdk.Hook{{Id: "test.file.open", Anchor: "test.menu.items", Func: "open"}},
+	})
+	e.addPlugin(&plugin{}, Plugin{
+		Id:      "test.file",
+		Version: "2.0.0",
+		Hooks:   []pdk.Hook{{Id: "test.file.open", Anchor: "test.nowhere", Func: "open"}},
+	})
+
+	g := e.Graph()
+	assertEdge(t, g, "plugin:test.file@1.0.0", "hook:test.file@1.0.0/test.file.open", EdgeProvides)
+	assertEdge(t, g, "plugin:test.file@2.0.0", "hook:test.file@2.0.0/test.file.open", EdgeProvides)
+
+	expected := map[string]GraphNode{
+		"hook:test.file@1.0.0/test.file.open": {Plugin: "plugin:test.file@1.0.0", Resolved: true},
+		"hook:test.file@2.0.0/test.file.open": {Plugin: "plugin:test.file@2.0.0", Resolved: false},
+	}
+	for _, n := range g.Nodes {
+		if want, ok := expected[n.Id]; ok {
+			if n.Plugin != want.Plugin || n.Resolved != want.Resolved {
+				t.Errorf("Expected %s to belong to %s with resolved %v, got %+v", n.Id, want.Plugin, want.Resolved, n)
+			}
+			delete(expected, n.Id)
+		}
+	}
+	if len(expected) != 0 {
+		t.Errorf("Expected a hook node per version, missing %v", expected)
+	}
+}
+
+func TestGraph_HostAnchor(t *testing.T) {
+	e := newTestEngine(t)
+	e.RegisterHostExtensionPoint("host.toolbar", "Toolbar", "1.0.0", "")
+
+	assertEdge(t, e.Graph(), "host", "anchor:host.toolbar", EdgeDefines)
+}
+
+func assertEdge(t *testing.T, g *Graph, from, to string, kind GraphEdgeKind) {
+	t.Helper()
+
+	for _, edge := range g.Edges {
+		if edge.From == from && edge.To == to && edge.Kind == kind {
+			return
+		}
+	}
+
+	t.Errorf("Expected edge %s -[%s]-> %s", from, kind, to)
+}
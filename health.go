@@ -0,0 +1,366 @@
+package pluginengine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero/sys"
+)
+
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerOpenFor  = 30 * time.Second
+	defaultRestartBackoff  = 100 * time.Millisecond
+	defaultMaxBackoff      = 30 * time.Second
+)
+
+// ErrPluginUnavailable is wrapped by the errors returned for calls into a plugin whose circuit breaker is open or that
+// is waiting to be restarted after crashing.
+var ErrPluginUnavailable = errors.New("plugin unavailable")
+
+// PluginState is the health of a plugin, or of one of its hooks when breakers are per hook.
+type PluginState string
+
+const (
+	// StateRunning is a healthy plugin, its breaker is closed
+	StateRunning PluginState = "running"
+	// StateRestarting is a plugin whose instance crashed and is re-instantiated after a backoff
+	StateRestarting PluginState = "restarting"
+	// StateOpen is a plugin whose breaker opened after too many consecutive failures, calls fail with
+	// ErrPluginUnavailable
+	StateOpen PluginState = "open"
+	// StateHalfOpen is a plugin whose breaker lets a single trial call through to decide whether to close again
+	StateHalfOpen PluginState = "half-open"
+)
+
+type (
+	// RestartPolicy
+	//
+	// How the engine deals with crashing plugins. A plugin instance that traps is discarded and the plugin is
+	// re-instantiated after Backoff, doubling with every consecutive crash or failed restart up to MaxBackoff, calls in
+	// the meantime fail with ErrPluginUnavailable. Failures consecutive calls that trap or fail to instantiate open the
+	// plugin's circuit breaker, which fails calls with ErrPluginUnavailable for OpenFor and then lets a trial call
+	// through, closing again if it succeeds. With PerHook each hook, listener and schedule has its own breaker. A zero
+	// Failures is 5 and a negative one disables the breaker, other zero values take their defaults of 30s, 100ms and
+	// 30s.
+	RestartPolicy struct {
+		Failures   int           `json:"failures" yaml:"failures"`
+		OpenFor    time.Duration `json:"openFor" yaml:"openFor"`
+		PerHook    bool          `json:"perHook" yaml:"perHook"`
+		Backoff    time.Duration `json:"backoff" yaml:"backoff"`
+		MaxBackoff time.Duration `json:"maxBackoff" yaml:"maxBackoff"`
+	}
+
+	// PluginStateEvent
+	//
+	// A change in the health of a plugin version reported to the listener set with Engine.SetStateListener. Hook is set
+	// for changes of a per-hook breaker and Err is the error that caused the change, if any.
+	PluginStateEvent struct {
+		Id      string
+		Version string
+		Hook    string
+		From    PluginState
+		To      PluginState
+		Err     error
+		Time    time.Time
+	}
+
+	// breaker is the circuit breaker of a plugin version or one of its hooks
+	breaker struct {
+		state     PluginState
+		failures  int
+		openUntil time.Time
+		// a half-open trial call is running
+		trial bool
+	}
+)
+
+// SetRestartPolicy
+//
+// Sets how crashing plugins are restarted and when their circuit breakers open.
+func (e *Engine) SetRestartPolicy(policy RestartPolicy) {
+	e.healthMu.Lock()
+	defer e.healthMu.Unlock()
+
+	e.restartPolicy = policy
+}
+
+// SetStateListener
+//
+// Sets the function told about every change in the health of a plugin. It is called synchronously, without any engine
+// lock held, from whichever goroutine made the call that caused the change.
+func (e *Engine) SetStateListener(fn func(PluginStateEvent)) {
+	e.healthMu.Lock()
+	defer e.healthMu.Unlock()
+
+	e.stateListener = fn
+}
+
+// PluginState
+//
+// Returns the state of a plugin version's breaker, or of the breaker of one of its hooks when breakers are per hook.
+func (e *Engine) PluginState(id, version, hook string) PluginState {
+	e.healthMu.Lock()
+	defer e.healthMu.Unlock()
+
+	if b := e.breakers[breakerKey(id, version, hook)]; nil != b && b.state != "" {
+		return b.state
+	}
+
+	return StateRunning
+}
+
+// policy returns the restart policy with its defaults filled in, the caller must hold healthMu
+func (e *Engine) policy() RestartPolicy {
+	policy := e.restartPolicy
+	if policy.Failures == 0 {
+		policy.Failures = defaultBreakerFailures
+	}
+	if policy.OpenFor <= 0 {
+		policy.OpenFor = defaultBreakerOpenFor
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = defaultRestartBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultMaxBackoff
+	}
+
+	return policy
+}
+
+func breakerKey(id, version, hook string) string {
+	return id + "@" + version + "/" + hook
+}
+
+// breakerFor
+//
+// Returns the breaker guarding calls to name on the plugin, creating it on first use, and the hook it is for. The
+// caller must hold healthMu.
+func (e *Engine) breakerFor(p *plugin, name string, policy RestartPolicy) (*breaker, string) {
+	if !policy.PerHook {
+		name = ""
+	}
+
+	key := breakerKey(p.Details.Id, p.Details.Version, name)
+	b := e.breakers[key]
+	if nil == b {
+		b = &breaker{state: StateRunning}
+		e.breakers[key] = b
+	}
+
+	return b, name
+}
+
+// breakerAllow
+//
+// Returns an error wrapping ErrPluginUnavailable when the breaker guarding a call is open, or half-open with its trial
+// call already running. An open breaker whose time is up goes half-open and lets this call through as its trial.
+func (e *Engine) breakerAllow(p *plugin, name string) error {
+	now := e.getClock().Now()
+
+	e.healthMu.Lock()
+	policy := e.policy()
+	if policy.Failures < 0 {
+		e.healthMu.Unlock()
+		return nil
+	}
+
+	b, hook := e.breakerFor(p, name, policy)
+
+	var event *PluginStateEvent
+	var err error
+	switch b.state {
+	case StateOpen:
+		if now.Before(b.openUntil) {
+			err = fmt.Errorf("%w: %s@%s has failed %d times in a row", ErrPluginUnavailable, p.Details.Id, p.Details.Version, b.failures)
+			break
+		}
+		event = e.transition(p, hook, b, StateHalfOpen, nil, now)
+		b.trial = true
+	case StateHalfOpen:
+		if b.trial {
+			err = fmt.Errorf("%w: %s@%s is being retried", ErrPluginUnavailable, p.Details.Id, p.Details.Version)
+			break
+		}
+		b.trial = true
+	}
+	listener := e.stateListener
+	e.healthMu.Unlock()
+
+	notify(listener, event)
+
+	return err
+}
+
+// breakerRecord
+//
+// Records the outcome of a call let through by breakerAllow. err is nil for calls that ran, even if the plugin returned
+// an error, and set for traps and failures to instantiate. A failure opens the breaker once there are enough in a row,
+// or straight away for a half-open trial call. A success closes it.
+func (e *Engine) breakerRecord(p *plugin, name string, err error) {
+	now := e.getClock().Now()
+
+	e.healthMu.Lock()
+	policy := e.policy()
+	if policy.Failures < 0 {
+		e.healthMu.Unlock()
+		return
+	}
+
+	b, hook := e.breakerFor(p, name, policy)
+	wasTrial := b.trial && b.state == StateHalfOpen
+	b.trial = false
+
+	var event *PluginStateEvent
+	if nil != err {
+		b.failures++
+		if wasTrial || (b.state != StateOpen && b.failures >= policy.Failures) {
+			b.openUntil = now.Add(policy.OpenFor)
+			event = e.transition(p, hook, b, StateOpen, err, now)
+		}
+	} else {
+		b.failures = 0
+		if b.state != StateRunning {
+			event = e.transition(p, hook, b, StateRunning, nil, now)
+		}
+	}
+	listener := e.stateListener
+	e.healthMu.Unlock()
+
+	notify(listener, event)
+}
+
+// breakerRelease
+//
+// Ends a call let through by breakerAllow that did not reach the plugin, without counting it either way.
+func (e *Engine) breakerRelease(p *plugin, name string) {
+	e.healthMu.Lock()
+	defer e.healthMu.Unlock()
+
+	if b, _ := e.breakerFor(p, name, e.policy()); b.state == StateHalfOpen {
+		b.trial = false
+	}
+}
+
+// transition
+//
+// Moves a breaker to a new state and returns the event reporting it. The caller must hold healthMu.
+func (e *Engine) transition(p *plugin, hook string, b *breaker, to PluginState, err error, now time.Time) *PluginStateEvent {
+	from := b.state
+	b.state = to
+
+	logln("Plugin ", breakerKey(p.Details.Id, p.Details.Version, hook), " is ", string(to), " (was ", string(from), ")")
+
+	return &PluginStateEvent{
+		Id:      p.Details.Id,
+		Version: p.Details.Version,
+		Hook:    hook,
+		From:    from,
+		To:      to,
+		Err:     err,
+		Time:    now,
+	}
+}
+
+// notify calls the state listener with the event, if there is both
+func notify(listener func(PluginStateEvent), event *PluginStateEvent) {
+	if nil != listener && nil != event {
+		listener(*event)
+	}
+}
+
+// crashed
+//
+// Handles a plugin instance that trapped and was dropped from its pool. The pool creates no instances until the backoff
+// has passed, then the plugin is re-instantiated in the background, backing off further while that fails.
+func (e *Engine) crashed(pool *instancePool, cause error) {
+	clock := e.getClock()
+
+	e.healthMu.Lock()
+	policy := e.policy()
+	listener := e.stateListener
+	e.healthMu.Unlock()
+
+	pool.mu.Lock()
+	pool.crashes++
+	backoff := restartBackoff(policy, pool.crashes)
+	pool.retryAt = clock.Now().Add(backoff)
+	start := !pool.restarting
+	pool.restarting = true
+	pool.mu.Unlock()
+
+	if !start {
+		return
+	}
+
+	p := pool.p
+	logln("Plugin ", p.Details.Id+"@"+p.Details.Version, " crashed, restarting in ", backoff, ": ", cause)
+	notify(listener, &PluginStateEvent{
+		Id: p.Details.Id, Version: p.Details.Version, From: StateRunning, To: StateRestarting, Err: cause, Time: clock.Now(),
+	})
+
+	go func() {
+		for {
+			select {
+			case <-clock.After(backoff):
+			case <-pool.done:
+				return
+			}
+
+			err := pool.fill(e.context)
+
+			pool.mu.Lock()
+			if nil == err {
+				pool.crashes = 0
+				pool.retryAt = time.Time{}
+				pool.restarting = false
+				pool.mu.Unlock()
+
+				notify(listener, &PluginStateEvent{
+					Id: p.Details.Id, Version: p.Details.Version, From: StateRestarting, To: StateRunning, Time: clock.Now(),
+				})
+				return
+			}
+
+			pool.crashes++
+			backoff = restartBackoff(policy, pool.crashes)
+			pool.retryAt = clock.Now().Add(backoff)
+			pool.mu.Unlock()
+
+			logln("Error restarting plugin ", p.Details.Id+"@"+p.Details.Version, ", retrying in ", backoff, ": ", err)
+		}
+	}()
+}
+
+// restartBackoff returns the backoff after the given number of consecutive crashes
+func restartBackoff(policy RestartPolicy, crashes int) time.Duration {
+	backoff := policy.Backoff
+	for i := 1; i < crashes && backoff < policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+
+	return backoff
+}
+
+// isTrap
+//
+// Returns true for call errors that leave the instance unusable: a trap, such as unreachable or an out of bounds memory
+// access, a panic recovered by wazero, or the module exiting.
+func isTrap(err error) bool {
+	if nil == err {
+		return false
+	}
+
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		return true
+	}
+
+	return strings.Contains(err.Error(), "wasm stack trace:")
+}
@@ -0,0 +1,146 @@
+package pluginengine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// a minimal module exporting a "run" function that traps with unreachable
+const trappingModule = "\x00asm\x01\x00\x00\x00" +
+	"\x01\x04\x01\x60\x00\x00" +
+	"\x03\x02\x01\x00" +
+	"\x07\x07\x01\x03run\x00\x00" +
+	"\x0a\x05\x01\x03\x00\x00\x0b"
+
+func TestRestartAndBreaker(t *testing.T) {
+	e := newTestEngine(t)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	e.SetClock(clock)
+	e.SetPoolConfig("test.crashing", PoolConfig{Max: 1, IdleTimeout: -1})
+	e.SetRestartPolicy(RestartPolicy{Failures: 2, OpenFor: time.Minute, Backoff: time.Second})
+
+	mu := sync.Mutex{}
+	states := make([]PluginState, 0)
+	e.SetStateListener(func(event PluginStateEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		states = append(states, event.To)
+	})
+	seen := func(n int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(states) == n
+	}
+
+	p := &plugin{ModuleData: []byte(trappingModule)}
+	e.mu.Lock()
+	e.addPlugin(p, Plugin{Id: "test.crashing", Version: "1.0.0", Stateless: true})
+	e.mu.Unlock()
+
+	call := func() error {
+		_, err := e.callPlugin(e.context, p, "run", "run", nil)
+		return err
+	}
+	restart := func(n int) {
+		waitFor(t, "the restart to wait", func() bool { return clock.Waiters() == 1 })
+		clock.Advance(time.Second)
+		waitFor(t, "the plugin to restart", func() bool { return seen(n) })
+	}
+
+	if err := call(); nil == err || errors.Is(err, ErrPluginUnavailable) {
+		t.Fatalf("Expected the call to trap, got %v", err)
+	}
+	if err := call(); !errors.Is(err, ErrPluginUnavailable) {
+		t.Errorf("Expected the plugin to be unavailable while restarting, got %v", err)
+	}
+	restart(2)
+
+	// the second trap in a row opens the breaker
+	if err := call(); nil == err || errors.Is(err, ErrPluginUnavailable) {
+		t.Fatalf("Expected the call to trap, got %v", err)
+	}
+	if state := e.PluginState("test.crashing", "1.0.0", ""); state != StateOpen {
+		t.Errorf("Expected the breaker to be open, got %s", state)
+	}
+	restart(5)
+	if err := call(); !errors.Is(err, ErrPluginUnavailable) {
+		t.Errorf("Expected the open breaker to fail the call, got %v", err)
+	}
+
+	// the trial call once the breaker has been open long enough traps and opens it again
+	clock.Advance(time.Minute)
+	if err := call(); nil == err || errors.Is(err, ErrPluginUnavailable) {
+		t.Fatalf("Expected the trial call to trap, got %v", err)
+	}
+
+	expected := []PluginState{
+		StateRestarting, StateRunning,
+		StateRestarting, StateOpen, StateRunning,
+		StateHalfOpen, StateRestarting, StateOpen,
+	}
+	mu.Lock()
+	if fmt.Sprint(states) != fmt.Sprint(expected) {
+		t.Errorf("Expected the states %v, got %v", expected, states)
+	}
+	mu.Unlock()
+
+	_ = e.stopPlugin(p)
+}
+
+func TestBreakerCloses(t *testing.T) {
+	e := newTestEngine(t)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	e.SetClock(clock)
+	e.SetRestartPolicy(RestartPolicy{Failures: 1, OpenFor: time.Minute, PerHook: true})
+
+	p := &plugin{Details: Plugin{Id: "test.flaky", Version: "1.0.0"}}
+	e.breakerRecord(p, "a", errors.New("trapped"))
+
+	if err := e.breakerAllow(p, "a"); !errors.Is(err, ErrPluginUnavailable) {
+		t.Errorf("Expected the open breaker to fail the call, got %v", err)
+	}
+	if err := e.breakerAllow(p, "b"); nil != err {
+		t.Errorf("Expected other hooks to have their own breaker, got %v", err)
+	}
+	e.breakerRecord(p, "b", nil)
+
+	clock.Advance(time.Minute)
+	if err := e.breakerAllow(p, "a"); nil != err {
+		t.Fatalf("Expected a trial call, got %v", err)
+	}
+	if err := e.breakerAllow(p, "a"); !errors.Is(err, ErrPluginUnavailable) {
+		t.Errorf("Expected a single trial call at a time, got %v", err)
+	}
+	e.breakerRecord(p, "a", nil)
+
+	if state := e.PluginState("test.flaky", "1.0.0", "a"); state != StateRunning {
+		t.Errorf("Expected a successful trial to close the breaker, got %s", state)
+	}
+}
+
+func TestIsTrap(t *testing.T) {
+	cases := map[error]bool{
+		nil:                  false,
+		errors.New("failed"): false,
+		sys.NewExitError(1):  true,
+		fmt.Errorf("call: %w", sys.NewExitError(0)):                        true,
+		errors.New("wasm error: unreachable\nwasm stack trace:\n\t.run()"): true,
+	}
+	for err, expected := range cases {
+		if isTrap(err) != expected {
+			t.Errorf("isTrap(%v): expected %v", err, expected)
+		}
+	}
+
+	policy := RestartPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for crashes, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 5 * time.Second} {
+		if backoff := restartBackoff(policy, crashes); backoff != expected {
+			t.Errorf("Expected a backoff of %s after %d crashes, got %s", expected, crashes, backoff)
+		}
+	}
+}
@@ -3,7 +3,6 @@ package pluginengine
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	extism "github.com/extism/go-sdk"
 	"io/fs"
 	"os"
@@ -13,7 +12,7 @@ import (
 // This Host function allows a plugin to load a local file via the engine. It will load the local file
 // as a []byte and pass that directly to the calling plugin as part of the response. It is up to the
 // calling plugin to then handle the file contents as needed.
-func load(e Engine) extism.HostFunction {
+func load(e *Engine) extism.HostFunction {
 	ret := extism.NewHostFunctionWithStack(
 		"LoadFile",
 		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
@@ -21,10 +20,10 @@ func load(e Engine) extism.HostFunction {
 
 			if nil != err2 {
 				// TODO: Figure out how to handle this correctly
-				fmt.Println("ERROR CALLING FROM PLUGIN TO HOST getExtensionsForExtensionPoint FUNCTION: ", err2)
+				logln("ERROR CALLING FROM PLUGIN TO HOST getExtensionsForExtensionPoint FUNCTION: ", err2)
 			}
 
-			fmt.Println("Plugin is calling LoadFile with path: ", filePath)
+			logln("Plugin is calling LoadFile with path: ", filePath)
 			dir := filepath.Dir(filePath)
 			filename := filepath.Base(filePath)
 			fsys := os.DirFS(dir)
@@ -33,7 +32,7 @@ func load(e Engine) extism.HostFunction {
 			fileData, err := fs.ReadFile(fsys, filename)
 			if err != nil {
 				// TODO: LOG THIS ERROR SOMEHOW
-				fmt.Println("Problem reading file: ", err)
+				logln("Problem reading file: ", err)
 			}
 
 			// write it back out to the calling plugin, so it can get it as a response to the host func call
@@ -41,7 +40,7 @@ func load(e Engine) extism.HostFunction {
 			stack[0] = ff
 
 			if err != nil {
-				fmt.Println("Error writing bytes: ", err)
+				logln("Error writing bytes: ", err)
 			}
 		},
 		[]extism.ValueType{extism.ValueTypeI64}, []extism.ValueType{extism.ValueTypeI64},
@@ -53,39 +52,43 @@ func load(e Engine) extism.HostFunction {
 
 // This function allows plugin anchor code or other hook code to call a hook function. The hook
 // function can reside in any loaded resolved plugin. It utilizes the Extism/WASM memory stack
-// to pass in parameters expected by the anchor the hook is tied in to.
-func hookCall(e Engine) extism.HostFunction {
+// to pass in parameters expected by the anchor the hook is tied in to. The call counts against the
+// calling plugin's caller limit and is rejected with a CallChainError when it recurses too deep or
+// re-enters a busy plugin. A call that is rejected or fails returns no output.
+func hookCall(e *Engine) extism.HostFunction {
 	ret := extism.NewHostFunctionWithStack(
 		"CallHook",
 		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
 			hkId, err := p.ReadString(stack[0])
 
 			if nil != err {
-				fmt.Println("ERROR CALLING FROM PLUGIN TO HOST getExtensionsForExtensionPoint FUNCTION: ", err)
+				logln("ERROR CALLING FROM PLUGIN TO HOST getExtensionsForExtensionPoint FUNCTION: ", err)
 			}
 
-			fmt.Println("Calling CallHook from plugin for hook id: ", hkId)
+			logln("Calling CallHook from plugin for hook id: ", hkId)
 
 			data, err := p.ReadBytes(stack[1])
 
 			if nil != err {
-				fmt.Println("ERROR READING BYTES OF INPUT DATA")
+				logln("ERROR READING BYTES OF INPUT DATA")
 			}
 
 			if nil != data && len(data) > 0 {
-				fmt.Println("WE GOT DATA.. it should be passed on to the extension to be called")
+				logln("WE GOT DATA.. it should be passed on to the extension to be called")
 			}
 
-			extResp, err := e.CallHookFunc(hkId, data)
+			extResp, err := e.callHook(ctx, hkId, data)
 			if nil != err {
-				fmt.Println("ERROR IN HOST FUNC: ", err)
+				logln("ERROR IN HOST FUNC: ", err)
+				stack[0] = 0
+				return
 			}
 
 			if nil != extResp {
 				ff, err := p.WriteBytes(extResp)
 
 				if err != nil {
-					fmt.Println("Error writing bytes: ", err)
+					logln("Error writing bytes: ", err)
 					return
 				} else {
 					stack[0] = ff
@@ -100,7 +103,7 @@ func hookCall(e Engine) extism.HostFunction {
 	return ret
 }
 
-func hooksForAnchor(e Engine) extism.HostFunction {
+func hooksForAnchor(e *Engine) extism.HostFunction {
 	ret := extism.NewHostFunctionWithStack(
 		"GetHooks",
 		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
@@ -108,15 +111,15 @@ func hooksForAnchor(e Engine) extism.HostFunction {
 			extPtId, err := p.ReadString(stack[0])
 
 			if nil != err {
-				fmt.Println("ERROR CALLING FROM PLUGIN TO HOST getExtensions FUNCTION: ", err)
+				logln("ERROR CALLING FROM PLUGIN TO HOST getExtensions FUNCTION: ", err)
 			}
 
-			fmt.Println("Calling GetExtensions from plugin for extensionPoint id: ", extPtId)
+			logln("Calling GetExtensions from plugin for extensionPoint id: ", extPtId)
 
 			hooks, err := e.GetHooksForAnchor(extPtId)
 
 			if nil != err {
-				fmt.Println("ERROR IN HOST FUNC: ", err)
+				logln("ERROR IN HOST FUNC: ", err)
 			}
 
 			if nil != hooks && len(hooks) > 0 {
@@ -126,7 +129,7 @@ func hooksForAnchor(e Engine) extism.HostFunction {
 				ff, err := p.WriteBytes(jsonBytes)
 
 				if err != nil {
-					fmt.Println("Error writing bytes: ", err)
+					logln("Error writing bytes: ", err)
 					return
 				} else {
 					stack[0] = ff
@@ -143,7 +146,16 @@ func hooksForAnchor(e Engine) extism.HostFunction {
 	return ret
 }
 
-func (e Engine) GetHostFuncs() []extism.HostFunction {
+func (e *Engine) GetHostFuncs() []extism.HostFunction {
 	return []extism.HostFunction{hookCall(e), load(e), hooksForAnchor(e)}
 }
 
+// pluginHostFuncs
+//
+// Returns the host functions that are created for each plugin instance because they depend on which plugin calls them.
+// allowedHosts are the hosts the plugin may reach with HTTPRequest.
+func (e *Engine) pluginHostFuncs(p *plugin, allowedHosts []string) []extism.HostFunction {
+	return []extism.HostFunction{
+		getSecret(e, p), kvGet(e, p), kvSet(e, p), kvDelete(e, p), kvList(e, p), httpRequest(e, p, allowedHosts),
+	}
+}
@@ -0,0 +1,248 @@
+package pluginengine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	extism "github.com/extism/go-sdk"
+)
+
+// the largest response body the HTTPRequest host function hands to a plugin
+const maxHTTPResponseBytes = 50 << 20
+
+type (
+	// HostPolicy
+	//
+	// Decides whether a plugin may reach a host it declares in allowedHosts. host is the entry as declared, so it may be
+	// a pattern such as *.example.com. Without a policy every declared host is denied.
+	HostPolicy func(plugin Plugin, host string) bool
+
+	// HTTPRequest
+	//
+	// The JSON a plugin passes to the HTTPRequest host function. Body is base64 encoded in JSON.
+	HTTPRequest struct {
+		Method  string            `json:"method"`
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Body    []byte            `json:"body,omitempty"`
+	}
+
+	// HTTPResponse
+	//
+	// The JSON the HTTPRequest host function returns to a plugin. Error is set instead of the other fields when the
+	// request was refused or failed.
+	HTTPResponse struct {
+		Status  int               `json:"status,omitempty"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Body    []byte            `json:"body,omitempty"`
+		Error   string            `json:"error,omitempty"`
+	}
+)
+
+// AllowHosts
+//
+// Returns a HostPolicy approving the declared hosts that match one of patterns. Patterns use path.Match syntax, so
+// *.example.com approves api.example.com as well as a plugin declaring *.example.com itself.
+func AllowHosts(patterns ...string) HostPolicy {
+	return func(plugin Plugin, host string) bool {
+		return matchHost(patterns, host)
+	}
+}
+
+// AllowAllHosts is a HostPolicy approving every host plugins declare.
+func AllowAllHosts(plugin Plugin, host string) bool {
+	return true
+}
+
+// SetHostPolicy
+//
+// Sets the policy approving the hosts plugins declare. It is applied when a plugin is instantiated, so a plugin that is
+// already running keeps the hosts it was given until it is reloaded. Declared hosts the policy denies are logged.
+func (e *Engine) SetHostPolicy(policy HostPolicy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.hostPolicy = policy
+}
+
+// SetHTTPTransport
+//
+// Sets the RoundTripper requests made with the HTTPRequest host function go through, http.DefaultTransport when nil.
+// Hosts use it to audit plugin traffic and tests use it to point plugins at an httptest server. Requests plugins make
+// with extism's own http_request function do not go through it.
+func (e *Engine) SetHTTPTransport(rt http.RoundTripper) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.transport = rt
+}
+
+// approvedHosts
+//
+// Returns the hosts a plugin declares that the engine's host policy approves.
+func (e *Engine) approvedHosts(p *plugin) []string {
+	e.mu.RLock()
+	policy := e.hostPolicy
+	e.mu.RUnlock()
+
+	approved := make([]string, 0, len(p.Details.AllowedHosts))
+	for _, host := range p.Details.AllowedHosts {
+		if nil != policy && policy(p.Details, host) {
+			approved = append(approved, host)
+			continue
+		}
+		logln("Host policy denied ", host, " to plugin ", p.Details.Id+"@"+p.Details.Version)
+	}
+
+	return approved
+}
+
+// checkHostPattern
+//
+// Returns an error for allowedHosts entries that are not a host name or pattern.
+func checkHostPattern(host string) error {
+	if host == "" {
+		return errors.New("is empty")
+	}
+	if strings.ContainsAny(host, "/:") {
+		return fmt.Errorf("%q must be a host name without a scheme, port or path", host)
+	}
+	if _, err := path.Match(host, ""); nil != err {
+		return fmt.Errorf("%q is not a valid pattern", host)
+	}
+
+	return nil
+}
+
+// matchHost returns true when host matches one of patterns
+func matchHost(patterns []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// doHTTPRequest
+//
+// Makes a plugin's request through the engine's transport when its host, and the host of every redirect, is one of
+// allowed.
+func (e *Engine) doHTTPRequest(ctx context.Context, allowed []string, req HTTPRequest) (HTTPResponse, error) {
+	u, err := url.Parse(req.URL)
+	if nil != err {
+		return HTTPResponse{}, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return HTTPResponse{}, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if !matchHost(allowed, u.Hostname()) {
+		return HTTPResponse{}, fmt.Errorf("host %s is not allowed", u.Hostname())
+	}
+
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, req.URL, bytes.NewReader(req.Body))
+	if nil != err {
+		return HTTPResponse{}, err
+	}
+	for k, v := range req.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	e.mu.RLock()
+	transport := e.transport
+	e.mu.RUnlock()
+
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			if !matchHost(allowed, r.URL.Hostname()) {
+				return fmt.Errorf("redirect to host %s is not allowed", r.URL.Hostname())
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+
+	resp, err := client.Do(httpReq)
+	if nil != err {
+		return HTTPResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPResponseBytes+1))
+	if nil != err {
+		return HTTPResponse{}, err
+	}
+	if len(body) > maxHTTPResponseBytes {
+		return HTTPResponse{}, fmt.Errorf("response body is larger than %d bytes", maxHTTPResponseBytes)
+	}
+
+	headers := make(map[string]string, len(resp.Header))
+	for k := range resp.Header {
+		headers[k] = resp.Header.Get(k)
+	}
+
+	return HTTPResponse{Status: resp.StatusCode, Headers: headers, Body: body}, nil
+}
+
+// httpRequest
+//
+// This host function makes an HTTP request for a plugin. It takes an HTTPRequest as JSON and returns an HTTPResponse
+// as JSON, with Error set when the request is refused or fails. Only the hosts the plugin declares and the engine's
+// host policy approves at instantiation can be reached.
+func httpRequest(e *Engine, plug *plugin, allowed []string) extism.HostFunction {
+	ret := extism.NewHostFunctionWithStack(
+		"HTTPRequest",
+		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
+			input, err := p.ReadBytes(stack[0])
+			stack[0] = 0
+			if nil != err {
+				logln("Error reading request from plugin: ", plug.Details.Id, err)
+				return
+			}
+
+			req := HTTPRequest{}
+			resp := HTTPResponse{}
+			if err := json.Unmarshal(input, &req); nil != err {
+				resp.Error = err.Error()
+			} else if resp, err = e.doHTTPRequest(ctx, allowed, req); nil != err {
+				logln("HTTP request from plugin failed: ", plug.Details.Id, err)
+				resp = HTTPResponse{Error: err.Error()}
+			}
+
+			jsonBytes, err := json.Marshal(resp)
+			if nil != err {
+				logln("Error marshalling response: ", err)
+				return
+			}
+
+			offset, err := p.WriteBytes(jsonBytes)
+			if nil != err {
+				logln("Error writing bytes: ", err)
+				return
+			}
+			stack[0] = offset
+		},
+		[]extism.ValueType{extism.ValueTypeI64}, []extism.ValueType{extism.ValueTypeI64},
+	)
+	ret.SetNamespace("extism:host/pluginengine")
+
+	return ret
+}
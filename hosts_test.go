@@ -0,0 +1,82 @@
+package pluginengine
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type recordingTransport struct {
+	urls []string
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.urls = append(rt.urls, req.URL.String())
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/plain"}},
+		Body:       io.NopCloser(strings.NewReader("recorded")),
+		Request:    req,
+	}, nil
+}
+
+func TestApprovedHosts(t *testing.T) {
+	e := newTestEngine(t)
+	p := &plugin{Details: Plugin{Id: "test.http", Version: "1.0.0", AllowedHosts: []string{"api.example.com", "*.internal", "other.org"}}}
+
+	if hosts := e.approvedHosts(p); len(hosts) != 0 {
+		t.Errorf("Expected every host to be denied without a policy, got %v", hosts)
+	}
+
+	e.SetHostPolicy(AllowHosts("*.example.com", "*.internal"))
+	if hosts := e.approvedHosts(p); !reflect.DeepEqual(hosts, []string{"api.example.com", "*.internal"}) {
+		t.Errorf("Expected api.example.com and *.internal, got %v", hosts)
+	}
+}
+
+func TestHTTPRequest(t *testing.T) {
+	e := newTestEngine(t)
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://denied.example.com/", http.StatusFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		_, _ = w.Write(append([]byte("echo "), body...))
+	}))
+	defer server.Close()
+
+	resp, err := e.doHTTPRequest(ctx, []string{"127.0.0.1"}, HTTPRequest{Method: "POST", URL: server.URL, Body: []byte("hi")})
+	if err != nil || resp.Status != http.StatusOK || string(resp.Body) != "echo hi" || resp.Headers["X-Method"] != "POST" {
+		t.Errorf("Unexpected response %+v %v", resp, err)
+	}
+
+	if _, err := e.doHTTPRequest(ctx, []string{"example.com"}, HTTPRequest{URL: server.URL}); err == nil {
+		t.Error("Expected a request to a host that is not allowed to fail")
+	}
+	if _, err := e.doHTTPRequest(ctx, []string{"127.0.0.1"}, HTTPRequest{URL: server.URL + "/redirect"}); err == nil || !strings.Contains(err.Error(), "denied.example.com") {
+		t.Errorf("Expected a redirect to a host that is not allowed to fail, got %v", err)
+	}
+	if _, err := e.doHTTPRequest(ctx, []string{"*"}, HTTPRequest{URL: "file:///etc/passwd"}); err == nil {
+		t.Error("Expected a file url to be refused")
+	}
+
+	rt := &recordingTransport{}
+	e.SetHTTPTransport(rt)
+
+	resp, err = e.doHTTPRequest(ctx, []string{"*.example.com"}, HTTPRequest{URL: "https://api.example.com/v1"})
+	if err != nil || string(resp.Body) != "recorded" {
+		t.Errorf("Unexpected response %+v %v", resp, err)
+	}
+	if !reflect.DeepEqual(rt.urls, []string{"https://api.example.com/v1"}) {
+		t.Errorf("Expected the request to go through the transport, got %v", rt.urls)
+	}
+}
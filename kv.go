@@ -0,0 +1,306 @@
+package pluginengine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	extism "github.com/extism/go-sdk"
+)
+
+// the longest key a plugin may store
+const maxKeyLength = 1024
+
+// ErrQuotaExceeded is wrapped by the error returned for writes that would take a plugin over its store quota.
+var ErrQuotaExceeded = errors.New("store quota exceeded")
+
+// StoreQuota
+//
+// Limits how much of the engine's Store a plugin may use. MaxKeys limits the number of keys, MaxBytes the bytes of all
+// its keys and values and MaxValueBytes the size of a single value. A zero limit is unlimited.
+type StoreQuota struct {
+	MaxKeys       int   `json:"maxKeys,omitempty" yaml:"maxKeys,omitempty"`
+	MaxBytes      int64 `json:"maxBytes,omitempty" yaml:"maxBytes,omitempty"`
+	MaxValueBytes int64 `json:"maxValueBytes,omitempty" yaml:"maxValueBytes,omitempty"`
+}
+
+// SetStore
+//
+// Sets the Store backing the KV host functions. The default is a MemoryStore.
+func (e *Engine) SetStore(s Store) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.store = s
+}
+
+// SetStoreQuota
+//
+// Sets the store quota of the plugin with the given id, shared by all its versions as they share a namespace. An empty
+// id sets the quota of plugins that have none of their own. Lowering a quota below what a plugin already uses does not
+// remove anything, it only fails further writes that would grow it.
+func (e *Engine) SetStoreQuota(id string, q StoreQuota) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.storeQuotas[id] = q
+}
+
+// StoreUsage
+//
+// Returns how much of the engine's Store the plugin with the given id uses.
+func (e *Engine) StoreUsage(id string) (StoreUsage, error) {
+	store, _ := e.storeFor(id)
+	return store.Usage(e.context, id)
+}
+
+// storeFor
+//
+// Returns the engine's store and the quota of the plugin with the given id.
+func (e *Engine) storeFor(id string) (Store, StoreQuota) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	q, ok := e.storeQuotas[id]
+	if !ok {
+		q = e.storeQuotas[""]
+	}
+
+	return e.store, q
+}
+
+// storeSet
+//
+// Stores a value in a plugin's namespace after checking the write keeps it within its quota.
+func (e *Engine) storeSet(ctx context.Context, id, key string, value []byte) error {
+	if err := checkKey(key); nil != err {
+		return err
+	}
+
+	store, q := e.storeFor(id)
+
+	if q.MaxValueBytes > 0 && int64(len(value)) > q.MaxValueBytes {
+		return fmt.Errorf("%w: value of %d bytes is over the %d byte limit", ErrQuotaExceeded, len(value), q.MaxValueBytes)
+	}
+
+	e.storeMu.Lock()
+	defer e.storeMu.Unlock()
+
+	if q.MaxKeys > 0 || q.MaxBytes > 0 {
+		usage, err := store.Usage(ctx, id)
+		if nil != err {
+			return err
+		}
+
+		old, exists, err := store.Get(ctx, id, key)
+		if nil != err {
+			return err
+		}
+
+		keys, bytes := usage.Keys, usage.Bytes+int64(len(value))
+		if exists {
+			bytes -= int64(len(old))
+		} else {
+			keys++
+			bytes += int64(len(key))
+		}
+
+		if q.MaxKeys > 0 && keys > q.MaxKeys {
+			return fmt.Errorf("%w: %d keys is over the limit of %d", ErrQuotaExceeded, keys, q.MaxKeys)
+		}
+		if q.MaxBytes > 0 && bytes > q.MaxBytes {
+			return fmt.Errorf("%w: %d bytes is over the limit of %d", ErrQuotaExceeded, bytes, q.MaxBytes)
+		}
+	}
+
+	return store.Set(ctx, id, key, value)
+}
+
+// storeDelete
+//
+// Removes a key from a plugin's namespace.
+func (e *Engine) storeDelete(ctx context.Context, id, key string) error {
+	if err := checkKey(key); nil != err {
+		return err
+	}
+
+	store, _ := e.storeFor(id)
+
+	e.storeMu.Lock()
+	defer e.storeMu.Unlock()
+
+	return store.Delete(ctx, id, key)
+}
+
+// checkKey
+//
+// Returns an error for keys plugins may not use.
+func checkKey(key string) error {
+	if key == "" {
+		return errors.New("key is empty")
+	}
+	if len(key) > maxKeyLength {
+		return fmt.Errorf("key is longer than %d bytes", maxKeyLength)
+	}
+
+	return nil
+}
+
+// writeKVError
+//
+// Hands a failed KV write back to the plugin as the offset of its error message, logging it as well.
+func writeKVError(p *extism.CurrentPlugin, plug *plugin, stack []uint64, err error) {
+	logln("KV error for plugin: ", plug.Details.Id, err)
+
+	offset, werr := p.WriteString(err.Error())
+	if nil != werr {
+		logln("Error writing bytes: ", werr)
+		stack[0] = 0
+		return
+	}
+	stack[0] = offset
+}
+
+// kvGet
+//
+// This host function returns the value of a key in the calling plugin's namespace, or 0 if it does not exist. It is
+// created per plugin as the namespace is the plugin id.
+func kvGet(e *Engine, plug *plugin) extism.HostFunction {
+	ret := extism.NewHostFunctionWithStack(
+		"KVGet",
+		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
+			key, err := p.ReadString(stack[0])
+			stack[0] = 0
+			if nil != err {
+				logln("Error reading key from plugin: ", plug.Details.Id, err)
+				return
+			}
+
+			store, _ := e.storeFor(plug.Details.Id)
+
+			value, ok, err := store.Get(ctx, plug.Details.Id, key)
+			if nil != err {
+				logln("KV error for plugin: ", plug.Details.Id, err)
+				return
+			}
+			if !ok {
+				return
+			}
+
+			offset, err := p.WriteBytes(value)
+			if nil != err {
+				logln("Error writing bytes: ", err)
+				return
+			}
+			stack[0] = offset
+		},
+		[]extism.ValueType{extism.ValueTypeI64}, []extism.ValueType{extism.ValueTypeI64},
+	)
+	ret.SetNamespace("extism:host/pluginengine")
+
+	return ret
+}
+
+// kvSet
+//
+// This host function stores a value under a key in the calling plugin's namespace. It returns 0 on success, otherwise
+// the offset of an error message, which for writes over the plugin's quota wraps ErrQuotaExceeded.
+func kvSet(e *Engine, plug *plugin) extism.HostFunction {
+	ret := extism.NewHostFunctionWithStack(
+		"KVSet",
+		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
+			key, err := p.ReadString(stack[0])
+			if nil != err {
+				writeKVError(p, plug, stack, err)
+				return
+			}
+
+			value, err := p.ReadBytes(stack[1])
+			if nil != err {
+				writeKVError(p, plug, stack, err)
+				return
+			}
+
+			if err := e.storeSet(ctx, plug.Details.Id, key, value); nil != err {
+				writeKVError(p, plug, stack, err)
+				return
+			}
+			stack[0] = 0
+		},
+		[]extism.ValueType{extism.ValueTypeI64, extism.ValueTypeI64}, []extism.ValueType{extism.ValueTypeI64},
+	)
+	ret.SetNamespace("extism:host/pluginengine")
+
+	return ret
+}
+
+// kvDelete
+//
+// This host function removes a key from the calling plugin's namespace. It returns 0 on success, otherwise the offset
+// of an error message.
+func kvDelete(e *Engine, plug *plugin) extism.HostFunction {
+	ret := extism.NewHostFunctionWithStack(
+		"KVDelete",
+		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
+			key, err := p.ReadString(stack[0])
+			if nil != err {
+				writeKVError(p, plug, stack, err)
+				return
+			}
+
+			if err := e.storeDelete(ctx, plug.Details.Id, key); nil != err {
+				writeKVError(p, plug, stack, err)
+				return
+			}
+			stack[0] = 0
+		},
+		[]extism.ValueType{extism.ValueTypeI64}, []extism.ValueType{extism.ValueTypeI64},
+	)
+	ret.SetNamespace("extism:host/pluginengine")
+
+	return ret
+}
+
+// kvList
+//
+// This host function returns the keys in the calling plugin's namespace that start with a prefix as a sorted JSON
+// array, or 0 if listing fails.
+func kvList(e *Engine, plug *plugin) extism.HostFunction {
+	ret := extism.NewHostFunctionWithStack(
+		"KVList",
+		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
+			prefix, err := p.ReadString(stack[0])
+			stack[0] = 0
+			if nil != err {
+				logln("Error reading prefix from plugin: ", plug.Details.Id, err)
+				return
+			}
+
+			store, _ := e.storeFor(plug.Details.Id)
+
+			keys, err := store.List(ctx, plug.Details.Id, prefix)
+			if nil != err {
+				logln("KV error for plugin: ", plug.Details.Id, err)
+				return
+			}
+
+			jsonBytes, err := json.Marshal(keys)
+			if nil != err {
+				logln("Error marshalling keys: ", err)
+				return
+			}
+
+			offset, err := p.WriteBytes(jsonBytes)
+			if nil != err {
+				logln("Error writing bytes: ", err)
+				return
+			}
+			stack[0] = offset
+		},
+		[]extism.ValueType{extism.ValueTypeI64}, []extism.ValueType{extism.ValueTypeI64},
+	)
+	ret.SetNamespace("extism:host/pluginengine")
+
+	return ret
+}
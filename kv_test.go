@@ -0,0 +1,126 @@
+package pluginengine
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+	fileStore, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "file": fileStore} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			for _, key := range []string{"b", "a/1", "a/2"} {
+				if err := store.Set(ctx, "p1", key, []byte("value "+key)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := store.Set(ctx, "p2", "a/3", []byte("other")); err != nil {
+				t.Fatal(err)
+			}
+
+			value, ok, err := store.Get(ctx, "p1", "a/1")
+			if err != nil || !ok || string(value) != "value a/1" {
+				t.Errorf("Expected value a/1, got %q %v %v", value, ok, err)
+			}
+			if _, ok, _ := store.Get(ctx, "p2", "a/1"); ok {
+				t.Error("Expected namespaces to be separate")
+			}
+
+			keys, err := store.List(ctx, "p1", "a/")
+			if err != nil || !reflect.DeepEqual(keys, []string{"a/1", "a/2"}) {
+				t.Errorf("Expected [a/1 a/2], got %v %v", keys, err)
+			}
+
+			usage, err := store.Usage(ctx, "p1")
+			if err != nil || usage.Keys != 3 || usage.Bytes != 7+int64(len("value a/1"))*2+int64(len("value b")) {
+				t.Errorf("Unexpected usage %+v %v", usage, err)
+			}
+
+			if err := store.Delete(ctx, "p1", "a/1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete(ctx, "p1", "missing"); err != nil {
+				t.Errorf("Expected deleting a missing key to succeed, got %v", err)
+			}
+			if _, ok, _ := store.Get(ctx, "p1", "a/1"); ok {
+				t.Error("Expected a/1 to be deleted")
+			}
+
+			// the longest key allowed does not fit in a file name
+			long := strings.Repeat("k", maxKeyLength)
+			if err := store.Set(ctx, "p3", long, []byte("long\nvalue")); err != nil {
+				t.Fatal(err)
+			}
+			if value, ok, err := store.Get(ctx, "p3", long); err != nil || !ok || string(value) != "long\nvalue" {
+				t.Errorf("Expected the long key's value, got %q %v %v", value, ok, err)
+			}
+			if keys, err := store.List(ctx, "p3", "k"); err != nil || !reflect.DeepEqual(keys, []string{long}) {
+				t.Errorf("Expected the long key to be listed, got %d keys %v", len(keys), err)
+			}
+			if usage, err := store.Usage(ctx, "p3"); err != nil || usage.Keys != 1 || usage.Bytes != int64(maxKeyLength+len("long\nvalue")) {
+				t.Errorf("Unexpected usage of the long key %+v %v", usage, err)
+			}
+			if err := store.Delete(ctx, "p3", long); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok, _ := store.Get(ctx, "p3", long); ok {
+				t.Error("Expected the long key to be deleted")
+			}
+		})
+	}
+}
+
+func TestStoreQuota(t *testing.T) {
+	e := newTestEngine(t)
+	ctx := context.Background()
+
+	e.SetStoreQuota("", StoreQuota{MaxKeys: 2})
+	e.SetStoreQuota("big", StoreQuota{MaxBytes: 10, MaxValueBytes: 8})
+
+	if err := e.storeSet(ctx, "small", "a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.storeSet(ctx, "small", "b", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.storeSet(ctx, "small", "a", []byte("overwrite")); err != nil {
+		t.Errorf("Expected overwriting a key to stay within the key quota, got %v", err)
+	}
+	if err := e.storeSet(ctx, "small", "c", []byte("3")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded for a third key, got %v", err)
+	}
+
+	if err := e.storeSet(ctx, "big", "k", []byte("123456789")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded for a value over MaxValueBytes, got %v", err)
+	}
+	if err := e.storeSet(ctx, "big", "k", []byte("12345678")); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.storeSet(ctx, "big", "j", []byte("1")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded over MaxBytes, got %v", err)
+	}
+	if err := e.storeDelete(ctx, "big", "k"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.storeSet(ctx, "big", "j", []byte("1")); err != nil {
+		t.Errorf("Expected the write to fit after deleting, got %v", err)
+	}
+
+	usage, err := e.StoreUsage("big")
+	if err != nil || usage != (StoreUsage{Keys: 1, Bytes: 2}) {
+		t.Errorf("Unexpected usage %+v %v", usage, err)
+	}
+
+	if err := e.storeSet(ctx, "small", "", []byte("1")); err == nil {
+		t.Error("Expected an empty key to be refused")
+	}
+}
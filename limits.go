@@ -0,0 +1,171 @@
+package pluginengine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRateLimited is wrapped by the errors returned for hook calls rejected by a rate limit
+	ErrRateLimited = errors.New("rate limit exceeded")
+	// ErrTooManyInFlight is wrapped by the errors returned for hook calls rejected by a max in-flight limit
+	ErrTooManyInFlight = errors.New("too many calls in flight")
+)
+
+// the scopes a Limit is set for, prefixed to the id in the limit keys
+const (
+	limitPlugin = "plugin"
+	limitHook   = "hook"
+	limitCaller = "caller"
+)
+
+type (
+	// Limit
+	//
+	// Admission control for hook calls. Rate is the number of calls per second let through on average and Burst how
+	// many may be made at once after a quiet spell, a token bucket of Burst tokens refilled at Rate. A Burst of 0 is
+	// Rate rounded up, at least 1. MaxInFlight is how many calls may run at the same time. Zero values are unlimited.
+	Limit struct {
+		Rate        float64 `json:"rate" yaml:"rate"`
+		Burst       int     `json:"burst" yaml:"burst"`
+		MaxInFlight int     `json:"maxInFlight" yaml:"maxInFlight"`
+	}
+
+	// bucket is the state of a Limit, kept while the limit is set or calls it admitted are in flight
+	bucket struct {
+		tokens   float64
+		last     time.Time
+		inflight int
+	}
+)
+
+// SetPluginLimit
+//
+// Limits the calls to the hooks of a plugin, all versions counted together. A zero Limit removes the limit.
+func (e *Engine) SetPluginLimit(id string, limit Limit) {
+	e.setLimit(limitPlugin, id, limit)
+}
+
+// SetHookLimit
+//
+// Limits the calls to a hook. A zero Limit removes the limit.
+func (e *Engine) SetHookLimit(hookId string, limit Limit) {
+	e.setLimit(limitHook, hookId, limit)
+}
+
+// SetCallerLimit
+//
+// Limits the hook calls a plugin makes with the CallHook host function, all versions counted together. The id "" limits
+// the calls the host makes with CallHookFunc. A zero Limit removes the limit.
+func (e *Engine) SetCallerLimit(id string, limit Limit) {
+	e.setLimit(limitCaller, id, limit)
+}
+
+func (e *Engine) setLimit(scope, id string, limit Limit) {
+	now := e.getClock().Now()
+
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+
+	key := scope + ":" + id
+	if limit == (Limit{}) {
+		delete(e.limits, key)
+		if b := e.buckets[key]; nil != b && b.inflight == 0 {
+			delete(e.buckets, key)
+		}
+		return
+	}
+
+	e.limits[key] = limit
+	if b := e.buckets[key]; nil != b {
+		// a new rate starts from a full bucket, calls already in flight still count
+		b.tokens = float64(limit.burst())
+		b.last = now
+	}
+}
+
+// burst returns the size of the limit's token bucket
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+
+	return int(math.Max(1, math.Ceil(l.Rate)))
+}
+
+// acquireLimits
+//
+// Admits a call from caller, "" for the host, to a hook of plugin, or returns an error wrapping ErrRateLimited or
+// ErrTooManyInFlight naming the limit that rejected it. Either every limit admits the call or none is charged for it.
+// The returned function must be called when the call is done.
+func (e *Engine) acquireLimits(caller, plugin, hookId string) (func(), error) {
+	now := e.getClock().Now()
+
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+
+	keys := make([]string, 0, 3)
+	for _, key := range []string{limitCaller + ":" + caller, limitPlugin + ":" + plugin, limitHook + ":" + hookId} {
+		limit, ok := e.limits[key]
+		if !ok {
+			continue
+		}
+
+		b := e.buckets[key]
+		if nil == b {
+			b = &bucket{tokens: float64(limit.burst()), last: now}
+			e.buckets[key] = b
+		}
+
+		if limit.MaxInFlight > 0 && b.inflight >= limit.MaxInFlight {
+			return nil, fmt.Errorf("%w: %s has %d calls in flight", ErrTooManyInFlight, limitName(key), b.inflight)
+		}
+
+		if limit.Rate > 0 {
+			if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+				b.tokens = math.Min(float64(limit.burst()), b.tokens+elapsed*limit.Rate)
+			}
+			b.last = now
+
+			if b.tokens < 1 {
+				return nil, fmt.Errorf("%w: %s allows %g calls per second", ErrRateLimited, limitName(key), limit.Rate)
+			}
+		}
+
+		keys = append(keys, key)
+	}
+
+	for _, key := range keys {
+		b := e.buckets[key]
+		if e.limits[key].Rate > 0 {
+			b.tokens--
+		}
+		b.inflight++
+	}
+
+	return func() {
+		e.limitMu.Lock()
+		defer e.limitMu.Unlock()
+
+		for _, key := range keys {
+			b := e.buckets[key]
+			b.inflight--
+			if _, ok := e.limits[key]; !ok && b.inflight == 0 {
+				delete(e.buckets, key)
+			}
+		}
+	}, nil
+}
+
+// limitName describes the limit with the key for errors
+func limitName(key string) string {
+	scope, id, _ := strings.Cut(key, ":")
+	if scope == limitCaller && id == "" {
+		return "the host"
+	}
+
+	return scope + " " + id
+}
@@ -0,0 +1,62 @@
+package pluginengine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	e := newTestEngine(t)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	e.SetClock(clock)
+
+	e.SetHookLimit("test.limited.hook", Limit{Rate: 2})
+	e.SetPluginLimit("test.limited", Limit{MaxInFlight: 2})
+	e.SetCallerLimit("test.caller", Limit{Rate: 1, Burst: 3})
+
+	// the hook's bucket holds two calls
+	first, err := e.acquireLimits("", "test.limited", "test.limited.hook")
+	if nil != err {
+		t.Fatal(err)
+	}
+	second, err := e.acquireLimits("test.caller", "test.limited", "test.limited.hook")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, err := e.acquireLimits("", "test.limited", "test.other"); !errors.Is(err, ErrTooManyInFlight) {
+		t.Errorf("Expected the plugin's in-flight limit to reject the call, got %v", err)
+	}
+
+	first()
+	if _, err := e.acquireLimits("test.caller", "test.limited", "test.limited.hook"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected the hook's rate limit to reject the call, got %v", err)
+	}
+	second()
+
+	// the rejected call was not charged to the caller, which has two of its three tokens left
+	for i := 0; i < 2; i++ {
+		release, err := e.acquireLimits("test.caller", "test.other", "test.other.hook")
+		if nil != err {
+			t.Fatalf("Expected call %d to be admitted, got %v", i, err)
+		}
+		release()
+	}
+	if _, err := e.acquireLimits("test.caller", "test.other", "test.other.hook"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected the caller's rate limit to reject the call, got %v", err)
+	}
+
+	clock.Advance(time.Second)
+	release, err := e.acquireLimits("test.caller", "test.limited", "test.limited.hook")
+	if nil != err {
+		t.Fatalf("Expected the buckets to refill, got %v", err)
+	}
+	release()
+
+	e.SetHookLimit("test.limited.hook", Limit{})
+	e.SetPluginLimit("test.limited", Limit{})
+	e.SetCallerLimit("test.caller", Limit{})
+	if len(e.buckets) != 0 {
+		t.Errorf("Expected removing the limits to drop their buckets, got %d", len(e.buckets))
+	}
+}
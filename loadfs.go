@@ -0,0 +1,331 @@
+package pluginengine
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// limits on what an archive loaded with LoadArchive may hold, as it is read into memory
+const (
+	maxArchiveSize    = 512 << 20
+	maxArchiveEntries = 10000
+)
+
+// ErrArchiveTooLarge is wrapped by the errors returned for archives holding more than the engine reads into memory
+var ErrArchiveTooLarge = errors.New("plugin archive too large")
+
+// readPluginsFS
+//
+// This function finds the plugin manifests in fsys, files named plugin.yaml or plugin.json, and resolves each plugin's
+// modules. Every directory holding a manifest is a plugin, so an archive can bundle several plugins each in its own
+// directory. The plugins are returned with their module paths set to paths within fsys. Manifests are strictly parsed
+// (see ParseManifest), and an invalid manifest, a directory with both a plugin.yaml and a plugin.json, a module that
+// can not be resolved or the same plugin version twice fails the whole of fsys.
+func readPluginsFS(fsys fs.FS) ([]*plugin, error) {
+	files := make([]string, 0)
+	manifestDirs := make(map[string]string)
+
+	err := fs.WalkDir(fsys, ".", func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() || !isManifestName(p) {
+			return nil
+		}
+
+		if other, ok := manifestDirs[path.Dir(p)]; ok {
+			return fmt.Errorf("%s and %s: a plugin has a plugin.yaml or a plugin.json, not both", other, p)
+		}
+
+		manifestDirs[path.Dir(p)] = p
+		files = append(files, p)
+		return nil
+	})
+
+	if nil != err {
+		return nil, err
+	}
+
+	plugs := make([]*plugin, 0)
+	seen := make(map[string]string)
+	for _, f := range files {
+		// read the bytes of the configuration file in
+		data, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := ParseManifest(f, data)
+		if nil != err {
+			return nil, err
+		}
+
+		key := p.Id + "@" + p.Version
+		if other, ok := seen[key]; ok {
+			return nil, fmt.Errorf("%s and %s both declare plugin %s", other, f, key)
+		}
+		seen[key] = f
+
+		plug := &plugin{
+			Details:  p,
+			Resolved: false,
+		}
+
+		if err := resolveModules(fsys, path.Dir(f), manifestDirs, plug); nil != err {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+
+		plugs = append(plugs, plug)
+	}
+
+	return plugs, nil
+}
+
+// resolveModules
+//
+// Sets the module paths of a plugin whose manifest is in dir. Modules named in the manifest are resolved relative to
+// dir. A manifest that names none must have exactly one .wasm module under dir, not counting the directories of other
+// plugins bundled inside it. Modules must be regular files reached through directories, symbolic links are refused.
+func resolveModules(fsys fs.FS, dir string, manifestDirs map[string]string, plug *plugin) error {
+	modules := plug.Details.Modules
+
+	if len(modules) == 0 {
+		found := make([]string, 0)
+		err := fs.WalkDir(fsys, dir, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if entry.IsDir() {
+				if _, ok := manifestDirs[p]; ok && p != dir {
+					return fs.SkipDir
+				}
+				return nil
+			}
+
+			if strings.HasSuffix(p, ".wasm") {
+				if !entry.Type().IsRegular() {
+					return fmt.Errorf("module %s: %w", p, ErrNotRegularFile)
+				}
+				found = append(found, p)
+			}
+			return nil
+		})
+
+		if nil != err {
+			return err
+		}
+
+		switch len(found) {
+		case 0:
+			return errors.New("no .wasm module found next to the plugin manifest")
+		case 1:
+			plug.PathToModule = found[0]
+			return nil
+		default:
+			return fmt.Errorf("found %s, name the plugin's modules with the modules field", strings.Join(found, ", "))
+		}
+	}
+
+	for _, m := range modules {
+		p := path.Join(dir, m.Path)
+		mode, err := entryType(fsys, p)
+		if nil != err {
+			return fmt.Errorf("module %s not found", m.Path)
+		}
+		if !mode.IsRegular() {
+			return fmt.Errorf("module %s: %w", m.Path, ErrNotRegularFile)
+		}
+
+		if len(modules) == 1 || m.Name == mainModuleName {
+			plug.PathToModule = p
+		} else {
+			plug.Linked = append(plug.Linked, linkedModule{Name: m.Name, Path: p})
+		}
+	}
+
+	return nil
+}
+
+// entryType
+//
+// Returns the type of the entry at p in fsys without following symbolic links, neither for the entry itself nor for the
+// directories leading up to it. A path through anything other than a directory is not found.
+func entryType(fsys fs.FS, p string) (fs.FileMode, error) {
+	dir, mode := ".", fs.ModeDir
+	for _, elem := range strings.Split(p, "/") {
+		if !mode.IsDir() {
+			return 0, &fs.PathError{Op: "stat", Path: p, Err: fs.ErrNotExist}
+		}
+
+		entries, err := fs.ReadDir(fsys, dir)
+		if nil != err {
+			return 0, err
+		}
+
+		i := sort.Search(len(entries), func(i int) bool { return entries[i].Name() >= elem })
+		if i == len(entries) || entries[i].Name() != elem {
+			return 0, &fs.PathError{Op: "stat", Path: p, Err: fs.ErrNotExist}
+		}
+
+		dir, mode = path.Join(dir, elem), entries[i].Type()
+	}
+
+	return mode, nil
+}
+
+// loadPluginsFS
+//
+// Reads the plugins in fsys along with their module bytes, so nothing has to be extracted to disk.
+func loadPluginsFS(fsys fs.FS) ([]*plugin, error) {
+	plugs, err := readPluginsFS(fsys)
+	if nil != err {
+		return nil, err
+	}
+
+	for _, plug := range plugs {
+		data, err := fs.ReadFile(fsys, plug.PathToModule)
+		if nil != err {
+			return nil, err
+		}
+
+		plug.ModuleData = data
+
+		for i := range plug.Linked {
+			if plug.Linked[i].Data, err = fs.ReadFile(fsys, plug.Linked[i].Path); nil != err {
+				return nil, err
+			}
+		}
+	}
+
+	return plugs, nil
+}
+
+// LoadFS
+//
+// This method loads the plugins found in fsys, for example plugins embedded in the host binary with go:embed. The
+// manifests and modules are read straight from fsys and the module bytes are handed to extism, nothing is written to
+// the engine's plugin output path.
+func (e *Engine) LoadFS(fsys fs.FS) (err error) {
+	ctx, span := e.startSpan(e.context, "pluginengine.load")
+	defer func() { endSpan(span, err) }()
+
+	plugs, err := loadPluginsFS(fsys)
+	if nil != err {
+		return err
+	}
+
+	if err := e.admit(fsys, "", plugs); nil != err {
+		return err
+	}
+
+	e.register(plugs)
+	e.precompile(ctx, plugs)
+	return nil
+}
+
+// LoadArchive
+//
+// This method loads the plugins in a .zip or .tar.gz archive held in r, which is size bytes long. The format is
+// detected from the archive contents. Like LoadFS nothing is extracted to disk.
+func (e *Engine) LoadArchive(r io.ReaderAt, size int64) (err error) {
+	ctx, span := e.startSpan(e.context, "pluginengine.load")
+	defer func() { endSpan(span, err) }()
+
+	fsys, err := archiveFS(r, size)
+	if nil != err {
+		return err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); nil != err {
+		return err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	plugs, err := loadPluginsFS(fsys)
+	if nil != err {
+		return err
+	}
+
+	for _, plug := range plugs {
+		plug.SourceHash = hash
+	}
+
+	if err := e.admit(fsys, "", plugs); nil != err {
+		return err
+	}
+
+	e.register(plugs)
+	e.precompile(ctx, plugs)
+	return nil
+}
+
+// register
+//
+// Adds the plugins to the engine, resolves them and settles their extraction, if they were extracted.
+func (e *Engine) register(plugs []*plugin) {
+	e.mu.Lock()
+	for _, plug := range plugs {
+		e.addPlugin(plug, plug.Details)
+	}
+
+	e.resolve()
+	e.mu.Unlock()
+
+	e.settle(plugs)
+}
+
+// archiveFS
+//
+// Opens a .zip or .tar.gz archive held in r as an fs.FS. Zip archives are read in place, tar.gz archives are
+// decompressed into memory as they can not be read randomly. Either fails with ErrArchiveTooLarge when it holds more
+// than maxArchiveEntries entries or maxArchiveSize bytes uncompressed.
+func archiveFS(r io.ReaderAt, size int64) (fs.FS, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); nil != err {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK")):
+		zipReader, err := zip.NewReader(r, size)
+		if nil != err {
+			return nil, err
+		}
+
+		// the zip reader fails reading an entry larger than its header says, so the headers can be trusted
+		if len(zipReader.File) > maxArchiveEntries {
+			return nil, fmt.Errorf("%w: more than %d entries", ErrArchiveTooLarge, maxArchiveEntries)
+		}
+		total := uint64(0)
+		for _, f := range zipReader.File {
+			if total += f.UncompressedSize64; total > maxArchiveSize {
+				return nil, fmt.Errorf("%w: more than %d bytes", ErrArchiveTooLarge, maxArchiveSize)
+			}
+		}
+
+		return zipReader, nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gzipReader, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if nil != err {
+			return nil, err
+		}
+		defer gzipReader.Close()
+
+		return untarFS(gzipReader, maxArchiveSize, maxArchiveEntries)
+	}
+
+	return nil, errors.New("unsupported plugin archive format, expected .zip or .tar.gz")
+}
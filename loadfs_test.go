@@ -0,0 +1,211 @@
+package pluginengine
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+var testArchiveFiles = map[string]string{
+	"memory/plugin.yaml": "id: test.memory\nversion: 1.0.0\n",
+	"memory/plugin.wasm": "\x00asm\x01\x00\x00\x00",
+}
+
+func TestLoadFS(t *testing.T) {
+	e, err := NewPluginEngine(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := fstest.MapFS{}
+	for name, contents := range testArchiveFiles {
+		fsys[name] = &fstest.MapFile{Data: []byte(contents)}
+	}
+
+	if err := e.LoadFS(fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	assertModuleData(t, e, "test.memory")
+}
+
+func TestLoadArchive(t *testing.T) {
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for name, contents := range testArchiveFiles {
+		w, _ := zw.Create(name)
+		_, _ = w.Write([]byte(contents))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var tarred bytes.Buffer
+	gw := gzip.NewWriter(&tarred)
+	tw := tar.NewWriter(gw)
+	for name, contents := range testArchiveFiles {
+		_ = tw.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg})
+		_, _ = tw.Write([]byte(contents))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for format, data := range map[string][]byte{"zip": zipped.Bytes(), "tar.gz": tarred.Bytes()} {
+		e, err := NewPluginEngine(nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := e.LoadArchive(bytes.NewReader(data), int64(len(data))); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		assertModuleData(t, e, "test.memory")
+	}
+
+	e, _ := NewPluginEngine(nil, "")
+	if err := e.LoadArchive(bytes.NewReader([]byte("not an archive")), 14); err == nil {
+		t.Errorf("Expected an error loading an unsupported archive")
+	}
+}
+
+func TestUntarFS(t *testing.T) {
+	var tarred bytes.Buffer
+	tw := tar.NewWriter(&tarred)
+	for name, contents := range testArchiveFiles {
+		_ = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg})
+		_, _ = tw.Write([]byte(contents))
+	}
+	_ = tw.Close()
+
+	data := tarred.Bytes()
+	fsys, err := untarFS(bytes.NewReader(data), maxArchiveSize, maxArchiveEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fstest.TestFS(fsys, "memory/plugin.yaml", "memory/plugin.wasm"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := untarFS(bytes.NewReader(data), maxArchiveSize, len(testArchiveFiles)-1); !errors.Is(err, ErrArchiveTooLarge) {
+		t.Errorf("Expected ErrArchiveTooLarge for too many entries, got %v", err)
+	}
+	if _, err := untarFS(bytes.NewReader(data), 8, maxArchiveEntries); !errors.Is(err, ErrArchiveTooLarge) {
+		t.Errorf("Expected ErrArchiveTooLarge for too many bytes, got %v", err)
+	}
+}
+
+func TestZipArchiveTooLarge(t *testing.T) {
+	// an entry claiming to inflate to far more than it holds, read from its header before anything is inflated
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	w, err := zw.CreateRaw(&zip.FileHeader{Name: "bomb/plugin.wasm", Method: zip.Deflate, UncompressedSize64: maxArchiveSize + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte{0x03, 0x00})
+	_ = zw.Close()
+
+	data := zipped.Bytes()
+	if _, err := archiveFS(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrArchiveTooLarge) {
+		t.Errorf("Expected ErrArchiveTooLarge, got %v", err)
+	}
+}
+
+func assertModuleData(t *testing.T, e *Engine, id string) {
+	t.Helper()
+
+	p := e.plugins[id]["1.0.0"]
+	if nil == p {
+		t.Fatalf("Expected plugin %s to be loaded", id)
+	}
+	if !bytes.Equal(p.ModuleData, []byte(testArchiveFiles["memory/plugin.wasm"])) {
+		t.Errorf("Expected plugin %s to carry its module bytes", id)
+	}
+}
+
+func TestReadPluginsFSBundle(t *testing.T) {
+	fsys := fstest.MapFS{
+		"menu/plugin.yaml":        {Data: []byte("id: test.menu\nversion: 1.0.0\n")},
+		"menu/menu.wasm":          {Data: []byte("\x00asm")},
+		"menu/nested/plugin.yaml": {Data: []byte("id: test.nested\nversion: 1.0.0\n")},
+		"menu/nested/nested.wasm": {Data: []byte("\x00asm")},
+		"linked/plugin.yaml":      {Data: []byte("id: test.linked\nversion: 1.0.0\nmodules:\n  - name: main\n    path: app.wasm\n  - name: lib\n    path: lib/helper.wasm\n")},
+		"linked/app.wasm":         {Data: []byte("\x00asm")},
+		"linked/lib/helper.wasm":  {Data: []byte("\x00asm")},
+		"linked/lib/unused.wasm":  {Data: []byte("\x00asm")},
+	}
+
+	plugs, err := loadPluginsFS(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modules := make(map[string]string)
+	for _, plug := range plugs {
+		modules[plug.Details.Id] = plug.PathToModule
+		if plug.Details.Id == "test.linked" {
+			if len(plug.Linked) != 1 || plug.Linked[0].Name != "lib" || plug.Linked[0].Path != "linked/lib/helper.wasm" || nil == plug.Linked[0].Data {
+				t.Errorf("Expected helper.wasm to be linked as lib, got %+v", plug.Linked)
+			}
+		}
+	}
+
+	expected := map[string]string{
+		"test.menu":   "menu/menu.wasm",
+		"test.nested": "menu/nested/nested.wasm",
+		"test.linked": "linked/app.wasm",
+	}
+	for id, module := range expected {
+		if modules[id] != module {
+			t.Errorf("Expected %s to use %s, got %q", id, module, modules[id])
+		}
+	}
+
+	// a second module without a modules field is ambiguous
+	fsys["menu/extra.wasm"] = &fstest.MapFile{Data: []byte("\x00asm")}
+	if _, err := readPluginsFS(fsys); err == nil {
+		t.Error("Expected an error for a plugin with two unnamed modules")
+	}
+}
+
+func TestSymlinkedModules(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "outside.wasm")
+	mustWrite(t, outside, "\x00asm")
+
+	// a discovered module, a named module and a named module in a linked directory
+	mustWrite(t, filepath.Join(dir, "found", "plugin.yaml"), "id: test.found\nversion: 1.0.0\n")
+	mustWrite(t, filepath.Join(dir, "named", "plugin.yaml"), "id: test.named\nversion: 1.0.0\nmodules:\n  - name: main\n    path: app.wasm\n")
+	mustWrite(t, filepath.Join(dir, "linkdir", "plugin.yaml"), "id: test.linkdir\nversion: 1.0.0\nmodules:\n  - name: main\n    path: lib/outside.wasm\n")
+	for link, target := range map[string]string{
+		"found/found.wasm": outside, "named/app.wasm": outside, "linkdir/lib": filepath.Dir(outside), "bare.wasm": outside,
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"found", "named", "linkdir"} {
+		if _, err := readPluginsFS(os.DirFS(filepath.Join(dir, name))); err == nil {
+			t.Errorf("%s: expected a symlinked module to be refused", name)
+		}
+	}
+	if _, _, err := loadWasm(filepath.Join(dir, "bare.wasm")); !errors.Is(err, ErrNotRegularFile) {
+		t.Errorf("Expected a symlinked bare module to be refused, got %v", err)
+	}
+	if _, err := ContentDigest(os.DirFS(filepath.Join(dir, "named"))); !errors.Is(err, ErrNotRegularFile) {
+		t.Errorf("Expected a symlinked module to fail the digest, got %v", err)
+	}
+}
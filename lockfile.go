@@ -0,0 +1,250 @@
+package pluginengine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LockfileName is the conventional name of a lockfile, kept next to the plugins it pins.
+const LockfileName = "pluginengine.lock"
+
+// the lockfile format version written by this engine
+const lockfileVersion = 1
+
+// ErrLockMismatch is wrapped by the error returned for plugins that do not match the lockfile under PolicyEnforce.
+var ErrLockMismatch = errors.New("plugin does not match the lockfile")
+
+type (
+	// LockEntry
+	//
+	// Pins one plugin version to the digests of what it was loaded from. ArchiveSHA256 is the sha256 of the archive or
+	// bare module the plugin came from and is empty for plugins loaded from an unpacked directory or an fs.FS.
+	// ModuleSHA256 is the sha256 of the wasm module itself.
+	LockEntry struct {
+		Id            string `json:"id" yaml:"id"`
+		Version       string `json:"version" yaml:"version"`
+		ArchiveSHA256 string `json:"archiveSha256,omitempty" yaml:"archiveSha256,omitempty"`
+		ModuleSHA256  string `json:"moduleSha256" yaml:"moduleSha256"`
+	}
+
+	// Lockfile
+	//
+	// The set of plugin versions, and their digests, an environment is expected to load. It is stored as YAML in a
+	// pluginengine.lock file so two environments can be checked to be running the same plugin builds.
+	Lockfile struct {
+		LockfileVersion int         `json:"lockfileVersion" yaml:"lockfileVersion"`
+		Plugins         []LockEntry `json:"plugins" yaml:"plugins"`
+	}
+)
+
+// GenerateLockfile
+//
+// This method returns a lockfile pinning every plugin version currently loaded, sorted by id and version.
+func (e *Engine) GenerateLockfile() *Lockfile {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	lock := &Lockfile{LockfileVersion: lockfileVersion, Plugins: make([]LockEntry, 0)}
+	for _, pv := range e.plugins {
+		for _, p := range pv {
+			lock.Plugins = append(lock.Plugins, LockEntry{
+				Id:            p.Details.Id,
+				Version:       p.Details.Version,
+				ArchiveSHA256: p.SourceHash,
+				ModuleSHA256:  p.ModuleHash,
+			})
+		}
+	}
+
+	sort.Slice(lock.Plugins, func(i, j int) bool {
+		if lock.Plugins[i].Id != lock.Plugins[j].Id {
+			return lock.Plugins[i].Id < lock.Plugins[j].Id
+		}
+		return lock.Plugins[i].Version < lock.Plugins[j].Version
+	})
+
+	return lock
+}
+
+// ReadLockfile
+//
+// Reads a lockfile written by Lockfile.Write.
+func ReadLockfile(path string) (*Lockfile, error) {
+	data, err := os.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+
+	lock := &Lockfile{}
+	if err := yaml.Unmarshal(data, lock); nil != err {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if lock.LockfileVersion != lockfileVersion {
+		return nil, fmt.Errorf("%s: unsupported lockfile version %d", path, lock.LockfileVersion)
+	}
+
+	return lock, nil
+}
+
+// Write
+//
+// Writes the lockfile to path as YAML.
+func (l *Lockfile) Write(path string) error {
+	data, err := yaml.Marshal(l)
+	if nil != err {
+		return err
+	}
+
+	return os.WriteFile(path, append([]byte("# generated by pluginengine, do not edit\n"), data...), 0644)
+}
+
+// Entry
+//
+// Returns the entry pinning the given plugin version, or nil if the lockfile has none.
+func (l *Lockfile) Entry(id, version string) *LockEntry {
+	for i := range l.Plugins {
+		if l.Plugins[i].Id == id && l.Plugins[i].Version == version {
+			return &l.Plugins[i]
+		}
+	}
+
+	return nil
+}
+
+// SetLockfile
+//
+// Sets the lockfile plugins are checked against as they are loaded and what happens when one does not match. A plugin
+// version missing from the lockfile or with a different archive or module digest is a mismatch. The archive digest is
+// only compared for plugins loaded from an archive or bare module. Start checks the other way round, a version in the
+// lockfile that is not loaded by then is a mismatch too. The default policy is PolicyOff.
+func (e *Engine) SetLockfile(lock *Lockfile, policy Policy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.lockfile = lock
+	e.lockPolicy = policy
+}
+
+// checkLock
+//
+// This method checks the plugins against the engine's lockfile according to its lock policy. An error wrapping
+// ErrLockMismatch is returned when the policy is enforced and any of the plugins do not match.
+func (e *Engine) checkLock(plugs []*plugin) error {
+	e.mu.RLock()
+	lock, policy := e.lockfile, e.lockPolicy
+	e.mu.RUnlock()
+
+	if policy == PolicyOff {
+		return nil
+	}
+
+	if nil == lock {
+		lock = &Lockfile{}
+	}
+
+	mismatches := make([]string, 0)
+	for _, plug := range plugs {
+		name := plug.Details.Id + "@" + plug.Details.Version
+
+		entry := lock.Entry(plug.Details.Id, plug.Details.Version)
+		switch {
+		case nil == entry:
+			mismatches = append(mismatches, name+" is not in the lockfile")
+		case entry.ModuleSHA256 != plug.ModuleHash:
+			mismatches = append(mismatches, fmt.Sprintf("%s module sha256 is %s, locked to %s", name, plug.ModuleHash, entry.ModuleSHA256))
+		case entry.ArchiveSHA256 != "" && plug.SourceHash != "" && entry.ArchiveSHA256 != plug.SourceHash:
+			mismatches = append(mismatches, fmt.Sprintf("%s archive sha256 is %s, locked to %s", name, plug.SourceHash, entry.ArchiveSHA256))
+		}
+	}
+
+	return lockMismatches(policy, mismatches)
+}
+
+// checkLockLoaded
+//
+// This method checks that every plugin version in the engine's lockfile is loaded, the other half of checkLock, so an
+// engine missing plugins the lockfile pins does not pass for one running the same plugin set. A missing version is a
+// mismatch handled according to the lock policy.
+func (e *Engine) checkLockLoaded() error {
+	e.mu.RLock()
+	lock, policy := e.lockfile, e.lockPolicy
+	mismatches := make([]string, 0)
+	if policy != PolicyOff && nil != lock {
+		for _, entry := range lock.Plugins {
+			if nil == e.plugins[entry.Id][entry.Version] {
+				mismatches = append(mismatches, entry.Id+"@"+entry.Version+" is in the lockfile but not loaded")
+			}
+		}
+	}
+	e.mu.RUnlock()
+
+	return lockMismatches(policy, mismatches)
+}
+
+// lockMismatches
+//
+// Returns an error wrapping ErrLockMismatch for the mismatches when the policy is enforced, otherwise logs them.
+func lockMismatches(policy Policy, mismatches []string) error {
+	if len(mismatches) == 0 {
+		return nil
+	}
+
+	if policy == PolicyEnforce {
+		return fmt.Errorf("%w: %s", ErrLockMismatch, strings.Join(mismatches, "; "))
+	}
+
+	for _, msg := range mismatches {
+		logln("WARNING: lockfile mismatch: ", msg)
+	}
+
+	return nil
+}
+
+// moduleHash
+//
+// Returns the hex encoded sha256 of a plugin's wasm module. For a plugin with linked modules it is the sha256 of the
+// sorted list of module names and their sha256, the main module being named main. The module bytes the plugin holds are
+// hashed, never the files they were read from, as those bytes are what is instantiated.
+func moduleHash(p *plugin) (string, error) {
+	main, err := dataHash(p.ModuleData, p.PathToModule)
+	if nil != err || len(p.Linked) == 0 {
+		return main, err
+	}
+
+	lines := []string{mainModuleName + "\x00" + main + "\n"}
+	for _, m := range p.Linked {
+		hash, err := dataHash(m.Data, m.Path)
+		if nil != err {
+			return "", err
+		}
+		lines = append(lines, m.Name+"\x00"+hash+"\n")
+	}
+	sort.Strings(lines)
+
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dataHash
+//
+// Returns the hex encoded sha256 of the module bytes read from path, an error when they were not read.
+func dataHash(data []byte, path string) (string, error) {
+	if nil == data {
+		return "", fmt.Errorf("module %s was not read when loading", path)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,74 @@
+package pluginengine
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockfile(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "locked.zip")
+	writeTestPluginZip(t, archive, "id: test.locked\nversion: 1.0.0\n")
+
+	e := newTestEngine(t)
+	if err := e.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	lockPath := filepath.Join(t.TempDir(), LockfileName)
+	if err := e.GenerateLockfile().Write(lockPath); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := ReadLockfile(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := lock.Entry("test.locked", "1.0.0")
+	if nil == entry || entry.ArchiveSHA256 == "" || entry.ModuleSHA256 == "" {
+		t.Fatalf("Expected the loaded plugin to be pinned, got %+v", lock.Plugins)
+	}
+
+	// the same archive loads under an enforced lockfile
+	e = newTestEngine(t)
+	e.SetLockfile(lock, PolicyEnforce)
+	if err := e.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	if nil == e.plugins["test.locked"]["1.0.0"] {
+		t.Fatal("Expected the locked plugin to load")
+	}
+	if err := e.Start(); err != nil {
+		t.Fatalf("Expected the engine to start with every locked plugin loaded, got %v", err)
+	}
+
+	// a locked plugin that is not loaded is a mismatch too
+	e = newTestEngine(t)
+	e.SetLockfile(lock, PolicyEnforce)
+	if err := e.Start(); !errors.Is(err, ErrLockMismatch) {
+		t.Fatalf("Expected ErrLockMismatch starting without the locked plugin, got %v", err)
+	}
+	e.SetLockfile(lock, PolicyWarn)
+	if err := e.Start(); err != nil {
+		t.Fatalf("Expected the missing plugin to only be logged when warning, got %v", err)
+	}
+
+	// a rebuilt archive does not load under the lockfile
+	writeTestPluginZip(t, archive, "id: test.locked\nversion: 1.0.0\ndescription: rebuilt\n")
+	e = newTestEngine(t)
+	e.SetLockfile(lock, PolicyEnforce)
+	_ = e.Load(dir)
+	if nil != e.plugins["test.locked"] {
+		t.Fatal("Expected the rebuilt archive to be refused")
+	}
+
+	// and is only logged when warning
+	e = newTestEngine(t)
+	e.SetLockfile(lock, PolicyWarn)
+	_ = e.Load(dir)
+	if nil == e.plugins["test.locked"]["1.0.0"] {
+		t.Fatal("Expected the rebuilt archive to load with a warning")
+	}
+}
@@ -0,0 +1,103 @@
+package pluginengine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// secrets shorter than this are not redacted, replacing them would mangle unrelated log output
+const minRedactLength = 4
+
+const redactedText = "[REDACTED]"
+
+// redactions holds the secret values handed to plugins, longest first so a secret containing another is redacted
+// whole. It is shared by every engine in the process as the log funnel is.
+var redactions = struct {
+	sync.RWMutex
+	values []string
+}{}
+
+// logOutput is where the log funnel writes, standard output when nil. Like redactions it is shared by every engine.
+var logOutput = struct {
+	sync.Mutex
+	w io.Writer
+}{}
+
+// SetLogOutput
+//
+// Sets where the engine logs to, for every engine in the process as they share the log funnel. nil, the default, logs
+// to standard output.
+func SetLogOutput(w io.Writer) {
+	logOutput.Lock()
+	defer logOutput.Unlock()
+
+	logOutput.w = w
+}
+
+// writeLog writes a line of the log funnel to the log output
+func writeLog(s string) {
+	logOutput.Lock()
+	defer logOutput.Unlock()
+
+	w := logOutput.w
+	if nil == w {
+		w = os.Stdout
+	}
+	_, _ = io.WriteString(w, s)
+}
+
+// redact
+//
+// Adds a secret value to be redacted from everything logged from now on.
+func redact(value string) {
+	if len(value) < minRedactLength {
+		return
+	}
+
+	redactions.Lock()
+	defer redactions.Unlock()
+
+	for _, v := range redactions.values {
+		if v == value {
+			return
+		}
+	}
+
+	redactions.values = append(redactions.values, value)
+	sort.Slice(redactions.values, func(i, j int) bool {
+		return len(redactions.values[i]) > len(redactions.values[j])
+	})
+}
+
+// redacted
+//
+// Returns s with every secret value replaced.
+func redacted(s string) string {
+	redactions.RLock()
+	defer redactions.RUnlock()
+
+	for _, v := range redactions.values {
+		s = strings.ReplaceAll(s, v, redactedText)
+	}
+
+	return s
+}
+
+// logln
+//
+// The engine's log funnel, fmt.Println with secrets redacted, to the log output (see SetLogOutput). Everything the
+// engine and its plugins log goes through here or logf.
+func logln(args ...interface{}) {
+	writeLog(redacted(fmt.Sprintln(args...)))
+}
+
+// logf
+//
+// fmt.Printf with secrets redacted, see logln.
+func logf(format string, args ...interface{}) {
+	writeLog(redacted(fmt.Sprintf(format, args...)))
+}
@@ -0,0 +1,695 @@
+package pluginengine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestSchemaVersion is the newest manifest schema version this engine understands. A manifest without a
+// schemaVersion is taken to be version 1.
+const ManifestSchemaVersion = 1
+
+// the JSON alternative to plugin.yaml, a plugin has one or the other
+const pluginManifestJSONName = "plugin.json"
+
+// ErrInvalidManifest is wrapped by every ManifestError.
+var ErrInvalidManifest = errors.New("invalid plugin manifest")
+
+var (
+	// ids are dot separated segments of letters, digits, '_' and '-', each starting with a letter, for example
+	// mycompany.plugins.MyPlugin
+	idPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*(\.[A-Za-z][A-Za-z0-9_-]*)*$`)
+
+	// setting and secret names are what plugins look their config and secrets up by
+	namePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*$`)
+
+	yamlLinePattern = regexp.MustCompile(`line (\d+): (.*)`)
+)
+
+// ManifestError
+//
+// A problem with a plugin manifest. Line and Column are 1 based and 0 when the position is not known, Field is the
+// path of the offending field, such as hooks[1].func, when the problem is with a value rather than the syntax.
+type ManifestError struct {
+	File   string
+	Line   int
+	Column int
+	Field  string
+	Msg    string
+}
+
+func (e *ManifestError) Error() string {
+	pos := e.File
+	if e.Line > 0 {
+		pos += ":" + strconv.Itoa(e.Line)
+		if e.Column > 0 {
+			pos += ":" + strconv.Itoa(e.Column)
+		}
+	}
+
+	if e.Field != "" {
+		return pos + ": " + e.Field + ": " + e.Msg
+	}
+
+	return pos + ": " + e.Msg
+}
+
+func (e *ManifestError) Unwrap() error {
+	return ErrInvalidManifest
+}
+
+// isManifestName
+//
+// Returns true for the file names a plugin manifest can have.
+func isManifestName(name string) bool {
+	base := path.Base(name)
+	return base == pluginManifestName || base == pluginManifestJSONName
+}
+
+// ParseManifest
+//
+// This function strictly decodes a plugin manifest. file names the manifest in errors, and a .json extension selects
+// JSON, anything else YAML. Unknown fields are rejected, errors carry the line and column they were found at where the
+// decoder reports one, and the decoded manifest is validated: id and version are required, the version must be x.y.z,
+// ids of the plugin, its anchors, hooks and listeners must be dot separated names, every hook and listener must name
+// its function, and modules must be relative .wasm paths with one named main when there is more than one.
+func ParseManifest(file string, data []byte) (Plugin, error) {
+	var p Plugin
+	var err error
+
+	// where each field's value was found, so validation errors can point at it
+	positions := make(map[string]position)
+	if strings.HasSuffix(file, ".json") {
+		p, err = decodeJSONManifest(file, data, positions)
+	} else {
+		p, err = decodeYAMLManifest(file, data, positions)
+	}
+
+	if nil != err {
+		return Plugin{}, err
+	}
+
+	if p.SchemaVersion == 0 {
+		p.SchemaVersion = 1
+	}
+
+	if err := validateManifest(file, p, positions); nil != err {
+		return Plugin{}, err
+	}
+
+	return p, nil
+}
+
+// decodeYAMLManifest
+//
+// Decodes a YAML manifest, checking for unknown fields against the node tree so their position can be reported. The
+// position of every field is recorded in positions.
+func decodeYAMLManifest(file string, data []byte, positions map[string]position) (Plugin, error) {
+	p := Plugin{}
+
+	node := &yaml.Node{}
+	if err := yaml.Unmarshal(data, node); nil != err {
+		return p, yamlManifestError(file, err)
+	}
+
+	if len(node.Content) == 0 {
+		return p, &ManifestError{File: file, Msg: "the manifest is empty"}
+	}
+
+	if err := checkYAMLFields(file, node.Content[0], reflect.TypeOf(p), "", positions); nil != err {
+		return p, err
+	}
+
+	if err := node.Decode(&p); nil != err {
+		return p, yamlManifestError(file, err)
+	}
+
+	return p, nil
+}
+
+// yamlManifestError
+//
+// Turns a yaml decoding error into a ManifestError. yaml only reports the line of a problem, not the column.
+func yamlManifestError(file string, err error) error {
+	msg := err.Error()
+
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+		msg = typeErr.Errors[0]
+	}
+
+	if m := yamlLinePattern.FindStringSubmatch(msg); nil != m {
+		line, _ := strconv.Atoi(m[1])
+		return &ManifestError{File: file, Line: line, Msg: m[2]}
+	}
+
+	return &ManifestError{File: file, Msg: strings.TrimPrefix(msg, "yaml: ")}
+}
+
+// checkYAMLFields
+//
+// Walks a YAML node alongside the Go type it decodes into and returns an error for the first mapping key that does
+// not match a field. field is the path of node, for error messages, and node's position is recorded under it.
+func checkYAMLFields(file string, node *yaml.Node, t reflect.Type, field string, positions map[string]position) error {
+	if field != "" {
+		positions[field] = position{line: node.Line, column: node.Column}
+	}
+
+	for node.Kind == yaml.AliasNode && nil != node.Alias {
+		node = node.Alias
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				return &ManifestError{File: file, Line: key.Line, Column: key.Column, Field: field, Msg: fmt.Sprintf("unknown field %q", key.Value)}
+			}
+
+			if err := checkYAMLFields(file, value, fieldType, joinField(field, key.Value), positions); nil != err {
+				return err
+			}
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			if err := checkYAMLFields(file, item, t.Elem(), fmt.Sprintf("%s[%d]", field, i), positions); nil != err {
+				return err
+			}
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := checkYAMLFields(file, node.Content[i+1], t.Elem(), joinField(field, node.Content[i].Value), positions); nil != err {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// yamlFields
+//
+// Returns the yaml keys of a struct's fields, following yaml.v3's naming rules, with inlined structs flattened.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+
+		inline := false
+		for _, opt := range parts[1:] {
+			inline = inline || opt == "inline"
+		}
+
+		if inline && f.Type.Kind() == reflect.Struct {
+			for k, v := range yamlFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+
+	return fields
+}
+
+// position is where a field's value starts in a manifest, 1 based
+type position struct {
+	line, column int
+}
+
+// parentField returns the field enclosing field, "hooks[0]" for "hooks[0].func" and "hooks" for "hooks[0]"
+func parentField(field string) string {
+	if strings.HasSuffix(field, "]") {
+		return field[:strings.LastIndexByte(field, '[')]
+	}
+	if i := strings.LastIndexByte(field, '.'); i >= 0 {
+		return field[:i]
+	}
+
+	return ""
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+
+	return parent + "." + name
+}
+
+// decodeJSONManifest
+//
+// Decodes a JSON manifest, rejecting unknown fields and anything after the manifest object. The tokens are walked
+// first to find the position of an unknown field, as the decoder only reports where the object containing it ends,
+// and the position of every field is recorded in positions along the way.
+func decodeJSONManifest(file string, data []byte, positions map[string]position) (Plugin, error) {
+	p := Plugin{}
+
+	var manifestErr *ManifestError
+	walker := json.NewDecoder(bytes.NewReader(data))
+	if err := checkJSONFields(file, data, walker, reflect.TypeOf(p), "", positions); errors.As(err, &manifestErr) {
+		return p, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&p); nil != err {
+		offset := dec.InputOffset()
+
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		msg := strings.TrimPrefix(err.Error(), "json: ")
+		field := ""
+
+		switch {
+		case errors.As(err, &syntaxErr):
+			offset = syntaxErr.Offset
+		case errors.As(err, &typeErr):
+			offset = typeErr.Offset
+			field = typeErr.Field
+			msg = fmt.Sprintf("cannot decode %s into %s", typeErr.Value, typeErr.Type)
+		case errors.Is(err, io.EOF):
+			return p, &ManifestError{File: file, Msg: "the manifest is empty"}
+		}
+
+		line, column := lineColumn(data, offset)
+		return p, &ManifestError{File: file, Line: line, Column: column, Field: field, Msg: msg}
+	}
+
+	if dec.More() {
+		line, column := lineColumn(data, dec.InputOffset())
+		return p, &ManifestError{File: file, Line: line, Column: column, Msg: "unexpected data after the manifest"}
+	}
+
+	return p, nil
+}
+
+// checkJSONFields
+//
+// Reads the next JSON value from dec alongside the Go type it decodes into and returns a ManifestError for the first
+// object key that does not match a field. Keys are matched case insensitively like encoding/json. Syntax errors are
+// returned as is, for the decoder to report. The position of the value is recorded under field.
+func checkJSONFields(file string, data []byte, dec *json.Decoder, t reflect.Type, field string, positions map[string]position) error {
+	start := jsonValueStart(data, dec.InputOffset())
+
+	tok, err := dec.Token()
+	if nil != err {
+		return err
+	}
+
+	if field != "" {
+		line, column := lineColumn(data, start)
+		positions[field] = position{line: line, column: column}
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		var fields map[string]reflect.Type
+		if t.Kind() == reflect.Struct {
+			fields = jsonFields(t)
+		}
+
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if nil != err {
+				return err
+			}
+			key, _ := keyTok.(string)
+
+			valueType := anyType
+			switch t.Kind() {
+			case reflect.Struct:
+				var ok bool
+				if key, valueType, ok = lookupJSONField(fields, key); !ok {
+					line, column := lineColumn(data, jsonKeyStart(data, dec.InputOffset()))
+					return &ManifestError{File: file, Line: line, Column: column, Field: field, Msg: fmt.Sprintf("unknown field %q", key)}
+				}
+			case reflect.Map:
+				valueType = t.Elem()
+			}
+
+			if err := checkJSONFields(file, data, dec, valueType, joinField(field, key), positions); nil != err {
+				return err
+			}
+		}
+
+		_, err = dec.Token()
+		return err
+	case json.Delim('['):
+		elemType := anyType
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			elemType = t.Elem()
+		}
+
+		for i := 0; dec.More(); i++ {
+			if err := checkJSONFields(file, data, dec, elemType, fmt.Sprintf("%s[%d]", field, i), positions); nil != err {
+				return err
+			}
+		}
+
+		_, err = dec.Token()
+		return err
+	}
+
+	return nil
+}
+
+// the type of values whose fields are not checked
+var anyType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// jsonFields
+//
+// Returns the json keys of a struct's fields, following encoding/json's naming rules, with embedded structs flattened.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for k, v := range jsonFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+
+	return fields
+}
+
+// lookupJSONField returns the name and type of the field a key decodes into
+func lookupJSONField(fields map[string]reflect.Type, key string) (string, reflect.Type, bool) {
+	if t, ok := fields[key]; ok {
+		return key, t, true
+	}
+
+	for name, t := range fields {
+		if strings.EqualFold(name, key) {
+			return name, t, true
+		}
+	}
+
+	return key, nil, false
+}
+
+// jsonValueStart
+//
+// Returns the offset of the value that follows offset, skipping whitespace and the separator before it.
+func jsonValueStart(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+		offset++
+	}
+
+	return offset
+}
+
+// jsonKeyStart
+//
+// Returns the offset of the opening quote of the object key that ends at end.
+func jsonKeyStart(data []byte, end int64) int64 {
+	for i := end - 2; i > 0; i-- {
+		if data[i] == '"' && data[i-1] != '\\' {
+			return i
+		}
+	}
+
+	return end
+}
+
+// lineColumn
+//
+// Converts a byte offset into data to a 1 based line and column.
+func lineColumn(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+
+	return line, column
+}
+
+// validateManifest
+//
+// Checks the values of a decoded manifest, returning the first problem found. The error points at the position of the
+// field in positions or, for a field that is missing, at the closest enclosing field that is not the manifest itself.
+func validateManifest(file string, p Plugin, positions map[string]position) error {
+	invalid := func(field, msg string) error {
+		for f := field; f != ""; f = parentField(f) {
+			if pos, ok := positions[f]; ok {
+				return &ManifestError{File: file, Line: pos.line, Column: pos.column, Field: field, Msg: msg}
+			}
+		}
+		return &ManifestError{File: file, Field: field, Msg: msg}
+	}
+
+	checkId := func(field, id string) error {
+		if id == "" {
+			return invalid(field, "is required")
+		}
+		if !idPattern.MatchString(id) {
+			return invalid(field, fmt.Sprintf("%q is not a valid id, expected dot separated names such as mycompany.plugins.MyPlugin", id))
+		}
+		return nil
+	}
+
+	if p.SchemaVersion < 0 || p.SchemaVersion > ManifestSchemaVersion {
+		return invalid("schemaVersion", fmt.Sprintf("version %d is not supported, the newest supported version is %d", p.SchemaVersion, ManifestSchemaVersion))
+	}
+
+	if err := checkId("id", p.Id); nil != err {
+		return err
+	}
+
+	if p.Version == "" {
+		return invalid("version", "is required")
+	}
+	if !isSemverValid(p.Version) {
+		return invalid("version", fmt.Sprintf("%q is not a semantic version, expected x.y.z", p.Version))
+	}
+
+	names := make(map[string]bool)
+	for i, m := range p.Modules {
+		clean := path.Clean(m.Path)
+		switch {
+		case m.Path == "":
+			return invalid(fmt.Sprintf("modules[%d].path", i), "is required")
+		case path.IsAbs(m.Path) || clean == ".." || strings.HasPrefix(clean, "../") || !strings.HasSuffix(clean, ".wasm"):
+			return invalid(fmt.Sprintf("modules[%d].path", i), fmt.Sprintf("%q must be the relative path of a .wasm file inside the plugin", m.Path))
+		}
+
+		if len(p.Modules) > 1 {
+			if m.Name == "" {
+				return invalid(fmt.Sprintf("modules[%d].name", i), "is required when a plugin has more than one module")
+			}
+			if names[m.Name] {
+				return invalid(fmt.Sprintf("modules[%d].name", i), fmt.Sprintf("%q is used by more than one module", m.Name))
+			}
+			names[m.Name] = true
+		}
+	}
+	if len(p.Modules) > 1 && !names[mainModuleName] {
+		return invalid("modules", "one of the modules must be named "+mainModuleName)
+	}
+
+	settings := make(map[string]bool)
+	for i, setting := range p.Settings {
+		field := fmt.Sprintf("settings[%d]", i)
+		if !namePattern.MatchString(setting.Name) {
+			return invalid(field+".name", fmt.Sprintf("%q is not a valid setting name, expected letters, digits, '_', '-' and '.'", setting.Name))
+		}
+		if settings[setting.Name] {
+			return invalid(field+".name", fmt.Sprintf("%q is declared more than once", setting.Name))
+		}
+		settings[setting.Name] = true
+
+		switch setting.Type {
+		case "", SettingString, SettingInt, SettingFloat, SettingBool:
+		default:
+			return invalid(field+".type", fmt.Sprintf("%q is not one of string, int, float or bool", setting.Type))
+		}
+
+		if nil != setting.Default {
+			if err := checkSettingValue(setting, fmt.Sprint(setting.Default)); nil != err {
+				return invalid(field+".default", err.Error())
+			}
+		}
+	}
+
+	secrets := make(map[string]bool)
+	for i, name := range p.Secrets {
+		field := fmt.Sprintf("secrets[%d]", i)
+		if !namePattern.MatchString(name) {
+			return invalid(field, fmt.Sprintf("%q is not a valid secret name, expected letters, digits, '_', '-' and '.'", name))
+		}
+		if secrets[name] {
+			return invalid(field, fmt.Sprintf("%q is declared more than once", name))
+		}
+		secrets[name] = true
+	}
+
+	hosts := make(map[string]bool)
+	for i, host := range p.AllowedHosts {
+		field := fmt.Sprintf("allowedHosts[%d]", i)
+		if err := checkHostPattern(host); nil != err {
+			return invalid(field, err.Error())
+		}
+		if hosts[host] {
+			return invalid(field, fmt.Sprintf("%q is declared more than once", host))
+		}
+		hosts[host] = true
+	}
+
+	for i, anchor := range p.Anchors {
+		if err := checkId(fmt.Sprintf("anchors[%d].id", i), anchor.Id); nil != err {
+			return err
+		}
+	}
+
+	for i, hook := range p.Hooks {
+		if err := checkId(fmt.Sprintf("hooks[%d].id", i), hook.Id); nil != err {
+			return err
+		}
+		if err := checkId(fmt.Sprintf("hooks[%d].anchor", i), hook.Anchor); nil != err {
+			return err
+		}
+		if hook.Func == "" {
+			return invalid(fmt.Sprintf("hooks[%d].func", i), "is required")
+		}
+	}
+
+	for i, evt := range p.Events {
+		if err := checkId(fmt.Sprintf("events[%d]", i), evt); nil != err {
+			return err
+		}
+	}
+
+	for i, l := range p.Listeners {
+		if l.Id != "" {
+			if err := checkId(fmt.Sprintf("listeners[%d].id", i), l.Id); nil != err {
+				return err
+			}
+		}
+		if err := checkId(fmt.Sprintf("listeners[%d].event", i), l.Event); nil != err {
+			return err
+		}
+		if l.Func == "" {
+			return invalid(fmt.Sprintf("listeners[%d].func", i), "is required")
+		}
+	}
+
+	schedules := make(map[string]bool)
+	for i, schedule := range p.Schedules {
+		field := fmt.Sprintf("schedules[%d]", i)
+		if !namePattern.MatchString(schedule.Id) {
+			return invalid(field+".id", fmt.Sprintf("%q is not a valid schedule id, expected letters, digits, '_', '-' and '.'", schedule.Id))
+		}
+		if schedules[schedule.Id] {
+			return invalid(field+".id", fmt.Sprintf("%q is declared more than once", schedule.Id))
+		}
+		schedules[schedule.Id] = true
+
+		if _, err := parseCron(schedule.Cron); nil != err {
+			return invalid(field+".cron", err.Error())
+		}
+		if schedule.Func == "" {
+			return invalid(field+".func", "is required")
+		}
+
+		switch schedule.Overlap {
+		case "", OverlapSkip, OverlapQueue, OverlapParallel:
+		default:
+			return invalid(field+".overlap", fmt.Sprintf("%q is not one of skip, queue or parallel", schedule.Overlap))
+		}
+
+		if schedule.Jitter != "" {
+			if d, err := time.ParseDuration(schedule.Jitter); nil != err || d < 0 {
+				return invalid(field+".jitter", fmt.Sprintf("%q is not a duration such as 30s", schedule.Jitter))
+			}
+		}
+	}
+
+	routeHooks := make(map[string]bool)
+	for _, hook := range p.Hooks {
+		if hook.Anchor == HTTPRoutesAnchor {
+			routeHooks[hook.Id] = false
+		}
+	}
+	for i, route := range p.Routes {
+		field := fmt.Sprintf("routes[%d]", i)
+		if _, ok := routeHooks[route.Hook]; !ok {
+			return invalid(field+".hook", fmt.Sprintf("%q is not a hook of this plugin on the %s anchor", route.Hook, HTTPRoutesAnchor))
+		}
+		routeHooks[route.Hook] = true
+		if route.Method != "" && !methodPattern.MatchString(route.Method) {
+			return invalid(field+".method", fmt.Sprintf("%q is not an upper case HTTP method", route.Method))
+		}
+		if err := checkRoutePath(route.Path); nil != err {
+			return invalid(field+".path", err.Error())
+		}
+	}
+	for i, hook := range p.Hooks {
+		if hook.Anchor == HTTPRoutesAnchor && !routeHooks[hook.Id] {
+			return invalid(fmt.Sprintf("hooks[%d]", i), fmt.Sprintf("%q is on the %s anchor but has no routes", hook.Id, HTTPRoutesAnchor))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package pluginengine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseManifest(t *testing.T) {
+	p, err := ParseManifest("plugin.json", []byte(`{"id": "test.json", "version": "1.2.3", "hooks": [{"id": "test.json.hook", "anchor": "test.menu", "func": "run"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Id != "test.json" || p.SchemaVersion != 1 || len(p.Hooks) != 1 {
+		t.Errorf("Unexpected manifest %+v", p)
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		manifest string
+		expected string
+	}{
+		{"unknown yaml field", "plugin.yaml", "id: test.strict\nversion: 1.0.0\nhooks:\n  - id: test.strict.hook\n    anchor: test.menu\n    fnuc: run\n", `plugin.yaml:6:5: hooks[0]: unknown field "fnuc"`},
+		{"unknown json field", "plugin.json", "{\n  \"id\": \"test.strict\",\n  \"verison\": \"1.0.0\"\n}", `plugin.json:3:3: unknown field "verison"`},
+		{"yaml type error", "plugin.yaml", "id: test.strict\nversion: 1.0.0\nhooks: nope\n", "plugin.yaml:3: "},
+		{"missing id", "plugin.yaml", "version: 1.0.0\n", "plugin.yaml: id: is required"},
+		{"missing version", "plugin.yaml", "id: test.strict\n", "plugin.yaml: version: is required"},
+		{"bad version", "plugin.yaml", "id: test.strict\nversion: v1\n", "plugin.yaml:2:10: version: \"v1\" is not a semantic version"},
+		{"bad id", "plugin.yaml", "id: test..strict\nversion: 1.0.0\n", "plugin.yaml:1:5: id: "},
+		{"missing hook func", "plugin.yaml", "id: test.strict\nversion: 1.0.0\nhooks:\n  - id: test.strict.hook\n    anchor: test.menu\n", "plugin.yaml:4:5: hooks[0].func: is required"},
+		{"newer schema", "plugin.yaml", "schemaVersion: 2\nid: test.strict\nversion: 1.0.0\n", "plugin.yaml:1:16: schemaVersion: version 2 is not supported"},
+		{"no main module", "plugin.yaml", "id: test.strict\nversion: 1.0.0\nmodules:\n  - name: a\n    path: a.wasm\n  - name: b\n    path: b.wasm\n", "plugin.yaml:4:3: modules: one of the modules must be named main"},
+		{"module outside the plugin", "plugin.yaml", "id: test.strict\nversion: 1.0.0\nmodules:\n  - path: ../a.wasm\n", "plugin.yaml:4:11: modules[0].path"},
+		{"host with scheme", "plugin.yaml", "id: test.strict\nversion: 1.0.0\nallowedHosts:\n  - https://example.com\n", "plugin.yaml:4:5: allowedHosts[0]: \"https://example.com\" must be a host name"},
+		{"bad json version", "plugin.json", "{\n  \"id\": \"test.strict\",\n  \"Version\": \"v1\"\n}", "plugin.json:3:14: version: "},
+		{"missing json hook func", "plugin.json", "{\"id\": \"test.strict\", \"version\": \"1.0.0\",\n \"hooks\": [{\"id\": \"test.strict.hook\", \"anchor\": \"test.menu\"}]}", "plugin.json:2:12: hooks[0].func: is required"},
+		{"empty", "plugin.yaml", "", "the manifest is empty"},
+	}
+
+	for _, test := range tests {
+		_, err := ParseManifest(test.file, []byte(test.manifest))
+		if !errors.Is(err, ErrInvalidManifest) {
+			t.Errorf("%s: expected an ErrInvalidManifest, got %v", test.name, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected an error containing %q, got %q", test.name, test.expected, err.Error())
+		}
+	}
+}
+
+func TestReadPluginsFSManifestNames(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/plugin.json":   {Data: []byte(`{"id": "test.a", "version": "1.0.0"}`)},
+		"a/a.wasm":        {Data: []byte("\x00asm")},
+		"b/manifest.yaml": {Data: []byte("not: a plugin")},
+	}
+
+	plugs, err := readPluginsFS(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plugs) != 1 || plugs[0].Details.Id != "test.a" {
+		t.Fatalf("Expected only plugin.json to be read, got %+v", plugs)
+	}
+
+	fsys["a/plugin.yaml"] = &fstest.MapFile{Data: []byte("id: test.a\nversion: 1.0.0\n")}
+	if _, err := readPluginsFS(fsys); err == nil {
+		t.Error("Expected an error for a plugin with both a plugin.yaml and a plugin.json")
+	}
+}
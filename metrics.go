@@ -0,0 +1,211 @@
+package pluginengine
+
+import (
+	"time"
+
+	extism "github.com/extism/go-sdk"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// the metrics the engine records
+const (
+	// MetricCalls counts the calls into hooks, listeners and schedules, labeled with plugin, version and hook
+	MetricCalls = "pluginengine_calls_total"
+	// MetricCallErrors counts the calls that failed, labeled as MetricCalls
+	MetricCallErrors = "pluginengine_call_errors_total"
+	// MetricCallDuration is a histogram of the seconds calls that reached the plugin took, labeled as MetricCalls
+	MetricCallDuration = "pluginengine_call_duration_seconds"
+	// MetricInstantiateDuration is a histogram of the seconds instantiating a plugin took, labeled with plugin and
+	// version
+	MetricInstantiateDuration = "pluginengine_instantiate_duration_seconds"
+	// MetricMemory is the bytes of wasm memory the instances of a plugin version hold, labeled with plugin and version
+	MetricMemory = "pluginengine_memory_bytes"
+	// MetricPoolInstances is the number of instances of a plugin version, labeled with plugin and version
+	MetricPoolInstances = "pluginengine_pool_instances"
+	// MetricPoolInUse is the number of instances of a plugin version running a call, labeled with plugin and version
+	MetricPoolInUse = "pluginengine_pool_in_use"
+	// MetricPoolMax is the max number of instances of a plugin version, labeled with plugin and version
+	MetricPoolMax = "pluginengine_pool_max"
+)
+
+// metric label names
+const (
+	LabelPlugin  = "plugin"
+	LabelVersion = "version"
+	LabelHook    = "hook"
+)
+
+// DefaultDurationBuckets are the histogram buckets, in seconds, PrometheusMetrics uses when given none
+var DefaultDurationBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type (
+	// Labels are the label names and values a metric is recorded with
+	Labels map[string]string
+
+	// Metrics
+	//
+	// Where the engine records its metrics, see the Metric constants for what is recorded. Add adds to a counter, Set
+	// sets a gauge and Observe adds a value to a histogram. They are called from the goroutines making calls and must be
+	// safe for concurrent use. PrometheusMetrics and ExpvarMetrics implement it, set one with Engine.SetMetrics.
+	Metrics interface {
+		Add(name string, labels Labels, delta float64)
+		Set(name string, labels Labels, value float64)
+		Observe(name string, labels Labels, value float64)
+	}
+)
+
+// SetMetrics
+//
+// Sets where the engine records its metrics. Nothing is recorded until metrics are set, nil turns them off again. The
+// instance pools are reported straight away, with the memory of their idle instances measured, so plugins already
+// running are not under-reported until their next calls.
+func (e *Engine) SetMetrics(metrics Metrics) {
+	e.metricsMu.Lock()
+	e.metrics = metrics
+	e.metricsMu.Unlock()
+
+	if nil == metrics {
+		return
+	}
+
+	e.mu.RLock()
+	plugs := make([]*plugin, 0)
+	for _, pv := range e.plugins {
+		for _, p := range pv {
+			plugs = append(plugs, p)
+		}
+	}
+	e.mu.RUnlock()
+
+	for _, p := range plugs {
+		p.mu.Lock()
+		pool := p.pool
+		p.mu.Unlock()
+
+		if nil != pool {
+			pool.measureIdle()
+			pool.report()
+		}
+	}
+}
+
+// getMetrics returns the engine's metrics, nil when they are off
+func (e *Engine) getMetrics() Metrics {
+	e.metricsMu.RLock()
+	defer e.metricsMu.RUnlock()
+
+	return e.metrics
+}
+
+// pluginLabels returns the labels identifying a plugin version
+func pluginLabels(p *plugin) Labels {
+	return Labels{LabelPlugin: p.Details.Id, LabelVersion: p.Details.Version}
+}
+
+// recordCall
+//
+// Records a call into the hook, listener or schedule name of a plugin. ran is whether the call reached the plugin, only
+// those have their duration recorded.
+func (e *Engine) recordCall(p *plugin, name string, ran bool, duration time.Duration, err error) {
+	metrics := e.getMetrics()
+	if nil == metrics {
+		return
+	}
+
+	labels := pluginLabels(p)
+	labels[LabelHook] = name
+
+	metrics.Add(MetricCalls, labels, 1)
+	if nil != err {
+		metrics.Add(MetricCallErrors, labels, 1)
+	}
+	if ran {
+		metrics.Observe(MetricCallDuration, labels, duration.Seconds())
+	}
+}
+
+// recordInstantiate records how long instantiating a plugin took
+func (e *Engine) recordInstantiate(p *plugin, duration time.Duration) {
+	if metrics := e.getMetrics(); nil != metrics {
+		metrics.Observe(MetricInstantiateDuration, pluginLabels(p), duration.Seconds())
+	}
+}
+
+// report
+//
+// Records the size, utilization and memory of the pool. A closed pool has a max of 0, so once its last instance is
+// closed all its series are 0 rather than left at what the plugin version had while it was running.
+func (pool *instancePool) report() {
+	metrics := pool.e.getMetrics()
+	if nil == metrics {
+		return
+	}
+
+	pool.mu.Lock()
+	size, idle, limit, memory := pool.size, len(pool.idle), pool.cfg.Max, pool.memory
+	if pool.closed {
+		limit = 0
+	}
+	pool.mu.Unlock()
+
+	labels := pluginLabels(pool.p)
+	metrics.Set(MetricPoolInstances, labels, float64(size))
+	metrics.Set(MetricPoolInUse, labels, float64(size-idle))
+	metrics.Set(MetricPoolMax, labels, float64(limit))
+	metrics.Set(MetricMemory, labels, float64(memory))
+}
+
+// measure
+//
+// Updates the memory the pool's instances hold with the current memory of one of them. Wasm memory only grows, so this
+// is called after each call the instance runs. Nothing is measured while metrics are off.
+func (pool *instancePool) measure(inst *pooledInstance) {
+	if nil == pool.e.getMetrics() {
+		return
+	}
+
+	size := instanceMemory(inst.Plugin)
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pool.memory += size - inst.memory
+	inst.memory = size
+}
+
+// measureIdle
+//
+// Measures the memory of the pool's idle instances, those checked out are measured when their calls return. The pool
+// lock is held throughout so none of them is checked out while it is measured.
+func (pool *instancePool) measureIdle() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for _, inst := range pool.idle {
+		size := instanceMemory(inst.Plugin)
+		pool.memory += size - inst.memory
+		inst.memory = size
+	}
+}
+
+// instanceMemory returns the bytes of memory of an instance's modules, including the extism kernel's
+func instanceMemory(inst *extism.Plugin) int64 {
+	size := exportedMemory(inst.Runtime.Extism)
+	for name := range inst.Modules {
+		if m := inst.Runtime.Wazero.Module(name); nil != m {
+			size += exportedMemory(m)
+		}
+	}
+
+	return size
+}
+
+// exportedMemory returns the size of the memory a module exports, 0 if it exports none
+func exportedMemory(m api.Module) int64 {
+	var size int64
+	for name := range m.ExportedMemoryDefinitions() {
+		size += int64(m.ExportedMemory(name).Size())
+	}
+
+	return size
+}
@@ -0,0 +1,150 @@
+package pluginengine
+
+import (
+	"bytes"
+	"expvar"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	m := NewPrometheusMetrics(0.1, 1)
+	m.Add("test_calls_total", Labels{"hook": "a", "plugin": `say "hi"`}, 1)
+	m.Add("test_calls_total", Labels{"hook": "a", "plugin": `say "hi"`}, 2)
+	m.Set("test_instances", nil, 4)
+	m.Observe("test_duration_seconds", Labels{"hook": "a"}, 0.5)
+	m.Observe("test_duration_seconds", Labels{"hook": "a"}, 2)
+
+	buf := &bytes.Buffer{}
+	n, err := m.WriteTo(buf)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	expected := `# TYPE test_calls_total counter
+test_calls_total{hook="a",plugin="say \"hi\""} 3
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{hook="a",le="0.1"} 0
+test_duration_seconds_bucket{hook="a",le="1"} 1
+test_duration_seconds_bucket{hook="a",le="+Inf"} 2
+test_duration_seconds_sum{hook="a"} 2.5
+test_duration_seconds_count{hook="a"} 2
+# TYPE test_instances gauge
+test_instances 4
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("Expected %d bytes written, got %d", buf.Len(), n)
+	}
+}
+
+// expvar names can not be reused, each run of TestExpvarMetrics publishes its own
+var expvarRuns int
+
+func TestExpvarMetrics(t *testing.T) {
+	expvarRuns++
+	name := "pluginengine_test_" + strconv.Itoa(expvarRuns)
+	m := NewExpvarMetrics(name)
+	m.Add("test_calls_total", Labels{"hook": "a"}, 2)
+	m.Set("test_instances", nil, 4)
+	m.Set("test_instances", nil, 3)
+	m.Observe("test_duration_seconds", nil, 0.5)
+
+	vars := expvar.Get(name).(*expvar.Map)
+	for key, expected := range map[string]string{
+		`test_calls_total{hook="a"}`: "2", "test_instances": "3",
+		"test_duration_seconds_count": "1", "test_duration_seconds_sum": "0.5",
+	} {
+		if v := vars.Get(key); nil == v || v.String() != expected {
+			t.Errorf("Expected %s to be %s, got %v", key, expected, v)
+		}
+	}
+}
+
+func TestEngineMetrics(t *testing.T) {
+	e := newTestEngine(t)
+	metrics := NewPrometheusMetrics()
+	e.SetMetrics(metrics)
+
+	p := &plugin{ModuleData: []byte(exportingModule)}
+	e.mu.Lock()
+	e.addPlugin(p, Plugin{Id: "test.measured", Version: "1.0.0", Stateless: true})
+	e.mu.Unlock()
+
+	if _, err := e.callPlugin(e.context, p, "test.measured.hook", "run", nil); nil != err {
+		t.Fatal(err)
+	}
+	if _, err := e.callPlugin(e.context, p, "test.measured.hook", "missing", nil); nil == err {
+		t.Fatal("Expected calling a missing function to fail")
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := metrics.WriteTo(buf); nil != err {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	labels := `{hook="test.measured.hook",plugin="test.measured",version="1.0.0"}`
+	for _, line := range []string{
+		MetricCalls + labels + " 2",
+		MetricCallErrors + labels + " 1",
+		MetricCallDuration + "_count" + labels + " 2",
+		MetricInstantiateDuration + `_count{plugin="test.measured",version="1.0.0"} 1`,
+		MetricPoolInstances + `{plugin="test.measured",version="1.0.0"} 1`,
+		MetricPoolInUse + `{plugin="test.measured",version="1.0.0"} 0`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected the metrics to have %q, got:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, MetricMemory+`{plugin="test.measured",version="1.0.0"} 0`+"\n") {
+		t.Error("Expected the memory of the plugin's instance to be measured")
+	}
+
+	// a stopped version does not keep reporting what it held
+	_ = e.stopPlugin(p)
+
+	buf.Reset()
+	if _, err := metrics.WriteTo(buf); nil != err {
+		t.Fatal(err)
+	}
+	for _, name := range []string{MetricPoolInstances, MetricPoolInUse, MetricPoolMax, MetricMemory} {
+		if line := name + `{plugin="test.measured",version="1.0.0"} 0`; !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Expected the metrics to have %q once stopped, got:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestSetMetricsMeasures(t *testing.T) {
+	e := newTestEngine(t)
+
+	p := &plugin{ModuleData: []byte(exportingModule)}
+	e.mu.Lock()
+	e.addPlugin(p, Plugin{Id: "test.late", Version: "1.0.0", Stateless: true})
+	e.mu.Unlock()
+
+	if _, err := e.callPlugin(e.context, p, "test.late.hook", "run", nil); nil != err {
+		t.Fatal(err)
+	}
+
+	// the instance already running is measured when metrics are set, before it is called again
+	metrics := NewPrometheusMetrics()
+	e.SetMetrics(metrics)
+
+	buf := &bytes.Buffer{}
+	if _, err := metrics.WriteTo(buf); nil != err {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), MetricPoolInstances+`{plugin="test.late",version="1.0.0"} 1`+"\n") {
+		t.Errorf("Expected the pool to be reported, got:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), MetricMemory+`{plugin="test.late",version="1.0.0"} 0`+"\n") ||
+		!strings.Contains(buf.String(), MetricMemory+`{plugin="test.late",version="1.0.0"}`) {
+		t.Errorf("Expected the memory of the idle instance to be measured, got:\n%s", buf.String())
+	}
+
+	_ = e.stopPlugin(p)
+}
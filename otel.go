@@ -0,0 +1,65 @@
+package pluginengine
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// otelTracer is a Tracer starting its spans with an OpenTelemetry tracer, see NewOTelTracer
+	otelTracer struct {
+		tracer trace.Tracer
+	}
+
+	otelSpan struct {
+		span trace.Span
+	}
+)
+
+// NewOTelTracer
+//
+// Returns a Tracer creating its spans with an OpenTelemetry tracer, so the engine's spans are exported with the rest of
+// the host's. The spans it starts are children of the OpenTelemetry span in the context they are started with, if any.
+func NewOTelTracer(tracer trace.Tracer) Tracer {
+	return &otelTracer{tracer: tracer}
+}
+
+func (t *otelTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(otelAttributes(attrs)...))
+	return ctx, &otelSpan{span: span}
+}
+
+func (s *otelSpan) SetAttributes(attrs ...Attribute) {
+	s.span.SetAttributes(otelAttributes(attrs)...)
+}
+
+func (s *otelSpan) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// TraceID returns the span's trace id, empty for the spans of a no-op OpenTelemetry tracer
+func (s *otelSpan) TraceID() string {
+	if sc := s.span.SpanContext(); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+
+	return ""
+}
+
+func (s *otelSpan) End() {
+	s.span.End()
+}
+
+// otelAttributes returns the attributes as OpenTelemetry string attributes
+func otelAttributes(attrs []Attribute) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		kvs = append(kvs, attribute.String(attr.Key, attr.Value))
+	}
+
+	return kvs
+}
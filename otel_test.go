@@ -0,0 +1,53 @@
+package pluginengine
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestOTelTracer(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+
+	e := newTestEngine(t)
+	e.SetTracer(NewOTelTracer(provider.Tracer("pluginengine")))
+	e.RegisterHostExtensionPoint("test.toolbar", "Toolbar", "1.0.0", "")
+
+	fsys := fstest.MapFS{
+		"otel/plugin.yaml": {Data: []byte("id: test.otel\nversion: 1.0.0\nstateless: true\n" +
+			"hooks:\n  - id: test.otel.hook\n    anchor: test.toolbar\n    func: run\n")},
+		"otel/otel.wasm": {Data: []byte(exportingModule)},
+	}
+	if err := e.LoadFS(fsys); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.CallHookFunc("test.otel.hook", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	byName := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		byName[span.Name] = span
+	}
+
+	call, instantiate := byName["pluginengine.call"], byName["pluginengine.instantiate"]
+	if _, ok := byName["pluginengine.load"]; !ok || call.Name == "" || instantiate.Name == "" {
+		t.Fatalf("Expected load, call and instantiate spans, got %v", exporter.GetSpans().Snapshots())
+	}
+	if instantiate.Parent.SpanID() != call.SpanContext.SpanID() || instantiate.SpanContext.TraceID() != call.SpanContext.TraceID() {
+		t.Error("Expected the instantiate span to be a child of the call span")
+	}
+
+	attrs := make(map[string]string)
+	for _, kv := range call.Attributes {
+		attrs[string(kv.Key)] = kv.Value.AsString()
+	}
+	if attrs[AttrPluginId] != "test.otel" || attrs[AttrHookId] != "test.otel.hook" || attrs[AttrAnchorId] != "test.toolbar" {
+		t.Errorf("Unexpected call span attributes: %v", attrs)
+	}
+}
@@ -0,0 +1,395 @@
+package pluginengine
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// ArchiveFormat
+	//
+	// The formats plugins can be packed in.
+	ArchiveFormat string
+
+	// PackOptions
+	//
+	// What goes into a plugin archive. The manifest is stored as plugin.yaml and the module under its own file name, both
+	// at the root of the archive. Each resource is stored under its base name, and a resource that is a directory is
+	// stored with everything in it.
+	PackOptions struct {
+		Manifest  string
+		Module    string
+		Resources []string
+	}
+
+	// ArchiveEntry
+	//
+	// A file or directory in a plugin archive. SHA256 is empty for directories.
+	ArchiveEntry struct {
+		Name   string      `json:"name" yaml:"name"`
+		Size   int64       `json:"size" yaml:"size"`
+		Mode   fs.FileMode `json:"mode" yaml:"mode"`
+		SHA256 string      `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	}
+
+	// ArchiveInfo
+	//
+	// What Inspect found in a plugin archive. SHA256 is the digest of the archive itself, the archive digest in a
+	// lockfile, and ContentDigest is the digest plugin signatures are made over (see ContentDigest).
+	ArchiveInfo struct {
+		Format        ArchiveFormat  `json:"format" yaml:"format"`
+		SHA256        string         `json:"sha256" yaml:"sha256"`
+		ContentDigest string         `json:"contentDigest" yaml:"contentDigest"`
+		Plugins       []Plugin       `json:"plugins" yaml:"plugins"`
+		Files         []ArchiveEntry `json:"files" yaml:"files"`
+	}
+
+	// a file to be packed, name is its slash separated path in the archive
+	packEntry struct {
+		name string
+		data []byte
+		dir  bool
+	}
+)
+
+const (
+	FormatZip   ArchiveFormat = "zip"
+	FormatTarGz ArchiveFormat = "tar.gz"
+)
+
+// every entry in a packed archive gets the same time and permissions so packing the same files always produces the same
+// bytes. Zip can not store times before 1980.
+var packTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	packFileMode = 0644
+	packDirMode  = 0755
+)
+
+// Pack
+//
+// This function packs a plugin into a .zip or .tar.gz archive written to w. The manifest must be valid, see
+// ParseManifest. Entries are sorted by name and have normalized timestamps and permissions so the archive is
+// reproducible.
+func Pack(w io.Writer, format ArchiveFormat, opts PackOptions) error {
+	entries, err := collectPackEntries(opts)
+	if nil != err {
+		return err
+	}
+
+	switch format {
+	case FormatZip:
+		return packZip(w, entries)
+	case FormatTarGz:
+		return packTarGz(w, entries)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedArchive, format)
+	}
+}
+
+// PackFile
+//
+// Packs a plugin into the archive file at path, in the format given by its .zip, .tar.gz or .tgz extension.
+func PackFile(path string, opts PackOptions) error {
+	var format ArchiveFormat
+	switch archiveSuffix(path) {
+	case ".zip":
+		format = FormatZip
+	case ".tar.gz", ".tgz":
+		format = FormatTarGz
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedArchive, path)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := Pack(buf, format, opts); nil != err {
+		return err
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// collectPackEntries
+//
+// Reads everything that goes into the archive, along with an entry for every directory, sorted by name.
+func collectPackEntries(opts PackOptions) ([]packEntry, error) {
+	manifest, err := os.ReadFile(opts.Manifest)
+	if nil != err {
+		return nil, err
+	}
+
+	if _, err := ParseManifest(opts.Manifest, manifest); nil != err {
+		return nil, err
+	}
+
+	// the manifest is stored under the name the engine looks for, keeping its format
+	manifestName := pluginManifestName
+	if strings.HasSuffix(opts.Manifest, ".json") {
+		manifestName = pluginManifestJSONName
+	}
+
+	if !strings.HasSuffix(opts.Module, ".wasm") {
+		return nil, fmt.Errorf("%s: the module must be a .wasm file", opts.Module)
+	}
+	module, err := os.ReadFile(opts.Module)
+	if nil != err {
+		return nil, err
+	}
+
+	files := map[string]*packEntry{
+		manifestName:               {name: manifestName, data: manifest},
+		filepath.Base(opts.Module): {name: filepath.Base(opts.Module), data: module},
+	}
+
+	add := func(name string, entry *packEntry) error {
+		if _, exists := files[name]; exists {
+			return fmt.Errorf("%s is packed more than once", name)
+		}
+		files[name] = entry
+		return nil
+	}
+
+	for _, resource := range opts.Resources {
+		root := filepath.Clean(resource)
+		err := filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(filepath.Dir(root), p)
+			if nil != err {
+				return err
+			}
+			name := filepath.ToSlash(rel)
+
+			if entry.IsDir() {
+				return add(name, &packEntry{name: name, dir: true})
+			}
+
+			if !entry.Type().IsRegular() {
+				return fmt.Errorf("%s: only regular files and directories can be packed", p)
+			}
+
+			data, err := os.ReadFile(p)
+			if nil != err {
+				return err
+			}
+
+			return add(name, &packEntry{name: name, data: data})
+		})
+
+		if nil != err {
+			return nil, err
+		}
+	}
+
+	entries := make([]packEntry, 0, len(files))
+	for _, entry := range files {
+		entries = append(entries, *entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	// refuse layouts the engine could not load, such as a second .wasm without a modules field naming them
+	mfs := memFS{".": &memFile{name: ".", mode: fs.ModeDir | packDirMode}}
+	for _, entry := range entries {
+		if entry.dir {
+			mfs.mkdirAll(entry.name, packTime)
+			continue
+		}
+
+		mfs.mkdirAll(path.Dir(entry.name), packTime)
+		mfs[entry.name] = &memFile{name: entry.name, data: entry.data, mode: packFileMode, modTime: packTime}
+	}
+
+	if _, err := readPluginsFS(mfs); nil != err {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// packZip
+//
+// Writes the entries as a zip archive.
+func packZip(w io.Writer, entries []packEntry) error {
+	zw := zip.NewWriter(w)
+
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: packTime}
+		if entry.dir {
+			header.Name += "/"
+			header.Method = zip.Store
+			header.SetMode(fs.ModeDir | packDirMode)
+		} else {
+			header.SetMode(packFileMode)
+		}
+
+		fw, err := zw.CreateHeader(header)
+		if nil != err {
+			return err
+		}
+
+		if _, err := fw.Write(entry.data); nil != err {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// packTarGz
+//
+// Writes the entries as a gzip compressed tar archive. The gzip header carries no name or time.
+func packTarGz(w io.Writer, entries []packEntry) error {
+	gw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if nil != err {
+		return err
+	}
+	tw := tar.NewWriter(gw)
+
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Typeflag: tar.TypeReg,
+			Mode:     packFileMode,
+			Size:     int64(len(entry.data)),
+			ModTime:  packTime,
+		}
+		if entry.dir {
+			header.Name += "/"
+			header.Typeflag = tar.TypeDir
+			header.Mode = packDirMode
+		}
+
+		if err := tw.WriteHeader(header); nil != err {
+			return err
+		}
+
+		if _, err := tw.Write(entry.data); nil != err {
+			return err
+		}
+	}
+
+	if err := tw.Close(); nil != err {
+		return err
+	}
+
+	return gw.Close()
+}
+
+// Inspect
+//
+// This function reads a .zip or .tar.gz plugin archive held in r, which is size bytes long, without extracting it to
+// disk. It returns the manifests of the plugins in the archive, a listing of its files with their checksums and the
+// archive's digests.
+func Inspect(r io.ReaderAt, size int64) (*ArchiveInfo, error) {
+	format := FormatTarGz
+	magic := make([]byte, 2)
+	if _, err := r.ReadAt(magic, 0); nil == err && string(magic) == "PK" {
+		format = FormatZip
+	}
+
+	fsys, err := archiveFS(r, size)
+	if nil != err {
+		return nil, err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); nil != err {
+		return nil, err
+	}
+
+	info := &ArchiveInfo{
+		Format:  format,
+		SHA256:  hex.EncodeToString(h.Sum(nil)),
+		Plugins: make([]Plugin, 0),
+		Files:   make([]ArchiveEntry, 0),
+	}
+
+	info.ContentDigest, err = ContentDigest(fsys)
+	if nil != err {
+		return nil, err
+	}
+
+	plugs, err := readPluginsFS(fsys)
+	if nil != err {
+		return nil, err
+	}
+	for _, plug := range plugs {
+		info.Plugins = append(info.Plugins, plug.Details)
+	}
+
+	err = fs.WalkDir(fsys, ".", func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == "." {
+			return nil
+		}
+
+		fi, err := entry.Info()
+		if nil != err {
+			return err
+		}
+
+		item := ArchiveEntry{Name: p, Mode: fi.Mode()}
+		if entry.Type().IsRegular() {
+			data, err := fs.ReadFile(fsys, p)
+			if nil != err {
+				return err
+			}
+
+			sum := sha256.Sum256(data)
+			item.Size = int64(len(data))
+			item.SHA256 = hex.EncodeToString(sum[:])
+		}
+
+		info.Files = append(info.Files, item)
+		return nil
+	})
+
+	if nil != err {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// InspectFile
+//
+// Inspects the plugin archive at path, see Inspect.
+func InspectFile(path string) (*ArchiveInfo, error) {
+	f, err := os.Open(path)
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if nil != err {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return nil, errors.New(path + " is a directory, not a plugin archive")
+	}
+
+	return Inspect(f, fi.Size())
+}
@@ -0,0 +1,88 @@
+package pluginengine
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPackAndInspect(t *testing.T) {
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "plugin.yaml"), "id: test.packed\nversion: 1.0.0\n")
+	mustWrite(t, filepath.Join(dir, "packed.wasm"), "\x00asm")
+	mustWrite(t, filepath.Join(dir, "assets", "icons", "icon.svg"), "<svg/>")
+
+	opts := PackOptions{
+		Manifest:  filepath.Join(dir, "plugin.yaml"),
+		Module:    filepath.Join(dir, "packed.wasm"),
+		Resources: []string{filepath.Join(dir, "assets")},
+	}
+
+	for _, format := range []ArchiveFormat{FormatZip, FormatTarGz} {
+		first := &bytes.Buffer{}
+		if err := Pack(first, format, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		// touching the files must not change the archive
+		later := time.Now().Add(time.Hour)
+		if err := os.Chtimes(filepath.Join(dir, "packed.wasm"), later, later); err != nil {
+			t.Fatal(err)
+		}
+
+		second := &bytes.Buffer{}
+		if err := Pack(second, format, opts); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Errorf("Expected %s packing to be reproducible", format)
+		}
+
+		info, err := Inspect(bytes.NewReader(first.Bytes()), int64(first.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Format != format || len(info.Plugins) != 1 || info.Plugins[0].Id != "test.packed" {
+			t.Errorf("Unexpected inspection of %s archive: %+v", format, info)
+		}
+
+		names := make([]string, 0)
+		for _, f := range info.Files {
+			names = append(names, f.Name)
+			if f.Name == "packed.wasm" && (f.Size != 4 || f.SHA256 == "") {
+				t.Errorf("Expected a checksum for the module, got %+v", f)
+			}
+		}
+		expected := []string{"assets", "assets/icons", "assets/icons/icon.svg", "packed.wasm", "plugin.yaml"}
+		if len(names) != len(expected) {
+			t.Fatalf("Expected files %v, got %v", expected, names)
+		}
+		for i := range expected {
+			if names[i] != expected[i] {
+				t.Errorf("Expected files %v, got %v", expected, names)
+				break
+			}
+		}
+	}
+
+	// packed archives extract with the engine's own extraction
+	archive := filepath.Join(t.TempDir(), "test.packed.tgz")
+	if err := PackFile(archive, opts); err != nil {
+		t.Fatal(err)
+	}
+	out := t.TempDir()
+	if err := Untar(archive, out); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "assets", "icons", "icon.svg")); err != nil {
+		t.Error(err)
+	}
+
+	opts.Manifest = filepath.Join(dir, "assets", "icons", "icon.svg")
+	if err := Pack(&bytes.Buffer{}, FormatZip, opts); err == nil {
+		t.Error("Expected an error for a manifest without an id and version")
+	}
+}
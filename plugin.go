@@ -3,6 +3,10 @@ package pluginengine
 import pdk "github.com/spirefyio/plugin-go-pdk"
 
 type Plugin struct {
+	// The version of the manifest format this manifest is written in, see ManifestSchemaVersion. Optional, a manifest
+	// without one is version 1.
+	SchemaVersion int `json:"schemaVersion,omitempty" yaml:"schemaVersion,omitempty"`
+
 	// A unique id for this plugin. It may often contain the base id that anchors defined within the plugin
 	// contain. For example mycompany.plugins.MyPlugin  and an anchor of this plugin might have an id of
 	// mycompany.plugins.MyPlugin.MyAnchor
@@ -24,5 +28,103 @@ type Plugin struct {
 	// anchors.
 	Hooks []pdk.Hook `json:"hooks" yaml:"hooks"`
 
+	// Names of the events this plugin may publish on the engine event bus
+	Events []string `json:"events" yaml:"events"`
+
+	// A slice of listeners, exported functions this plugin wants called when a named event is published
+	Listeners []EventListener `json:"listeners" yaml:"listeners"`
+
+	// The wasm modules of this plugin, with paths relative to the manifest. Optional when the plugin's directory holds a
+	// single .wasm module. A plugin with more than one module names one of them main, the others are linked with it
+	// under their names so the main module can import their functions.
+	Modules []Module `json:"modules" yaml:"modules"`
+
+	// The settings this plugin can be configured with. Their values reach the plugin through extism config, see
+	// Engine.SetPluginConfig.
+	Settings []Setting `json:"settings" yaml:"settings"`
+
+	// Names of the secrets this plugin may ask for with the GetSecret host function, see Engine.SetSecretProvider
+	Secrets []string `json:"secrets" yaml:"secrets"`
+
+	// Hosts this plugin makes HTTP requests to, exact names or patterns such as *.example.com. Only the hosts the
+	// engine's host policy approves are reachable, see Engine.SetHostPolicy.
+	AllowedHosts []string `json:"allowedHosts" yaml:"allowedHosts"`
+
+	// The HTTP routes served by this plugin's hooks on the HTTPRoutesAnchor, one or more per hook, see
+	// Engine.HTTPHandler
+	Routes []Route `json:"routes" yaml:"routes"`
+
+	// Exported functions the engine calls on a cron schedule once the scheduler is started, see Engine.StartScheduler
+	Schedules []Schedule `json:"schedules" yaml:"schedules"`
+
+	// A stateless plugin keeps no state between calls, so the engine may run concurrent calls on a pool of instances,
+	// see Engine.SetPoolConfig. Plugins are stateful by default and have a single instance that runs one call at a time.
+	Stateless bool `json:"stateless" yaml:"stateless"`
+
 	LoadOnStart bool `json:"loadOnStart" yaml:"loadOnStart"`
 }
+
+// SettingType is the type of a setting's value, string when not given.
+type SettingType string
+
+const (
+	SettingString SettingType = "string"
+	SettingInt    SettingType = "int"
+	SettingFloat  SettingType = "float"
+	SettingBool   SettingType = "bool"
+)
+
+// Setting
+//
+// A configuration value a plugin declares. Every value reaches the plugin as a string through extism config, Type is
+// what the string must parse as. A setting without a Default and without a host supplied value is left out of the
+// plugin's config.
+type Setting struct {
+	Name        string      `json:"name" yaml:"name"`
+	Type        SettingType `json:"type,omitempty" yaml:"type,omitempty"`
+	Default     interface{} `json:"default,omitempty" yaml:"default,omitempty"`
+	Description string      `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// OverlapPolicy is what a schedule does when it fires while its previous run is still going.
+type OverlapPolicy string
+
+const (
+	// OverlapSkip skips the run, the default
+	OverlapSkip OverlapPolicy = "skip"
+	// OverlapQueue runs it once the previous runs are done
+	OverlapQueue OverlapPolicy = "queue"
+	// OverlapParallel runs it alongside the previous runs
+	OverlapParallel OverlapPolicy = "parallel"
+)
+
+// Schedule
+//
+// An exported function of a plugin called on a cron schedule, see parseCron for the expression syntax. Schedules fire
+// in the engine clock's local time. Jitter is a duration, such as 30s, up to which each run is delayed at random so
+// plugins on the same schedule do not all run at once.
+type Schedule struct {
+	Id      string        `json:"id" yaml:"id"`
+	Cron    string        `json:"cron" yaml:"cron"`
+	Func    string        `json:"func" yaml:"func"`
+	Overlap OverlapPolicy `json:"overlap,omitempty" yaml:"overlap,omitempty"`
+	Jitter  string        `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+}
+
+// Module
+//
+// A wasm module of a plugin. Name is the module name other modules of the plugin import it by.
+type Module struct {
+	Name string `json:"name" yaml:"name"`
+	Path string `json:"path" yaml:"path"`
+}
+
+// EventListener
+//
+// A listener declared in a plugin manifest. Func is the exported WASM function of the owning plugin that is called with
+// the event payload whenever the named Event is published.
+type EventListener struct {
+	Id    string `json:"id" yaml:"id"`
+	Event string `json:"event" yaml:"event"`
+	Func  string `json:"func" yaml:"func"`
+}
@@ -0,0 +1,547 @@
+package pluginengine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	extism "github.com/extism/go-sdk"
+	"github.com/tetratelabs/wazero"
+)
+
+const (
+	defaultPoolMax         = 4
+	defaultPoolIdleTimeout = 5 * time.Minute
+)
+
+// errPoolClosed is returned for checkouts from the pool of a plugin being stopped
+var errPoolClosed = errors.New("the plugin is being stopped")
+
+type (
+	// PoolConfig
+	//
+	// Sizes the instance pool of a stateless plugin. Min instances are kept once the plugin is first used, or when it is
+	// started if it loads on start, up to Max are created while calls run concurrently, and instances above Min that sit
+	// idle for IdleTimeout are closed. A zero Max is 4 and a zero IdleTimeout 5 minutes, a negative IdleTimeout never
+	// evicts. Stateful plugins always have a single instance that is never evicted.
+	PoolConfig struct {
+		Min         int           `json:"min" yaml:"min"`
+		Max         int           `json:"max" yaml:"max"`
+		IdleTimeout time.Duration `json:"idleTimeout" yaml:"idleTimeout"`
+	}
+
+	// instancePool
+	//
+	// The extism instances of one plugin version. Calls check an instance out, so an instance only ever runs one call
+	// at a time, and return it when done. All instances share a compilation cache so the module is only compiled once.
+	// Instances get config changes before their next call, see configGen.
+	instancePool struct {
+		e *Engine
+		p *plugin
+
+		mu   sync.Mutex
+		cond *sync.Cond
+		// idle instances, the most recently used last
+		idle []*pooledInstance
+		// instances created or being created, idle or checked out
+		size     int
+		cfg      PoolConfig
+		cache    wazero.CompilationCache
+		closed   bool
+		done     chan struct{}
+		sweeping bool
+		// bumped when the plugin's config changes, instances created at an older generation get it on checkout
+		configGen int
+		// consecutive crashes and failed restarts, no instances are created before retryAt while restarting
+		crashes    int
+		retryAt    time.Time
+		restarting bool
+
+		// bytes of wasm memory the instances hold, see measure
+		memory int64
+	}
+
+	pooledInstance struct {
+		*extism.Plugin
+		configGen int
+		lastUsed  time.Time
+		// bytes of wasm memory when last measured
+		memory int64
+	}
+)
+
+// SetPoolConfig
+//
+// Sets the instance pool size of the stateless plugin with the given id, all its versions. An empty id sets the pool
+// config of plugins that have none of their own. Pools already created are resized, instances above a lowered Max are
+// closed as they are returned.
+func (e *Engine) SetPoolConfig(id string, cfg PoolConfig) {
+	e.mu.Lock()
+	e.poolConfigs[id] = cfg
+	plugs := make([]*plugin, 0)
+	for pid, pv := range e.plugins {
+		if id == "" || pid == id {
+			for _, p := range pv {
+				plugs = append(plugs, p)
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	for _, p := range plugs {
+		p.mu.Lock()
+		pool := p.pool
+		p.mu.Unlock()
+
+		if nil != pool {
+			pool.mu.Lock()
+			pool.cfg = e.poolConfig(p)
+			pool.cond.Broadcast()
+			pool.mu.Unlock()
+		}
+	}
+}
+
+// poolConfig
+//
+// Returns the effective pool config of a plugin.
+func (e *Engine) poolConfig(p *plugin) PoolConfig {
+	if !p.Details.Stateless {
+		return PoolConfig{Min: 1, Max: 1, IdleTimeout: -1}
+	}
+
+	e.mu.RLock()
+	cfg, ok := e.poolConfigs[p.Details.Id]
+	if !ok {
+		cfg = e.poolConfigs[""]
+	}
+	e.mu.RUnlock()
+
+	if cfg.Max <= 0 {
+		cfg.Max = defaultPoolMax
+	}
+	if cfg.Min > cfg.Max {
+		cfg.Min = cfg.Max
+	}
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = defaultPoolIdleTimeout
+	}
+
+	return cfg
+}
+
+// poolFor
+//
+// Returns the plugin's instance pool, creating it on first use.
+func (e *Engine) poolFor(p *plugin) *instancePool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if nil == p.pool {
+		pool := &instancePool{
+			e:     e,
+			p:     p,
+			cfg:   e.poolConfig(p),
+			cache: wazero.NewCompilationCache(),
+			done:  make(chan struct{}),
+		}
+		pool.cond = sync.NewCond(&pool.mu)
+		p.pool = pool
+	}
+
+	return p.pool
+}
+
+// callPlugin
+//
+// Calls an exported function of a plugin on an instance checked out of its pool. name is the hook, listener or
+// schedule being called, for per-hook circuit breakers. An instance that traps is discarded and the plugin restarted,
+// see crashed, and traps and failures to instantiate count towards opening the circuit breaker. The plugin's host
+// functions are called with ctx extended with this call, see withCall. Every call is recorded in the engine's metrics.
+func (e *Engine) callPlugin(ctx context.Context, p *plugin, name, fn string, data []byte) (out []byte, err error) {
+	ran := false
+	var took time.Duration
+	defer func() { e.recordCall(p, name, ran, took, err) }()
+
+	if err := e.breakerAllow(p, name); nil != err {
+		return nil, err
+	}
+
+	pool := e.poolFor(p)
+
+	inst, err := pool.get(ctx)
+	if nil != err {
+		// the caller giving up on waiting for an instance is no fault of the plugin's
+		if !errors.Is(err, ErrPluginUnavailable) && !errors.Is(err, errPoolClosed) && nil == ctx.Err() {
+			e.breakerRecord(p, name, err)
+		} else {
+			e.breakerRelease(p, name)
+		}
+		return nil, err
+	}
+	pool.report()
+
+	if span := spanOf(ctx); nil != span && nil != inst.TraceCtx && span.TraceID() != "" {
+		// function level wasm traces join the trace of the call
+		if err := inst.TraceCtx.SetTraceId(span.TraceID()); nil != err {
+			logln("Error setting the observe trace id: ", err)
+		}
+	}
+
+	started := time.Now()
+	_, out, err = inst.CallWithContext(withCall(ctx, p, name), fn, data)
+	took, ran = time.Since(started), true
+
+	if isTrap(err) {
+		pool.drop(inst)
+		e.crashed(pool, err)
+		e.breakerRecord(p, name, err)
+		return nil, err
+	}
+
+	pool.measure(inst)
+	pool.put(inst)
+	e.breakerRecord(p, name, nil)
+
+	return out, err
+}
+
+// ensureInstance
+//
+// Makes sure the plugin has its minimum number of instances, at least one.
+func (e *Engine) ensureInstance(ctx context.Context, p *plugin) error {
+	return e.poolFor(p).fill(ctx)
+}
+
+// fill
+//
+// Creates instances until the pool has its minimum number, at least one.
+func (pool *instancePool) fill(ctx context.Context) error {
+	pool.mu.Lock()
+	want := pool.cfg.Min
+	if want < 1 {
+		want = 1
+	}
+	missing := want - pool.size
+	pool.mu.Unlock()
+
+	insts := make([]*pooledInstance, 0, missing)
+	defer func() {
+		for _, inst := range insts {
+			pool.put(inst)
+		}
+	}()
+
+	for i := 0; i < missing; i++ {
+		pool.mu.Lock()
+		grow := pool.size < want && pool.size < pool.cfg.Max && !pool.closed
+		if grow {
+			pool.size++
+		}
+		pool.mu.Unlock()
+
+		if !grow {
+			return nil
+		}
+
+		inst, err := pool.create(ctx)
+		if nil != err {
+			return err
+		}
+		insts = append(insts, inst)
+	}
+
+	return nil
+}
+
+// instances returns the number of instances the plugin has
+func (p *plugin) instances() int {
+	p.mu.Lock()
+	pool := p.pool
+	p.mu.Unlock()
+
+	if nil == pool {
+		return 0
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	return pool.size
+}
+
+// get
+//
+// Checks out an idle instance, creates one when the pool is below its max, or waits for one to be returned. Waiting
+// ends with ctx's error when ctx is done first.
+func (pool *instancePool) get(ctx context.Context) (*pooledInstance, error) {
+	// wakes the waiting checkouts once ctx is done, set up when this one first waits
+	var stop func() bool
+
+	pool.mu.Lock()
+	for {
+		if pool.closed {
+			pool.mu.Unlock()
+			return nil, errPoolClosed
+		}
+
+		if n := len(pool.idle); n > 0 {
+			inst := pool.idle[n-1]
+			pool.idle = pool.idle[:n-1]
+			gen := pool.configGen
+			pool.mu.Unlock()
+
+			if inst.configGen != gen {
+				pool.configure(inst, gen)
+			}
+			return inst, nil
+		}
+
+		if pool.size < pool.cfg.Max {
+			if pool.restarting {
+				if wait := pool.retryAt.Sub(pool.e.getClock().Now()); wait > 0 {
+					pool.mu.Unlock()
+					return nil, fmt.Errorf("%w: %s@%s is restarting, retry in %s", ErrPluginUnavailable, pool.p.Details.Id, pool.p.Details.Version, wait)
+				}
+			}
+			pool.size++
+			pool.mu.Unlock()
+			return pool.create(ctx)
+		}
+
+		if err := ctx.Err(); nil != err {
+			// hand on a wake up meant for an instance this checkout no longer takes
+			pool.cond.Signal()
+			pool.mu.Unlock()
+			return nil, err
+		}
+
+		if nil == stop {
+			stop = context.AfterFunc(ctx, func() {
+				pool.mu.Lock()
+				pool.cond.Broadcast()
+				pool.mu.Unlock()
+			})
+			defer stop()
+		}
+
+		pool.cond.Wait()
+	}
+}
+
+// create
+//
+// Instantiates a new instance for a slot already counted in size, giving the slot back if it fails.
+func (pool *instancePool) create(ctx context.Context) (*pooledInstance, error) {
+	pool.mu.Lock()
+	gen := pool.configGen
+	pool.mu.Unlock()
+
+	inst, err := pool.e.instantiate(ctx, pool.p, pool.cache)
+	if nil != err {
+		pool.mu.Lock()
+		pool.size--
+		last := pool.closed && pool.size == 0
+		pool.cond.Signal()
+		pool.mu.Unlock()
+
+		if last {
+			pool.closeCache()
+		}
+		return nil, err
+	}
+
+	pi := &pooledInstance{Plugin: inst, configGen: gen}
+	pool.measure(pi)
+
+	return pi, nil
+}
+
+// put
+//
+// Returns a checked out instance to the pool, closing it instead when the pool is closed or above its max.
+func (pool *instancePool) put(inst *pooledInstance) {
+	clock := pool.e.getClock()
+
+	pool.mu.Lock()
+	if pool.closed || pool.size > pool.cfg.Max {
+		pool.mu.Unlock()
+		pool.discard(inst)
+		return
+	}
+
+	inst.lastUsed = clock.Now()
+	pool.idle = append(pool.idle, inst)
+	pool.cond.Signal()
+
+	sweep := !pool.sweeping && pool.cfg.IdleTimeout > 0 && pool.size > pool.cfg.Min
+	if sweep {
+		pool.sweeping = true
+	}
+	pool.mu.Unlock()
+
+	pool.report()
+
+	if sweep {
+		go pool.sweep(clock)
+	}
+}
+
+// sweep
+//
+// Closes instances above the pool's min that have been idle for its idle timeout, for as long as the pool has more
+// instances than its min.
+func (pool *instancePool) sweep(clock Clock) {
+	for {
+		pool.mu.Lock()
+		timeout := pool.cfg.IdleTimeout
+		if timeout <= 0 {
+			pool.sweeping = false
+			pool.mu.Unlock()
+			return
+		}
+		pool.mu.Unlock()
+
+		select {
+		case <-clock.After(timeout):
+		case <-pool.done:
+			return
+		}
+
+		now := clock.Now()
+		evicted := make([]*pooledInstance, 0)
+
+		pool.mu.Lock()
+		// the least recently used instances are first
+		for len(pool.idle) > 0 && pool.size-len(evicted) > pool.cfg.Min && now.Sub(pool.idle[0].lastUsed) >= timeout {
+			evicted = append(evicted, pool.idle[0])
+			pool.idle = pool.idle[1:]
+		}
+		more := !pool.closed && pool.cfg.IdleTimeout > 0 && pool.size-len(evicted) > pool.cfg.Min
+		if !more {
+			pool.sweeping = false
+		}
+		pool.mu.Unlock()
+
+		for _, inst := range evicted {
+			pool.discard(inst)
+		}
+
+		if !more {
+			return
+		}
+	}
+}
+
+// discard
+//
+// Closes an instance that left the pool, calling the plugin's stop export first, and frees its slot. The compilation
+// cache is closed with the last instance of a closed pool.
+func (pool *instancePool) discard(inst *pooledInstance) {
+	if inst.FunctionExists("stop") {
+		if _, _, err := inst.CallWithContext(pool.e.context, "stop", nil); nil != err {
+			logln("Error calling plugin stop: ", err)
+		}
+	}
+
+	pool.drop(inst)
+}
+
+// drop
+//
+// Closes an instance that left the pool without calling its stop export, as for an instance that trapped, and frees
+// its slot.
+func (pool *instancePool) drop(inst *pooledInstance) {
+	if err := inst.CloseWithContext(pool.e.context); nil != err {
+		logln("Error closing plugin: ", err)
+	}
+
+	pool.mu.Lock()
+	pool.size--
+	pool.memory -= inst.memory
+	last := pool.closed && pool.size == 0
+	pool.cond.Signal()
+	pool.mu.Unlock()
+
+	pool.report()
+
+	if last {
+		pool.closeCache()
+	}
+}
+
+// close
+//
+// Closes the pool and its idle instances. Instances checked out are closed as they are returned and waiting checkouts
+// fail.
+func (pool *instancePool) close() {
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		return
+	}
+	pool.closed = true
+	close(pool.done)
+	idle := pool.idle
+	pool.idle = nil
+	empty := pool.size == 0
+	pool.cond.Broadcast()
+	pool.mu.Unlock()
+
+	for _, inst := range idle {
+		pool.discard(inst)
+	}
+
+	if empty {
+		pool.closeCache()
+		pool.report()
+	}
+}
+
+func (pool *instancePool) closeCache() {
+	if err := pool.cache.Close(pool.e.context); nil != err {
+		logln("Error closing cache: ", err)
+	}
+}
+
+// reconfigure
+//
+// Marks every instance as needing the plugin's current config and hands it to the idle ones straight away.
+func (pool *instancePool) reconfigure() {
+	pool.mu.Lock()
+	pool.configGen++
+	gen := pool.configGen
+	idle := pool.idle
+	pool.idle = nil
+	pool.mu.Unlock()
+
+	for _, inst := range idle {
+		pool.configure(inst, gen)
+		pool.put(inst)
+	}
+}
+
+// configure
+//
+// Gives an instance the plugin's current config and calls its configChanged export if it has one.
+func (pool *instancePool) configure(inst *pooledInstance, gen int) {
+	config := pool.e.pluginConfig(pool.p)
+
+	inst.Config = config
+	inst.configGen = gen
+
+	if inst.FunctionExists(configChangedFunc) {
+		data, err := json.Marshal(config)
+		if nil != err {
+			logln("Error marshalling plugin config: ", err)
+			return
+		}
+
+		if _, _, err := inst.CallWithContext(pool.e.context, configChangedFunc, data); nil != err {
+			logln("Error calling plugin configChanged: ", pool.p.Details.Id, err)
+		}
+	}
+}
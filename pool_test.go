@@ -0,0 +1,101 @@
+package pluginengine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInstancePool(t *testing.T) {
+	e := newTestEngine(t)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	e.SetClock(clock)
+	e.SetPoolConfig("test.pooled", PoolConfig{Min: 1, Max: 2, IdleTimeout: time.Minute})
+
+	p := &plugin{ModuleData: []byte(exportingModule)}
+	e.mu.Lock()
+	e.addPlugin(p, Plugin{Id: "test.pooled", Version: "1.0.0", Stateless: true})
+	e.mu.Unlock()
+
+	pool := e.poolFor(p)
+	first, err := pool.get(e.context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pool.get(e.context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second || p.instances() != 2 {
+		t.Fatalf("Expected two instances, got %d", p.instances())
+	}
+
+	// a third checkout waits for an instance to be returned
+	got := make(chan *pooledInstance)
+	go func() {
+		inst, _ := pool.get(e.context)
+		got <- inst
+	}()
+
+	select {
+	case <-got:
+		t.Fatal("Expected the checkout to wait while the pool is at its max")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// a checkout gives up waiting when its context is done
+	ctx, cancel := context.WithTimeout(e.context, 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the checkout to fail with context.DeadlineExceeded, got %v", err)
+	}
+
+	pool.put(first)
+	if third := <-got; third != first {
+		t.Error("Expected the returned instance to be checked out again")
+	}
+	pool.put(first)
+	pool.put(second)
+
+	if _, err := e.callPlugin(e.context, p, "run", "run", nil); err != nil {
+		t.Errorf("Expected the call to succeed, got %v", err)
+	}
+
+	// the instance above the min is evicted once idle for the timeout
+	waitFor(t, "the sweep to wait", func() bool { return clock.Waiters() == 1 })
+	clock.Advance(time.Minute)
+	waitFor(t, "the idle instance to be evicted", func() bool { return p.instances() == 1 })
+
+	if err := e.stopPlugin(p); err != nil {
+		t.Fatal(err)
+	}
+	if p.instances() != 0 {
+		t.Errorf("Expected no instances after stopping, got %d", p.instances())
+	}
+	if _, err := pool.get(e.context); err != errPoolClosed {
+		t.Errorf("Expected errPoolClosed, got %v", err)
+	}
+}
+
+func TestStatefulPool(t *testing.T) {
+	e := newTestEngine(t)
+	e.SetPoolConfig("", PoolConfig{Min: 2, Max: 8})
+
+	p := &plugin{ModuleData: []byte(exportingModule)}
+	e.mu.Lock()
+	e.addPlugin(p, Plugin{Id: "test.stateful", Version: "1.0.0"})
+	e.mu.Unlock()
+
+	if cfg := e.poolConfig(p); cfg != (PoolConfig{Min: 1, Max: 1, IdleTimeout: -1}) {
+		t.Errorf("Expected a stateful plugin to have a single instance, got %+v", cfg)
+	}
+
+	if err := e.ensureInstance(e.context, p); err != nil {
+		t.Fatal(err)
+	}
+	if p.instances() != 1 {
+		t.Errorf("Expected one instance, got %d", p.instances())
+	}
+	_ = e.stopPlugin(p)
+}
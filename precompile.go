@@ -0,0 +1,175 @@
+package pluginengine
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// SetPrecompile
+//
+// Sets how many wasm modules Load, LoadFS and LoadArchive compile at once before returning, so the first call into a
+// plugin does not pay for compiling it. Modules are compiled into the compilation cache the plugin's instances share,
+// separately from instantiating them. 0, the default, compiles each plugin when it is first instantiated.
+func (e *Engine) SetPrecompile(workers int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.precompileWorkers = workers
+}
+
+// precompile
+//
+// Compiles the modules of the plugins on the engine's precompile workers, if it has any. Modules that fail to compile
+// are logged, the error is returned again when the plugin is instantiated.
+func (e *Engine) precompile(ctx context.Context, plugs []*plugin) {
+	e.mu.RLock()
+	workers := e.precompileWorkers
+	e.mu.RUnlock()
+
+	if workers <= 0 {
+		return
+	}
+
+	forEachConcurrently(plugs, workers, func(p *plugin) {
+		if err := e.compile(ctx, p); nil != err {
+			logln("Error precompiling plugin ", p.Details.Id+"@"+p.Details.Version, ": ", err)
+		}
+	})
+}
+
+// compile
+//
+// Compiles a plugin's main and linked modules into the compilation cache of its instance pool. The go-sdk this engine
+// builds against (v1.6.0) has no extism.NewCompiledPlugin to compile a plugin ahead of instantiating it, so the
+// modules are compiled with wazero directly. This only pays off because instantiate hands extism the same pool.cache
+// in its runtime config: wazero keys the cache by module content, so the modules extism compiles when the plugin is
+// instantiated are found already compiled. The runtime used here is closed straight away, the compiled code stays in
+// the cache.
+func (e *Engine) compile(ctx context.Context, p *plugin) (err error) {
+	ctx, span := e.startSpan(ctx, "pluginengine.compile", pluginAttrs(p)...)
+	defer func() { endSpan(span, err) }()
+
+	pool := e.poolFor(p)
+
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(pool.cache))
+	defer r.Close(ctx)
+
+	modules := [][]byte{p.ModuleData}
+	paths := []string{p.PathToModule}
+	for _, m := range p.Linked {
+		modules = append(modules, m.Data)
+		paths = append(paths, m.Path)
+	}
+
+	for i, data := range modules {
+		if nil == data {
+			var err error
+			if data, err = os.ReadFile(paths[i]); nil != err {
+				return err
+			}
+		}
+
+		if _, err := r.CompileModule(ctx, data); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// startLevels
+//
+// Orders plugins for starting into levels. A plugin depends on the plugins defining the anchors its hooks attach to and
+// is in a later level than all of them, plugins in the same level do not depend on each other. Plugins in a dependency
+// cycle are logged and go in a last level together. The caller must hold the engine lock.
+func (e *Engine) startLevels(plugs []*plugin) [][]*plugin {
+	starting := make(map[*plugin]bool, len(plugs))
+	for _, p := range plugs {
+		starting[p] = true
+	}
+
+	deps := make(map[*plugin]map[*plugin]bool, len(plugs))
+	for _, p := range plugs {
+		deps[p] = make(map[*plugin]bool)
+		for _, hk := range p.Details.Hooks {
+			for _, achr := range e.anchors[hk.Anchor] {
+				if nil != achr.Plugin && achr.Plugin != p && starting[achr.Plugin] {
+					deps[p][achr.Plugin] = true
+				}
+			}
+		}
+	}
+
+	levels := make([][]*plugin, 0)
+	started := make(map[*plugin]bool, len(plugs))
+	for len(started) < len(plugs) {
+		level := make([]*plugin, 0)
+		for _, p := range plugs {
+			if started[p] {
+				continue
+			}
+			ready := true
+			for dep := range deps[p] {
+				ready = ready && started[dep]
+			}
+			if ready {
+				level = append(level, p)
+			}
+		}
+
+		if len(level) == 0 {
+			for _, p := range plugs {
+				if !started[p] {
+					logln("Plugin ", p.Details.Id+"@"+p.Details.Version, " is in a dependency cycle, starting it last")
+					level = append(level, p)
+				}
+			}
+		}
+
+		sort.Slice(level, func(i, j int) bool {
+			if level[i].Details.Id != level[j].Details.Id {
+				return level[i].Details.Id < level[j].Details.Id
+			}
+			return level[i].Details.Version < level[j].Details.Version
+		})
+
+		for _, p := range level {
+			started[p] = true
+		}
+		levels = append(levels, level)
+	}
+
+	return levels
+}
+
+// startWorkers is how many plugins Start instantiates at once
+func startWorkers() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+// forEachConcurrently
+//
+// Calls fn with each plugin, running up to workers calls at once, and returns when all of them have.
+func forEachConcurrently(plugs []*plugin, workers int, fn func(p *plugin)) {
+	sem := make(chan struct{}, workers)
+	wg := sync.WaitGroup{}
+
+	for _, p := range plugs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(p *plugin) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(p)
+		}(p)
+	}
+
+	wg.Wait()
+}
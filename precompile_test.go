@@ -0,0 +1,90 @@
+package pluginengine
+
+import (
+	"testing"
+	"testing/fstest"
+
+	pdk "github.com/spirefyio/plugin-go-pdk"
+)
+
+func TestStartLevels(t *testing.T) {
+	e := newTestEngine(t)
+
+	plugs := map[string]*plugin{}
+	add := func(id string, anchors []string, hooks []string) {
+		details := Plugin{Id: id, Version: "1.0.0"}
+		for _, a := range anchors {
+			details.Anchors = append(details.Anchors, pdk.Anchor{Id: a})
+		}
+		for i, a := range hooks {
+			details.Hooks = append(details.Hooks, pdk.Hook{Id: id + ".hook" + string(rune('a'+i)), Anchor: a, Func: "run"})
+		}
+		plugs[id] = &plugin{}
+		e.addPlugin(plugs[id], details)
+	}
+
+	e.mu.Lock()
+	add("test.base", []string{"test.base.menu"}, nil)
+	add("test.alone", nil, nil)
+	add("test.middle", []string{"test.middle.menu"}, []string{"test.base.menu"})
+	add("test.top", nil, []string{"test.middle.menu", "test.base.menu"})
+	add("test.cycle.a", []string{"test.cycle.a.menu"}, []string{"test.cycle.b.menu"})
+	add("test.cycle.b", []string{"test.cycle.b.menu"}, []string{"test.cycle.a.menu"})
+
+	all := make([]*plugin, 0, len(plugs))
+	for _, p := range plugs {
+		all = append(all, p)
+	}
+	levels := e.startLevels(all)
+	e.mu.Unlock()
+
+	expected := [][]string{{"test.alone", "test.base"}, {"test.middle"}, {"test.top"}, {"test.cycle.a", "test.cycle.b"}}
+	if len(levels) != len(expected) {
+		t.Fatalf("Expected %d levels, got %d", len(expected), len(levels))
+	}
+	for i, level := range levels {
+		ids := make([]string, 0, len(level))
+		for _, p := range level {
+			ids = append(ids, p.Details.Id)
+		}
+		if len(ids) != len(expected[i]) {
+			t.Errorf("Level %d: expected %v, got %v", i, expected[i], ids)
+			continue
+		}
+		for j := range ids {
+			if ids[j] != expected[i][j] {
+				t.Errorf("Level %d: expected %v, got %v", i, expected[i], ids)
+				break
+			}
+		}
+	}
+}
+
+func TestPrecompile(t *testing.T) {
+	e := newTestEngine(t)
+	e.SetPrecompile(2)
+
+	fsys := fstest.MapFS{
+		"good/plugin.yaml": {Data: []byte("id: test.precompiled\nversion: 1.0.0\nstateless: true\n")},
+		"good/good.wasm":   {Data: []byte(exportingModule)},
+	}
+	if err := e.LoadFS(fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	p := e.plugins["test.precompiled"]["1.0.0"]
+	if nil == p || nil == p.pool {
+		t.Fatal("Expected the plugin to have a pool holding its compiled module")
+	}
+	if p.instances() != 0 {
+		t.Errorf("Expected precompiling not to instantiate, got %d instances", p.instances())
+	}
+	if _, err := e.callPlugin(e.context, p, "run", "run", nil); err != nil {
+		t.Errorf("Expected the precompiled plugin to run, got %v", err)
+	}
+
+	broken := &plugin{ModuleData: []byte("\x00asm\x01\x00\x00\x00\xff")}
+	if err := e.compile(e.context, broken); err == nil {
+		t.Error("Expected an invalid module to fail to compile")
+	}
+}
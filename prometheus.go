@@ -0,0 +1,207 @@
+package pluginengine
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	promCounter   = "counter"
+	promGauge     = "gauge"
+	promHistogram = "histogram"
+)
+
+type (
+	// PrometheusMetrics
+	//
+	// Metrics kept in memory and written in the Prometheus text exposition format, by WriteTo or by serving it as an
+	// http.Handler to be scraped. A metric's type is set by how it is first recorded.
+	PrometheusMetrics struct {
+		mu       sync.Mutex
+		buckets  []float64
+		families map[string]*promFamily
+	}
+
+	promFamily struct {
+		kind   string
+		series map[string]*promSeries
+	}
+
+	// promSeries is a metric with one set of label values, labels are its label pairs sorted by name
+	promSeries struct {
+		labels []string
+		value  float64
+		counts []uint64
+		sum    float64
+		count  uint64
+	}
+)
+
+// NewPrometheusMetrics
+//
+// Returns PrometheusMetrics with no metrics recorded. Histograms have the buckets given, upper bounds in ascending
+// order, or DefaultDurationBuckets.
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultDurationBuckets
+	}
+
+	return &PrometheusMetrics{
+		buckets:  append([]float64(nil), buckets...),
+		families: make(map[string]*promFamily),
+	}
+}
+
+func (m *PrometheusMetrics) Add(name string, labels Labels, delta float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.series(name, promCounter, labels).value += delta
+}
+
+func (m *PrometheusMetrics) Set(name string, labels Labels, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.series(name, promGauge, labels).value = value
+}
+
+func (m *PrometheusMetrics) Observe(name string, labels Labels, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.series(name, promHistogram, labels)
+	if nil == s.counts {
+		s.counts = make([]uint64, len(m.buckets))
+	}
+	for i, bound := range m.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// series returns the series of a metric for the labels, creating it and the metric as needed. The caller must hold mu.
+func (m *PrometheusMetrics) series(name, kind string, labels Labels) *promSeries {
+	family := m.families[name]
+	if nil == family {
+		family = &promFamily{kind: kind, series: make(map[string]*promSeries)}
+		m.families[name] = family
+	}
+
+	pairs := labelPairs(labels)
+	key := strings.Join(pairs, ",")
+	s := family.series[key]
+	if nil == s {
+		s = &promSeries{labels: pairs}
+		family.series[key] = s
+	}
+
+	return s
+}
+
+// WriteTo
+//
+// Writes the metrics in the Prometheus text exposition format, sorted by name and labels.
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		family := m.families[name]
+		bw.WriteString("# TYPE " + name + " " + family.kind + "\n")
+
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			s := family.series[key]
+			if family.kind != promHistogram {
+				bw.WriteString(name + formatPairs(s.labels) + " " + formatFloat(s.value) + "\n")
+				continue
+			}
+
+			for i, bound := range m.buckets {
+				bucket := formatPairs(s.labels, `le="`+formatFloat(bound)+`"`)
+				bw.WriteString(name + "_bucket" + bucket + " " + strconv.FormatUint(s.counts[i], 10) + "\n")
+			}
+			bw.WriteString(name + "_bucket" + formatPairs(s.labels, `le="+Inf"`) + " " + strconv.FormatUint(s.count, 10) + "\n")
+			bw.WriteString(name + "_sum" + formatPairs(s.labels) + " " + formatFloat(s.sum) + "\n")
+			bw.WriteString(name + "_count" + formatPairs(s.labels) + " " + strconv.FormatUint(s.count, 10) + "\n")
+		}
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP writes the metrics for a Prometheus scrape
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := m.WriteTo(w); nil != err {
+		logln("Error writing metrics: ", err)
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// labelPairs returns the labels as name="value" pairs sorted by name, with the values escaped
+func labelPairs(labels Labels) []string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+		pairs = append(pairs, name+`="`+value+`"`)
+	}
+	sort.Strings(pairs)
+
+	return pairs
+}
+
+// formatPairs returns label pairs, followed by the extra pairs, as written after a metric name
+func formatPairs(pairs []string, extra ...string) string {
+	if len(pairs)+len(extra) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(append(append(make([]string, 0, len(pairs)+len(extra)), pairs...), extra...), ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
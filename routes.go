@@ -0,0 +1,323 @@
+package pluginengine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// HTTPRoutesAnchor is the id of the built-in host anchor plugins attach hooks to in order to serve HTTP routes, see
+// Engine.HTTPHandler.
+const HTTPRoutesAnchor = "pluginengine.http.routes"
+
+const (
+	defaultMaxRequestBytes  = 1 << 20
+	defaultMaxResponseBytes = 10 << 20
+)
+
+// the methods a route may be limited to, as in the request line
+var methodPattern = regexp.MustCompile(`^[A-Z]+$`)
+
+type (
+	// Route
+	//
+	// Declares the HTTP requests a hook on the HTTPRoutesAnchor serves. Path is a pattern of '/' separated segments,
+	// where {name} matches any one segment and a final {name...} matches the rest of the path, including nothing. An empty
+	// Method serves every method.
+	Route struct {
+		Hook   string `json:"hook" yaml:"hook"`
+		Method string `json:"method,omitempty" yaml:"method,omitempty"`
+		Path   string `json:"path" yaml:"path"`
+	}
+
+	// RouteRequest
+	//
+	// The JSON payload a route hook is called with. Headers with more than one value are joined with ", ". Body is
+	// base64 encoded in JSON.
+	RouteRequest struct {
+		Method  string            `json:"method"`
+		Path    string            `json:"path"`
+		Query   string            `json:"query,omitempty"`
+		Params  map[string]string `json:"params,omitempty"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Body    []byte            `json:"body,omitempty"`
+	}
+
+	// RouteResponse
+	//
+	// The JSON a route hook returns. A zero Status is 200, and a hook returning nothing answers 204.
+	RouteResponse struct {
+		Status  int               `json:"status,omitempty"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Body    []byte            `json:"body,omitempty"`
+	}
+
+	// RouteLimits
+	//
+	// The largest request body passed to a route hook and the largest response body accepted from one, in bytes. Larger
+	// requests are answered 413 and larger responses 502.
+	RouteLimits struct {
+		MaxRequestBytes  int64 `json:"maxRequestBytes" yaml:"maxRequestBytes"`
+		MaxResponseBytes int64 `json:"maxResponseBytes" yaml:"maxResponseBytes"`
+	}
+
+	// routeMatch is a route that matches a request path and the hook serving it
+	routeMatch struct {
+		route  Route
+		params map[string]string
+	}
+)
+
+// SetRouteLimits
+//
+// Sets the body size limits of Engine.HTTPHandler. A zero limit keeps the default of 1 MiB for requests and 10 MiB for
+// responses.
+func (e *Engine) SetRouteLimits(limits RouteLimits) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.routeLimits = limits
+}
+
+// HTTPHandler
+//
+// This method returns an http.Handler serving the routes of the hooks attached to the HTTPRoutesAnchor. Each request is
+// passed to the matching hook as a RouteRequest and its RouteResponse is written back. Routes are looked up on every
+// request so plugins loaded, reloaded or unloaded afterwards are served without getting a new handler. When more than
+// one route matches a path the one with the most literal segments wins, a path that matches only routes for other
+// methods is answered 405 and one that matches nothing 404. A plugin that is unavailable, see RestartPolicy, is answered
+// 503 and a call over a limit, see Limit, 429.
+func (e *Engine) HTTPHandler() http.Handler {
+	return http.HandlerFunc(e.serveRoute)
+}
+
+func (e *Engine) serveRoute(w http.ResponseWriter, r *http.Request) {
+	e.mu.RLock()
+	limits := e.routeLimits
+	matches := e.matchRoutes(r.URL.Path)
+	e.mu.RUnlock()
+
+	if limits.MaxRequestBytes <= 0 {
+		limits.MaxRequestBytes = defaultMaxRequestBytes
+	}
+	if limits.MaxResponseBytes <= 0 {
+		limits.MaxResponseBytes = defaultMaxResponseBytes
+	}
+
+	var match *routeMatch
+	allow := make([]string, 0)
+	for i := range matches {
+		if matches[i].route.Method == "" || matches[i].route.Method == r.Method {
+			match = &matches[i]
+			break
+		}
+		allow = append(allow, matches[i].route.Method)
+	}
+
+	if nil == match {
+		if len(allow) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		sort.Strings(allow)
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limits.MaxRequestBytes))
+	if nil != err {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	headers := make(map[string]string, len(r.Header))
+	for k, v := range r.Header {
+		headers[k] = strings.Join(v, ", ")
+	}
+
+	payload, err := json.Marshal(RouteRequest{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Params:  match.params,
+		Headers: headers,
+		Body:    body,
+	})
+	if nil != err {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	out, err := e.CallHookFunc(match.route.Hook, payload)
+	if nil != err {
+		logln("Error calling route hook: ", match.route.Hook, err)
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrPluginUnavailable):
+			status = http.StatusServiceUnavailable
+		case errors.Is(err, ErrRateLimited), errors.Is(err, ErrTooManyInFlight):
+			status = http.StatusTooManyRequests
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	if len(out) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	resp := RouteResponse{}
+	if err := json.Unmarshal(out, &resp); nil != err {
+		logln("Invalid response from route hook: ", match.route.Hook, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	if int64(len(resp.Body)) > limits.MaxResponseBytes {
+		logln("Response from route hook ", match.route.Hook, " is larger than ", limits.MaxResponseBytes, " bytes")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+	if resp.Status < 100 || resp.Status > 999 {
+		logln("Invalid status from route hook: ", match.route.Hook, resp.Status)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	for k, v := range resp.Headers {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(resp.Status)
+	_, _ = w.Write(resp.Body)
+}
+
+// matchRoutes
+//
+// Returns the routes of resolved hooks on the HTTPRoutesAnchor matching path, most specific first. Only the routes of
+// the plugin version a hook id is called on count, so a route an older version still loaded declares is not served by
+// the newer version's code. The caller must hold the engine lock.
+func (e *Engine) matchRoutes(path string) []routeMatch {
+	matches := make([]routeMatch, 0)
+	for _, achr := range e.anchors[HTTPRoutesAnchor] {
+		for _, hk := range achr.Hooks {
+			if nil == hk.Plugin || e.hooks[hk.Id] != hk {
+				continue
+			}
+			for _, route := range hk.Plugin.Details.Routes {
+				if route.Hook != hk.Id {
+					continue
+				}
+				if params, ok := matchPath(route.Path, path); ok {
+					matches = append(matches, routeMatch{route: route, params: params})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		li, lj := literalSegments(matches[i].route.Path), literalSegments(matches[j].route.Path)
+		if li != lj {
+			return li > lj
+		}
+		if matches[i].route.Path != matches[j].route.Path {
+			return matches[i].route.Path < matches[j].route.Path
+		}
+		return matches[i].route.Hook < matches[j].route.Hook
+	})
+
+	return matches
+}
+
+// matchPath
+//
+// Returns the parameters of pattern captured from path, and whether the path matches at all.
+func matchPath(pattern, path string) (map[string]string, bool) {
+	patternSegs := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegs := strings.Split(strings.Trim(path, "/"), "/")
+	params := make(map[string]string)
+
+	for i, seg := range patternSegs {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "...}") {
+			rest := ""
+			if i < len(pathSegs) {
+				rest = strings.Join(pathSegs[i:], "/")
+			}
+			params[seg[1:len(seg)-4]] = rest
+			return params, true
+		}
+
+		if i >= len(pathSegs) {
+			return nil, false
+		}
+
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if pathSegs[i] == "" {
+				return nil, false
+			}
+			params[seg[1:len(seg)-1]] = pathSegs[i]
+			continue
+		}
+
+		if seg != pathSegs[i] {
+			return nil, false
+		}
+	}
+
+	return params, len(patternSegs) == len(pathSegs)
+}
+
+// literalSegments returns the number of segments of a route pattern that are not parameters
+func literalSegments(pattern string) int {
+	n := 0
+	for _, seg := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		if seg != "" && !strings.HasPrefix(seg, "{") {
+			n++
+		}
+	}
+
+	return n
+}
+
+// checkRoutePath
+//
+// Returns an error for route paths that are not a valid pattern.
+func checkRoutePath(pattern string) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("%q must start with '/'", pattern)
+	}
+
+	segs := strings.Split(strings.Trim(pattern, "/"), "/")
+	names := make(map[string]bool)
+	for i, seg := range segs {
+		if !strings.ContainsAny(seg, "{}") {
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(seg, "{"), "}"), "...")
+		switch {
+		case !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") || !namePattern.MatchString(name):
+			return fmt.Errorf("%q has an invalid parameter %q", pattern, seg)
+		case strings.HasSuffix(seg, "...}") && i != len(segs)-1:
+			return fmt.Errorf("%q may only end with a {name...} parameter", pattern)
+		case names[name]:
+			return fmt.Errorf("%q uses parameter %q more than once", pattern, name)
+		}
+		names[name] = true
+	}
+
+	return nil
+}
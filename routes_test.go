@@ -0,0 +1,143 @@
+package pluginengine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	pdk "github.com/spirefyio/plugin-go-pdk"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		params        map[string]string
+		ok            bool
+	}{
+		{"/items", "/items", map[string]string{}, true},
+		{"/items", "/items/1", nil, false},
+		{"/items/{id}", "/items/42", map[string]string{"id": "42"}, true},
+		{"/items/{id}", "/items/", nil, false},
+		{"/files/{path...}", "/files/a/b.txt", map[string]string{"path": "a/b.txt"}, true},
+		{"/files/{path...}", "/files", map[string]string{"path": ""}, true},
+	}
+
+	for _, test := range tests {
+		params, ok := matchPath(test.pattern, test.path)
+		if ok != test.ok || (ok && !reflect.DeepEqual(params, test.params)) {
+			t.Errorf("%s %s: expected %v %v, got %v %v", test.pattern, test.path, test.params, test.ok, params, ok)
+		}
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	e := newTestEngine(t)
+
+	e.mu.Lock()
+	e.addPlugin(&plugin{}, Plugin{
+		Id:      "test.routes",
+		Version: "1.0.0",
+		Hooks: []pdk.Hook{
+			{Id: "test.routes.item", Anchor: HTTPRoutesAnchor, Func: "item"},
+			{Id: "test.routes.new", Anchor: HTTPRoutesAnchor, Func: "create"},
+		},
+		Routes: []Route{
+			{Hook: "test.routes.item", Method: "GET", Path: "/items/{id}"},
+			{Hook: "test.routes.new", Method: "POST", Path: "/items/new"},
+		},
+	})
+	e.resolve()
+	e.mu.Unlock()
+
+	e.mu.RLock()
+	matches := e.matchRoutes("/items/new")
+	e.mu.RUnlock()
+	if len(matches) != 2 || matches[0].route.Hook != "test.routes.new" {
+		t.Errorf("Expected the literal route to match first, got %+v", matches)
+	}
+
+	handler := e.HTTPHandler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/other", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("DELETE", "/items/new", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("Expected 405 allowing GET, POST, got %d %q", w.Code, w.Header().Get("Allow"))
+	}
+
+	e.SetRouteLimits(RouteLimits{MaxRequestBytes: 4})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/items/new", strings.NewReader("too large")))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %d", w.Code)
+	}
+}
+
+func TestRouteVersions(t *testing.T) {
+	e := newTestEngine(t)
+
+	e.mu.Lock()
+	e.addPlugin(&plugin{}, Plugin{
+		Id:      "test.routes",
+		Version: "1.0.0",
+		Hooks:   []pdk.Hook{{Id: "test.routes.item", Anchor: HTTPRoutesAnchor, Func: "item"}},
+		Routes: []Route{
+			{Hook: "test.routes.item", Method: "GET", Path: "/items/{id}"},
+			{Hook: "test.routes.item", Method: "DELETE", Path: "/old/{id}"},
+		},
+	})
+	e.addPlugin(&plugin{}, Plugin{
+		Id:      "test.routes",
+		Version: "1.1.0",
+		Hooks:   []pdk.Hook{{Id: "test.routes.item", Anchor: HTTPRoutesAnchor, Func: "item"}},
+		Routes:  []Route{{Hook: "test.routes.item", Method: "GET", Path: "/items/{id}"}},
+	})
+	e.resolve()
+	e.mu.Unlock()
+
+	// only the version the hook is called on serves its routes
+	e.mu.RLock()
+	items, old := e.matchRoutes("/items/1"), e.matchRoutes("/old/1")
+	e.mu.RUnlock()
+	if len(items) != 1 {
+		t.Errorf("Expected the route to match once, got %+v", items)
+	}
+	if len(old) != 0 {
+		t.Errorf("Expected the route only the older version declares not to match, got %+v", old)
+	}
+
+	w := httptest.NewRecorder()
+	e.HTTPHandler().ServeHTTP(w, httptest.NewRequest("POST", "/items/1", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET" {
+		t.Errorf("Expected 405 allowing GET once, got %d %q", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestRouteManifest(t *testing.T) {
+	base := "id: test.routes\nversion: 1.0.0\nhooks:\n  - id: test.routes.item\n    anchor: " + HTTPRoutesAnchor + "\n    func: item\n"
+
+	if _, err := ParseManifest("plugin.yaml", []byte(base+"routes:\n  - hook: test.routes.item\n    method: GET\n    path: /items/{id}\n")); err != nil {
+		t.Errorf("Expected a valid manifest, got %v", err)
+	}
+
+	tests := map[string]string{
+		"": "has no routes",
+		"routes:\n  - hook: other\n    path: /a\n":                             `routes[0].hook: "other" is not a hook of this plugin`,
+		"routes:\n  - hook: test.routes.item\n    method: get\n    path: /a\n": "routes[0].method",
+		"routes:\n  - hook: test.routes.item\n    path: /a/{rest...}/b\n":      "may only end with a {name...} parameter",
+		"routes:\n  - hook: test.routes.item\n    path: items\n":               "must start with '/'",
+	}
+	for routes, expected := range tests {
+		_, err := ParseManifest("plugin.yaml", []byte(base+routes))
+		if nil == err || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error containing %q, got %v", expected, err)
+		}
+	}
+}
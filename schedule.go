@@ -0,0 +1,370 @@
+package pluginengine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the most runs of a schedule with OverlapQueue that wait for the current one, further runs are skipped
+const maxQueuedRuns = 16
+
+type (
+	// ScheduleStatus
+	//
+	// The state of a plugin schedule as returned by Engine.ScheduleStatus. LastRun is when the most recently finished run
+	// started, LastError its error or empty if it succeeded. Counts are kept across reloads of the plugin version.
+	ScheduleStatus struct {
+		Plugin       string        `json:"plugin" yaml:"plugin"`
+		Version      string        `json:"version" yaml:"version"`
+		Id           string        `json:"id" yaml:"id"`
+		Cron         string        `json:"cron" yaml:"cron"`
+		Overlap      OverlapPolicy `json:"overlap" yaml:"overlap"`
+		Next         time.Time     `json:"next" yaml:"next"`
+		LastRun      time.Time     `json:"lastRun,omitempty" yaml:"lastRun,omitempty"`
+		LastDuration time.Duration `json:"lastDuration,omitempty" yaml:"lastDuration,omitempty"`
+		LastError    string        `json:"lastError,omitempty" yaml:"lastError,omitempty"`
+		Running      int           `json:"running" yaml:"running"`
+		Queued       int           `json:"queued" yaml:"queued"`
+		Runs         int           `json:"runs" yaml:"runs"`
+		Failures     int           `json:"failures" yaml:"failures"`
+		Skipped      int           `json:"skipped" yaml:"skipped"`
+	}
+
+	// schedulePayload is the JSON a scheduled function is called with
+	schedulePayload struct {
+		Schedule string    `json:"schedule"`
+		Time     time.Time `json:"time"`
+	}
+
+	// scheduler
+	//
+	// Runs the schedules of the loaded plugins. mu guards entries and their status and is never held while calling into
+	// the engine or a plugin.
+	scheduler struct {
+		mu      sync.Mutex
+		entries map[string]*scheduleEntry // keyed by id@version/schedule
+		changed chan struct{}             // signalled whenever plugins are resolved
+		stop    chan struct{}             // nil while the scheduler is stopped
+		wg      sync.WaitGroup
+	}
+
+	// scheduleEntry is a schedule of one plugin version being run
+	scheduleEntry struct {
+		plugin   *plugin
+		schedule Schedule
+		cron     *cronSchedule
+		jitter   time.Duration
+		overlap  OverlapPolicy
+		stop     chan struct{}
+		status   ScheduleStatus
+	}
+)
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		entries: make(map[string]*scheduleEntry),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// schedulesChanged
+//
+// Tells the scheduler the loaded plugins changed. It never blocks so it can be called while holding the engine lock.
+func (s *scheduler) schedulesChanged() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// StartScheduler
+//
+// This method starts running the schedules of the loaded and resolved plugins. Schedules of plugins loaded, reloaded or
+// unloaded afterwards are picked up as that happens. Each scheduled function is called with a JSON object holding the
+// schedule id and the time it was scheduled for.
+func (e *Engine) StartScheduler() error {
+	s := e.scheduler
+
+	s.mu.Lock()
+	if nil != s.stop {
+		s.mu.Unlock()
+		return errors.New("the scheduler is already started")
+	}
+	stop := make(chan struct{})
+	s.stop = stop
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			e.syncSchedules()
+
+			select {
+			case <-s.changed:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// StopScheduler
+//
+// This method stops the scheduler and waits for the runs in progress to finish. Queued runs are dropped.
+func (e *Engine) StopScheduler() {
+	s := e.scheduler
+
+	s.mu.Lock()
+	if nil == s.stop {
+		s.mu.Unlock()
+		return
+	}
+	close(s.stop)
+	s.stop = nil
+	for key, entry := range s.entries {
+		close(entry.stop)
+		entry.status.Queued = 0
+		delete(s.entries, key)
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// ScheduleStatus
+//
+// Returns the status of every schedule being run, sorted by plugin, version and schedule id. It is empty while the
+// scheduler is stopped.
+func (e *Engine) ScheduleStatus() []ScheduleStatus {
+	s := e.scheduler
+
+	s.mu.Lock()
+	statuses := make([]ScheduleStatus, 0, len(s.entries))
+	for _, entry := range s.entries {
+		statuses = append(statuses, entry.status)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		a, b := statuses[i], statuses[j]
+		if a.Plugin != b.Plugin {
+			return a.Plugin < b.Plugin
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Id < b.Id
+	})
+
+	return statuses
+}
+
+// syncSchedules
+//
+// Starts the schedules of resolved plugins that are not running yet and stops those of plugins that were unloaded,
+// reloaded or became unresolved. A reloaded plugin's schedules keep their counts.
+func (e *Engine) syncSchedules() {
+	wanted := make(map[string]*scheduleEntry)
+
+	e.mu.RLock()
+	for _, pv := range e.plugins {
+		for _, p := range pv {
+			if !p.Resolved {
+				continue
+			}
+			for _, schedule := range p.Details.Schedules {
+				key := p.Details.Id + "@" + p.Details.Version + "/" + schedule.Id
+				wanted[key] = &scheduleEntry{plugin: p, schedule: schedule}
+			}
+		}
+	}
+	e.mu.RUnlock()
+
+	s := e.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if nil == s.stop {
+		return
+	}
+
+	previous := make(map[string]ScheduleStatus)
+	for key, entry := range s.entries {
+		if w := wanted[key]; nil == w || w.plugin != entry.plugin || w.schedule != entry.schedule {
+			close(entry.stop)
+			previous[key] = entry.status
+			delete(s.entries, key)
+		}
+	}
+
+	for key, entry := range wanted {
+		if nil != s.entries[key] {
+			continue
+		}
+
+		var err error
+		if entry.cron, err = parseCron(entry.schedule.Cron); nil != err {
+			logln("Not scheduling ", key, ": ", err)
+			continue
+		}
+		if entry.schedule.Jitter != "" {
+			if entry.jitter, err = time.ParseDuration(entry.schedule.Jitter); nil != err {
+				logln("Not scheduling ", key, ": ", err)
+				continue
+			}
+		}
+		entry.overlap = entry.schedule.Overlap
+		if entry.overlap == "" {
+			entry.overlap = OverlapSkip
+		}
+
+		entry.stop = make(chan struct{})
+		entry.status = previous[key]
+		entry.status.Plugin = entry.plugin.Details.Id
+		entry.status.Version = entry.plugin.Details.Version
+		entry.status.Id = entry.schedule.Id
+		entry.status.Cron = entry.schedule.Cron
+		entry.status.Overlap = entry.overlap
+		entry.status.Running = 0
+		entry.status.Queued = 0
+		s.entries[key] = entry
+
+		s.wg.Add(1)
+		go e.runSchedule(entry)
+	}
+}
+
+// runSchedule
+//
+// Waits for each time the schedule fires, plus its jitter, and triggers a run until the entry is stopped.
+func (e *Engine) runSchedule(entry *scheduleEntry) {
+	s := e.scheduler
+	defer s.wg.Done()
+
+	clock := e.getClock()
+	for {
+		now := clock.Now()
+		next := entry.cron.next(now)
+		if next.IsZero() {
+			logln("Schedule ", entry.status.Plugin, "/", entry.status.Id, " never fires again")
+			return
+		}
+		if entry.jitter > 0 {
+			next = next.Add(time.Duration(rand.Int63n(int64(entry.jitter))))
+		}
+
+		s.mu.Lock()
+		entry.status.Next = next
+		s.mu.Unlock()
+
+		select {
+		case <-clock.After(next.Sub(now)):
+		case <-entry.stop:
+			return
+		}
+
+		e.triggerSchedule(entry, next)
+	}
+}
+
+// triggerSchedule
+//
+// Starts a run of the schedule, or queues or skips it when the previous run is still going, per its overlap policy.
+func (e *Engine) triggerSchedule(entry *scheduleEntry, at time.Time) {
+	s := e.scheduler
+
+	s.mu.Lock()
+	if entry.status.Running > 0 {
+		switch entry.overlap {
+		case OverlapSkip:
+			entry.status.Skipped++
+			s.mu.Unlock()
+			return
+		case OverlapQueue:
+			if entry.status.Queued < maxQueuedRuns {
+				entry.status.Queued++
+			} else {
+				entry.status.Skipped++
+			}
+			s.mu.Unlock()
+			return
+		}
+	}
+	entry.status.Running++
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for {
+			start := e.getClock().Now()
+			err := e.callScheduled(entry, at)
+			duration := e.getClock().Now().Sub(start)
+
+			s.mu.Lock()
+			entry.status.Runs++
+			entry.status.LastRun = start
+			entry.status.LastDuration = duration
+			entry.status.LastError = ""
+			if nil != err {
+				entry.status.Failures++
+				entry.status.LastError = err.Error()
+				logln("Schedule ", entry.status.Plugin, "/", entry.status.Id, " failed: ", err)
+			}
+
+			stopped := false
+			select {
+			case <-entry.stop:
+				stopped = true
+			default:
+			}
+
+			if entry.status.Queued > 0 && !stopped {
+				entry.status.Queued--
+				at = e.getClock().Now()
+				s.mu.Unlock()
+				continue
+			}
+			entry.status.Running--
+			s.mu.Unlock()
+			return
+		}
+	}()
+}
+
+// callScheduled
+//
+// Calls the scheduled function of the entry's plugin, unless the plugin version was unloaded or replaced since.
+func (e *Engine) callScheduled(entry *scheduleEntry, at time.Time) error {
+	p := entry.plugin
+
+	e.mu.RLock()
+	current := e.plugins[p.Details.Id][p.Details.Version] == p
+	if current {
+		// registered while holding the lock so Unload/Reload can not stop the instance out from under this call
+		p.inflight.Add(1)
+	}
+	e.mu.RUnlock()
+
+	if !current {
+		return fmt.Errorf("plugin %s@%s is no longer loaded", p.Details.Id, p.Details.Version)
+	}
+	defer p.inflight.Done()
+
+	payload, err := json.Marshal(schedulePayload{Schedule: entry.schedule.Id, Time: at})
+	if nil != err {
+		return err
+	}
+
+	_, err = e.callPlugin(e.context, p, entry.schedule.Id, entry.schedule.Func, payload)
+	return err
+}
@@ -0,0 +1,177 @@
+package pluginengine
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiting
+}
+
+func (c *fakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.waiters)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2024, 1, 31, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 31, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 15, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2024, 1, 31, 13, 0, 0, 0, time.UTC)},
+		{"30 2 29 feb *", time.Date(2024, 2, 29, 2, 30, 0, 0, time.UTC)},
+		{"0 0 * * sun", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		// both day fields restricted, either matches
+		{"0 0 1 * fri", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, test := range tests {
+		c, err := parseCron(test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if next := c.next(from); !next.Equal(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.expr, test.expected, next)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "* * * foo *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%s: expected an error", expr)
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	e := newTestEngine(t)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 30, 0, time.UTC)}
+	e.SetClock(clock)
+
+	e.mu.Lock()
+	e.addPlugin(&plugin{ModuleData: []byte(exportingModule)}, Plugin{
+		Id:      "test.scheduled",
+		Version: "1.0.0",
+		Schedules: []Schedule{
+			{Id: "tick", Cron: "* * * * *", Func: "run"},
+			{Id: "broken", Cron: "*/2 * * * *", Func: "missing", Overlap: OverlapQueue},
+		},
+	})
+	e.mu.Unlock()
+
+	if err := e.StartScheduler(); err != nil {
+		t.Fatal(err)
+	}
+	defer e.StopScheduler()
+
+	waitFor(t, "the schedules to start", func() bool { return clock.Waiters() == 2 })
+
+	statuses := e.ScheduleStatus()
+	if len(statuses) != 2 || statuses[0].Id != "broken" || !statuses[0].Next.Equal(time.Date(2024, 1, 1, 0, 2, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected statuses %+v", statuses)
+	}
+
+	clock.Advance(2 * time.Minute)
+	waitFor(t, "the runs to finish", func() bool {
+		s := e.ScheduleStatus()
+		return s[0].Runs == 1 && s[1].Runs == 1 && s[0].Running == 0 && s[1].Running == 0
+	})
+
+	statuses = e.ScheduleStatus()
+	if statuses[0].Failures != 1 || statuses[0].LastError == "" {
+		t.Errorf("Expected the broken schedule to record its failure, got %+v", statuses[0])
+	}
+	if statuses[1].Failures != 0 || statuses[1].LastError != "" {
+		t.Errorf("Expected the tick schedule to succeed, got %+v", statuses[1])
+	}
+
+	// overlap policies, with a run pretended to be in progress
+	s := e.scheduler
+	s.mu.Lock()
+	tick := s.entries["test.scheduled@1.0.0/tick"]
+	broken := s.entries["test.scheduled@1.0.0/broken"]
+	tick.status.Running++
+	broken.status.Running++
+	s.mu.Unlock()
+
+	e.triggerSchedule(tick, clock.Now())
+	e.triggerSchedule(broken, clock.Now())
+
+	s.mu.Lock()
+	if tick.status.Skipped != 1 || broken.status.Queued != 1 {
+		t.Errorf("Expected tick to skip and broken to queue, got %+v %+v", tick.status, broken.status)
+	}
+	tick.status.Running--
+	broken.status.Running--
+	s.mu.Unlock()
+
+	e.Unload("test.scheduled", "1.0.0")
+	waitFor(t, "the schedules to stop", func() bool { return len(e.ScheduleStatus()) == 0 })
+}
@@ -0,0 +1,136 @@
+package pluginengine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	extism "github.com/extism/go-sdk"
+)
+
+// ErrSecretNotFound is wrapped by the errors SecretProviders return for names they have no secret for.
+var ErrSecretNotFound = errors.New("secret not found")
+
+type (
+	// SecretProvider
+	//
+	// Resolves the secrets plugins ask for with the GetSecret host function. Hosts implement it to read from their
+	// secret store, EnvSecretProvider and FileSecretProvider are included.
+	SecretProvider interface {
+		Secret(ctx context.Context, name string) (string, error)
+	}
+
+	// EnvSecretProvider
+	//
+	// Reads secrets from environment variables. The variable for a secret is Prefix followed by its name upper cased
+	// with '.' and '-' replaced by '_', so api.token with a prefix of PLUGIN_ is read from PLUGIN_API_TOKEN.
+	EnvSecretProvider struct {
+		Prefix string
+	}
+
+	// FileSecretProvider
+	//
+	// Reads secrets from files in Dir named after the secret, as mounted by Docker and Kubernetes. A trailing newline is
+	// removed.
+	FileSecretProvider struct {
+		Dir string
+	}
+)
+
+func (p EnvSecretProvider) Secret(ctx context.Context, name string) (string, error) {
+	key := p.Prefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+
+	return value, nil
+}
+
+func (p FileSecretProvider) Secret(ctx context.Context, name string) (string, error) {
+	if !fs.ValidPath(name) || strings.Contains(name, "/") {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(p.Dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	if nil != err {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// SetSecretProvider
+//
+// Sets where the secrets plugins ask for are resolved from. Without one every GetSecret call fails.
+func (e *Engine) SetSecretProvider(sp SecretProvider) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.secrets = sp
+}
+
+// getSecret
+//
+// This host function hands a plugin the value of a secret it declares in its manifest, resolved through the engine's
+// SecretProvider. It is created per plugin so it knows which secrets the caller may have. A secret that is not declared,
+// not found or can not be resolved returns 0 to the plugin and is logged without its value. Every value handed out is
+// redacted from engine logs.
+func getSecret(e *Engine, plug *plugin) extism.HostFunction {
+	ret := extism.NewHostFunctionWithStack(
+		"GetSecret",
+		func(ctx context.Context, p *extism.CurrentPlugin, stack []uint64) {
+			name, err := p.ReadString(stack[0])
+			stack[0] = 0
+			if nil != err {
+				logln("Error reading secret name from plugin: ", plug.Details.Id, err)
+				return
+			}
+
+			declared := false
+			for _, s := range plug.Details.Secrets {
+				declared = declared || s == name
+			}
+			if !declared {
+				logln("Plugin ", plug.Details.Id, " asked for secret ", name, " which it does not declare")
+				return
+			}
+
+			e.mu.RLock()
+			sp := e.secrets
+			e.mu.RUnlock()
+
+			if nil == sp {
+				logln("Plugin ", plug.Details.Id, " asked for secret ", name, " but the engine has no secret provider")
+				return
+			}
+
+			value, err := sp.Secret(ctx, name)
+			if nil != err {
+				logln("Error resolving secret for plugin: ", plug.Details.Id, err)
+				return
+			}
+
+			redact(value)
+
+			offset, err := p.WriteString(value)
+			if nil != err {
+				logln("Error writing secret: ", err)
+				return
+			}
+			stack[0] = offset
+		},
+		[]extism.ValueType{extism.ValueTypeI64}, []extism.ValueType{extism.ValueTypeI64},
+	)
+	ret.SetNamespace("extism:host/pluginengine")
+
+	return ret
+}
@@ -0,0 +1,57 @@
+package pluginengine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretProviders(t *testing.T) {
+	t.Setenv("PLUGIN_API_TOKEN", "env-token")
+
+	value, err := EnvSecretProvider{Prefix: "PLUGIN_"}.Secret(context.Background(), "api.token")
+	if err != nil || value != "env-token" {
+		t.Errorf("Expected env-token, got %q %v", value, err)
+	}
+	if _, err := (EnvSecretProvider{Prefix: "PLUGIN_"}).Secret(context.Background(), "missing"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("Expected ErrSecretNotFound, got %v", err)
+	}
+
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "api.token"), "file-token\n")
+
+	files := FileSecretProvider{Dir: dir}
+	value, err = files.Secret(context.Background(), "api.token")
+	if err != nil || value != "file-token" {
+		t.Errorf("Expected file-token, got %q %v", value, err)
+	}
+	if _, err := files.Secret(context.Background(), "../api.token"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("Expected secrets outside the directory to be refused, got %v", err)
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	redact("s3cr3t-redaction-test")
+	redact("s3cr3t-redaction-test-longer")
+	redact("abc")
+
+	got := redacted("token s3cr3t-redaction-test-longer and s3cr3t-redaction-test, abc")
+	expected := "token [REDACTED] and [REDACTED], abc"
+	if got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestLogOutput(t *testing.T) {
+	buf := &bytes.Buffer{}
+	SetLogOutput(buf)
+	defer SetLogOutput(nil)
+
+	redact("s3cr3t-log-output-test")
+	logln("token", "s3cr3t-log-output-test")
+	if buf.String() != "token [REDACTED]\n" {
+		t.Errorf("Expected the redacted line in the log output, got %q", buf.String())
+	}
+}
@@ -0,0 +1,341 @@
+package pluginengine
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+)
+
+type (
+	// Signature
+	//
+	// A publisher's Ed25519 signature over the content digest of a plugin (see ContentDigest). It is stored as JSON,
+	// either embedded in the plugin as plugin.sig or detached next to an archive, directory or module with a .sig
+	// extension added to its name. A detached signature takes precedence over an embedded one.
+	Signature struct {
+		Algorithm string `json:"algorithm"`
+		Publisher string `json:"publisher"`
+		Digest    string `json:"digest"`
+		Signature string `json:"signature"`
+	}
+
+	// TrustStore
+	//
+	// The publisher public keys the engine trusts. A publisher can have more than one key so keys can be rotated.
+	TrustStore struct {
+		mu   sync.RWMutex
+		keys map[string][]ed25519.PublicKey
+	}
+
+	// Policy
+	//
+	// How the engine reacts when a check fails. PolicyOff skips the check, PolicyWarn logs the failure and loads the
+	// plugin anyway and PolicyEnforce refuses to load it.
+	Policy int
+
+	VerificationStatus string
+)
+
+const (
+	PolicyOff Policy = iota
+	PolicyWarn
+	PolicyEnforce
+)
+
+const (
+	// VerificationSkipped is recorded when the verification policy is off
+	VerificationSkipped VerificationStatus = "skipped"
+	// VerificationUnsigned is recorded when no signature was found
+	VerificationUnsigned VerificationStatus = "unsigned"
+	// VerificationUntrusted is recorded when the signature's publisher has no key in the trust store
+	VerificationUntrusted VerificationStatus = "untrusted"
+	// VerificationInvalid is recorded when the signature does not match the plugin contents or the publisher's keys
+	VerificationInvalid VerificationStatus = "invalid"
+	// VerificationVerified is recorded when the plugin contents were signed by a trusted publisher
+	VerificationVerified VerificationStatus = "verified"
+)
+
+const (
+	// the name of an embedded signature at the root of a plugin
+	signatureName = "plugin.sig"
+
+	signatureAlgorithm = "ed25519"
+
+	// prefixed to the digest before signing so a plugin signature can not be mistaken for any other signed message
+	signatureContext = "pluginengine.signature.v1:"
+)
+
+// ErrNotRegularFile is returned for plugin contents that are neither a directory nor a regular file, such as a symbolic
+// link, which could make a plugin load something other than what was signed.
+var ErrNotRegularFile = errors.New("not a regular file")
+
+// ContentDigest
+//
+// Returns the hex encoded sha256 digest of a plugin's contents. Every regular file in fsys other than an embedded
+// plugin.sig is hashed, and the digest is taken over the sorted list of file names and their hashes. The digest only
+// depends on the files themselves, so a plugin has the same digest packed as a .zip, a .tar.gz or unpacked. Anything
+// else than directories and regular files, such as symbolic links, fails with ErrNotRegularFile.
+func ContentDigest(fsys fs.FS) (string, error) {
+	lines := make([]string, 0)
+
+	err := fs.WalkDir(fsys, ".", func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() || p == signatureName {
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return fmt.Errorf("%s: %w", p, ErrNotRegularFile)
+		}
+
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		lines = append(lines, p+"\x00"+hex.EncodeToString(sum[:])+"\n")
+		return nil
+	})
+
+	if nil != err {
+		return "", err
+	}
+
+	sort.Strings(lines)
+
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Sign
+//
+// Signs the contents of the plugin in fsys on behalf of publisher.
+func Sign(fsys fs.FS, publisher string, key ed25519.PrivateKey) (*Signature, error) {
+	digest, err := ContentDigest(fsys)
+	if nil != err {
+		return nil, err
+	}
+
+	return &Signature{
+		Algorithm: signatureAlgorithm,
+		Publisher: publisher,
+		Digest:    digest,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(signatureContext+digest))),
+	}, nil
+}
+
+// SignArchive
+//
+// Signs the plugin archive at path and writes the signature next to it as a detached path.sig file.
+func SignArchive(path, publisher string, key ed25519.PrivateKey) (*Signature, error) {
+	f, err := os.Open(path)
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if nil != err {
+		return nil, err
+	}
+
+	fsys, err := archiveFS(f, info.Size())
+	if nil != err {
+		return nil, err
+	}
+
+	sig, err := Sign(fsys, publisher, key)
+	if nil != err {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(sig, "", "  ")
+	if nil != err {
+		return nil, err
+	}
+
+	return sig, os.WriteFile(path+".sig", data, 0644)
+}
+
+func NewTrustStore() *TrustStore {
+	return &TrustStore{keys: make(map[string][]ed25519.PublicKey)}
+}
+
+// Add
+//
+// Trusts key for signatures made by publisher.
+func (t *TrustStore) Add(publisher string, key ed25519.PublicKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.keys[publisher] = append(t.keys[publisher], key)
+}
+
+// AddEncoded
+//
+// Trusts a base64 encoded key for signatures made by publisher, convenient for keys read from configuration.
+func (t *TrustStore) AddEncoded(publisher, key string) error {
+	data, err := base64.StdEncoding.DecodeString(key)
+	if nil != err {
+		return err
+	}
+
+	if len(data) != ed25519.PublicKeySize {
+		return fmt.Errorf("an ed25519 public key is %d bytes, got %d", ed25519.PublicKeySize, len(data))
+	}
+
+	t.Add(publisher, ed25519.PublicKey(data))
+	return nil
+}
+
+// Verify
+//
+// Checks a signature against the digest of the plugin contents it is meant to cover.
+func (t *TrustStore) Verify(sig *Signature, digest string) VerificationStatus {
+	if nil == sig {
+		return VerificationUnsigned
+	}
+
+	if sig.Algorithm != signatureAlgorithm || sig.Digest != digest {
+		return VerificationInvalid
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if nil != err {
+		return VerificationInvalid
+	}
+
+	t.mu.RLock()
+	keys := t.keys[sig.Publisher]
+	t.mu.RUnlock()
+
+	if len(keys) == 0 {
+		return VerificationUntrusted
+	}
+
+	for _, key := range keys {
+		if ed25519.Verify(key, []byte(signatureContext+digest), raw) {
+			return VerificationVerified
+		}
+	}
+
+	return VerificationInvalid
+}
+
+// SetTrustStore
+//
+// Sets the publisher keys plugins are verified against.
+func (e *Engine) SetTrustStore(ts *TrustStore) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.trustStore = ts
+}
+
+// SetVerificationPolicy
+//
+// Sets what happens to plugins that are not signed by a trusted publisher. The default is PolicyOff.
+func (e *Engine) SetVerificationPolicy(policy Policy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.verifyPolicy = policy
+}
+
+// verify
+//
+// This method checks the signature of the plugins loaded from fsys according to the engine's verification policy and
+// records the outcome on each plugin. detached is the path of a detached signature to look for, empty if the plugins
+// did not come from the local file system. An error is returned when the policy is enforced and the plugins are not
+// signed by a trusted publisher.
+func (e *Engine) verify(fsys fs.FS, detached string, plugs []*plugin) error {
+	e.mu.RLock()
+	policy, ts := e.verifyPolicy, e.trustStore
+	e.mu.RUnlock()
+
+	status := VerificationSkipped
+	publisher := ""
+
+	if policy != PolicyOff {
+		sig, err := readSignature(fsys, detached)
+		if nil != err {
+			return err
+		}
+
+		digest, err := ContentDigest(fsys)
+		if nil != err {
+			return err
+		}
+
+		if nil == ts {
+			ts = NewTrustStore()
+		}
+
+		status = ts.Verify(sig, digest)
+		if nil != sig {
+			publisher = sig.Publisher
+		}
+
+		if status != VerificationVerified {
+			msg := fmt.Sprintf("plugin signature is %s", status)
+			if policy == PolicyEnforce {
+				return errors.New(msg)
+			}
+
+			logln("WARNING: ", msg, pluginIds(plugs))
+		}
+	}
+
+	for _, plug := range plugs {
+		plug.Verification = status
+		plug.Publisher = publisher
+	}
+
+	return nil
+}
+
+// readSignature
+//
+// Reads the detached signature if there is one, otherwise the signature embedded in fsys. A nil signature is returned
+// when there is neither.
+func readSignature(fsys fs.FS, detached string) (*Signature, error) {
+	var data []byte
+	var err error
+
+	if detached != "" {
+		data, err = os.ReadFile(detached)
+	}
+
+	if detached == "" || errors.Is(err, fs.ErrNotExist) {
+		data, err = fs.ReadFile(fsys, signatureName)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+	}
+
+	if nil != err {
+		return nil, err
+	}
+
+	sig := &Signature{}
+	if err := json.Unmarshal(data, sig); nil != err {
+		return nil, fmt.Errorf("malformed plugin signature: %w", err)
+	}
+
+	return sig, nil
+}
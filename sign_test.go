@@ -0,0 +1,100 @@
+package pluginengine
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestContentDigest(t *testing.T) {
+	a := fstest.MapFS{
+		"plugin.yaml": &fstest.MapFile{Data: []byte("id: test.sign\n")},
+		"plugin.wasm": &fstest.MapFile{Data: []byte("\x00asm")},
+	}
+	b := fstest.MapFS{
+		"plugin.yaml": a["plugin.yaml"],
+		"plugin.wasm": a["plugin.wasm"],
+		"plugin.sig":  &fstest.MapFile{Data: []byte("{}")},
+	}
+
+	da, err := ContentDigest(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := ContentDigest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if da != db {
+		t.Errorf("Expected an embedded signature to be left out of the digest")
+	}
+
+	b["plugin.wasm"] = &fstest.MapFile{Data: []byte("\x00asm\x01")}
+	if db, _ = ContentDigest(b); da == db {
+		t.Errorf("Expected the digest to change with the contents")
+	}
+
+	b["plugin.wasm"] = &fstest.MapFile{Data: []byte("/etc/passwd"), Mode: fs.ModeSymlink}
+	if _, err := ContentDigest(b); !errors.Is(err, ErrNotRegularFile) {
+		t.Errorf("Expected a symbolic link to fail the digest, got %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "signed.zip")
+	writeTestPluginZip(t, archive, "id: test.signed\nversion: 1.0.0\n")
+
+	e := newTestEngine(t)
+	e.SetVerificationPolicy(PolicyEnforce)
+
+	if _, err := e.loadArchive(archive); err == nil {
+		t.Fatal("Expected an unsigned archive to be refused")
+	}
+
+	if _, err := SignArchive(archive, "test.publisher", priv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.loadArchive(archive); err == nil {
+		t.Fatal("Expected an archive signed by an untrusted publisher to be refused")
+	}
+
+	ts := NewTrustStore()
+	ts.Add("test.publisher", pub)
+	e.SetTrustStore(ts)
+
+	plugs, err := e.loadArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plugs[0].Verification != VerificationVerified || plugs[0].Publisher != "test.publisher" {
+		t.Errorf("Expected a verified plugin, got %s from %s", plugs[0].Verification, plugs[0].Publisher)
+	}
+
+	e.register(plugs)
+	if diags := e.Diagnostics(); len(diags) != 1 || diags[0].Verification != VerificationVerified {
+		t.Errorf("Expected the verification status in the diagnostics, got %+v", diags)
+	}
+
+	// tampering with the archive invalidates the signature
+	writeTestPluginZip(t, archive, "id: test.signed\nversion: 1.0.0\nloadOnStart: true\n")
+	if _, err := e.loadArchive(archive); err == nil {
+		t.Fatal("Expected a tampered archive to be refused")
+	}
+
+	e.SetVerificationPolicy(PolicyWarn)
+	plugs, err = e.loadArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plugs[0].Verification != VerificationInvalid {
+		t.Errorf("Expected the tampered archive to load as invalid under the warn policy, got %s", plugs[0].Verification)
+	}
+}
@@ -0,0 +1,379 @@
+package pluginengine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type (
+	pluginSourceKind int
+
+	// pluginSource
+	//
+	// Something on disk plugins can be loaded from.
+	pluginSource struct {
+		Path string
+		Kind pluginSourceKind
+	}
+)
+
+// The kinds of plugin source, in precedence order. When the same plugin id and version is found in more than one source
+// during a single Load the one with the lowest kind wins.
+const (
+	sourceDirectory pluginSourceKind = iota
+	sourceArchive
+	sourceWasm
+)
+
+const (
+	// the manifest file that marks a directory as an unpacked plugin
+	pluginManifestName = "plugin.yaml"
+
+	// the name of the wasm custom section a bare module can carry its plugin.yaml in
+	wasmManifestSection = "plugin.yaml"
+
+	// the name that marks the main module of a plugin with more than one module
+	mainModuleName = "main"
+)
+
+// ErrUnsupportedArchive is returned for archive formats the engine can not load plugins from.
+var ErrUnsupportedArchive = errors.New("unsupported plugin archive type, expected .zip, .tar.gz or .tgz")
+
+var (
+	pluginArchiveExtensions = []string{".tar.gz", ".tgz", ".zip"}
+
+	// archives that are recognisably archives but not a format plugins can be packaged in
+	unsupportedArchiveExtensions = []string{".gz", ".tar", ".tar.bz2", ".tbz2", ".bz2", ".tar.xz", ".txz", ".xz", ".tar.zst", ".zst", ".7z", ".rar"}
+)
+
+// archiveSuffix
+//
+// Returns the supported archive suffix of file, or an empty string if it is not a supported archive.
+func archiveSuffix(file string) string {
+	for _, ext := range pluginArchiveExtensions {
+		if strings.HasSuffix(file, ext) {
+			return ext
+		}
+	}
+
+	return ""
+}
+
+// sourceKindForFile
+//
+// Returns the kind of plugin source a file is. ok is false for files that are not plugin sources at all, such as a
+// sidecar manifest, and an ErrUnsupportedArchive error is returned for archives in a format that is not supported.
+func sourceKindForFile(file string) (kind pluginSourceKind, ok bool, err error) {
+	if archiveSuffix(file) != "" {
+		return sourceArchive, true, nil
+	}
+
+	if strings.HasSuffix(file, ".wasm") {
+		return sourceWasm, true, nil
+	}
+
+	for _, ext := range unsupportedArchiveExtensions {
+		if strings.HasSuffix(file, ext) {
+			return 0, false, fmt.Errorf("%w: %s", ErrUnsupportedArchive, file)
+		}
+	}
+
+	return 0, false, nil
+}
+
+// findPluginSources
+//
+// This function finds everything plugins can be loaded from at root:
+//
+//   - root itself when it is a single archive or .wasm file. Any other file is rejected with ErrUnsupportedArchive.
+//   - unpacked plugin directories, which are directories holding a plugin.yaml or plugin.json. Everything inside one belongs to that
+//     plugin so the directory is not searched any further, including when root itself is one.
+//   - .zip, .tar.gz and .tgz archives.
+//   - bare .wasm modules outside of an unpacked plugin directory. Their manifest is a sidecar file with the same name
+//     and a .yaml or .json extension, or else a plugin.yaml custom section in the module.
+//
+// Archives in other formats are logged and skipped. Sources are returned directories first, then archives, then bare
+// modules, which is the precedence used when the same plugin version is found more than once.
+func findPluginSources(root string) ([]pluginSource, error) {
+	info, err := os.Stat(root)
+	if nil != err {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		kind, ok, err := sourceKindForFile(root)
+		if nil != err {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedArchive, root)
+		}
+
+		return []pluginSource{{Path: root, Kind: kind}}, nil
+	}
+
+	sources := make([]pluginSource, 0)
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			for _, name := range []string{pluginManifestName, pluginManifestJSONName} {
+				if _, err := os.Stat(filepath.Join(path, name)); nil == err {
+					sources = append(sources, pluginSource{Path: path, Kind: sourceDirectory})
+					return filepath.SkipDir
+				}
+			}
+
+			return nil
+		}
+
+		kind, ok, err := sourceKindForFile(path)
+		if nil != err {
+			logln("Rejecting plugin file: ", err)
+		} else if ok {
+			sources = append(sources, pluginSource{Path: path, Kind: kind})
+		}
+
+		return nil
+	})
+
+	if nil != err {
+		return nil, err
+	}
+
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].Kind < sources[j].Kind
+	})
+
+	return sources, nil
+}
+
+// loadSource
+//
+// Loads the plugins of a single source without registering them.
+func (e *Engine) loadSource(src pluginSource) ([]*plugin, error) {
+	var plugs []*plugin
+	var fsys fs.FS
+	var err error
+
+	// the plugins are verified against the same copy of the files their manifests and module bytes were read from
+	switch src.Kind {
+	case sourceDirectory:
+		plugs, fsys, err = loadDirectory(src.Path)
+	case sourceWasm:
+		plugs, fsys, err = loadWasm(src.Path)
+	default:
+		// archives are verified once extracted
+		return e.loadArchive(src.Path)
+	}
+
+	if nil != err {
+		return nil, err
+	}
+
+	if err := e.admit(fsys, src.Path+".sig", plugs); nil != err {
+		return nil, fmt.Errorf("%s: %w", src.Path, err)
+	}
+
+	return plugs, nil
+}
+
+// loadDirectory
+//
+// Loads an unpacked plugin directory in place, nothing is copied to the engine's plugin output path. The directory is
+// read into memory once and returned along with the plugins, which carry their module bytes from that copy, so a module
+// changed on disk after the directory is read is neither verified nor instantiated.
+func loadDirectory(dir string) ([]*plugin, fs.FS, error) {
+	fsys, err := snapshotDir(dir)
+	if nil != err {
+		return nil, nil, err
+	}
+
+	plugs, err := loadPluginsFS(fsys)
+	if nil != err {
+		return nil, nil, err
+	}
+
+	for _, plug := range plugs {
+		plug.rebase(dir)
+		plug.Source = dir
+	}
+
+	return plugs, fsys, nil
+}
+
+// snapshotDir
+//
+// Reads a directory into a memFS. Entries that are not directories or regular files, such as symbolic links, are kept
+// with their type but no contents, so they are refused as modules and fail the content digest as they would on disk.
+func snapshotDir(dir string) (memFS, error) {
+	fsys := os.DirFS(dir)
+	mfs := memFS{}
+
+	err := fs.WalkDir(fsys, ".", func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := entry.Info()
+		if nil != err {
+			return err
+		}
+
+		f := &memFile{name: p, mode: info.Mode(), modTime: info.ModTime()}
+		if entry.Type().IsRegular() {
+			if f.data, err = fs.ReadFile(fsys, p); nil != err {
+				return err
+			}
+		}
+
+		mfs[p] = f
+		return nil
+	})
+
+	if nil != err {
+		return nil, err
+	}
+
+	return mfs, nil
+}
+
+// loadWasm
+//
+// Loads a bare .wasm module. A sidecar manifest next to the module takes precedence over a manifest embedded in the
+// module so a module can be re-described without rebuilding it. A module that is not a regular file, such as a
+// symbolic link, is refused. The module and its sidecar are read once, the plugin carries the module bytes and the
+// returned fs.FS holds both for verifying the plugin, a sidecar manifest describes the module so the signature has to
+// cover it too.
+func loadWasm(file string) ([]*plugin, fs.FS, error) {
+	var name string
+	var data []byte
+
+	info, err := os.Lstat(file)
+	if nil != err {
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, nil, fmt.Errorf("%s: %w", file, ErrNotRegularFile)
+	}
+
+	module, err := os.ReadFile(file)
+	if nil != err {
+		return nil, nil, err
+	}
+	fsys := singleFileFS(filepath.Base(file), module)
+
+	if sidecar := sidecarManifest(file); sidecar != "" {
+		name = sidecar
+		data, err = os.ReadFile(sidecar)
+		if nil != err {
+			return nil, nil, err
+		}
+		fsys[filepath.Base(sidecar)] = &memFile{name: filepath.Base(sidecar), data: data, mode: 0644}
+	} else {
+		var found bool
+		data, found, err = wasmCustomSection(module, wasmManifestSection)
+		if nil != err {
+			return nil, nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if !found {
+			return nil, nil, fmt.Errorf("%s has no sidecar .yaml or .json manifest and no %s custom section", file, wasmManifestSection)
+		}
+		name = file + "#" + wasmManifestSection
+	}
+
+	p, err := ParseManifest(name, data)
+	if nil != err {
+		return nil, nil, err
+	}
+
+	if len(p.Modules) > 0 {
+		return nil, nil, fmt.Errorf("%s: the manifest of a bare module can not name modules, package the plugin instead", file)
+	}
+
+	sum := sha256.Sum256(module)
+
+	return []*plugin{{
+		Details:      p,
+		PathToModule: file,
+		ModuleData:   module,
+		Source:       file,
+		SourceHash:   hex.EncodeToString(sum[:]),
+	}}, fsys, nil
+}
+
+// sidecarManifest
+//
+// Returns the path of the sidecar manifest of a bare module, <name>.yaml or else <name>.json, or an empty string when
+// the module has neither.
+func sidecarManifest(file string) string {
+	for _, ext := range []string{".yaml", ".json"} {
+		sidecar := strings.TrimSuffix(file, ".wasm") + ext
+		if _, err := os.Stat(sidecar); nil == err {
+			return sidecar
+		}
+	}
+
+	return ""
+}
+
+// wasmCustomSection
+//
+// Returns the payload of the first custom section with the given name in a wasm binary module.
+func wasmCustomSection(module []byte, name string) ([]byte, bool, error) {
+	if len(module) < 8 || !bytes.Equal(module[:4], []byte("\x00asm")) {
+		return nil, false, errors.New("not a wasm binary module")
+	}
+
+	for pos := 8; pos < len(module); {
+		id := module[pos]
+		size, n := uleb128(module[pos+1:])
+		if n == 0 || pos+1+n+int(size) > len(module) {
+			return nil, false, errors.New("malformed wasm section")
+		}
+
+		start := pos + 1 + n
+		end := start + int(size)
+
+		// custom sections have id 0 and start with their name
+		if id == 0 {
+			nameLen, m := uleb128(module[start:end])
+			if m == 0 || start+m+int(nameLen) > end {
+				return nil, false, errors.New("malformed wasm custom section")
+			}
+
+			if string(module[start+m:start+m+int(nameLen)]) == name {
+				return module[start+m+int(nameLen) : end], true, nil
+			}
+		}
+
+		pos = end
+	}
+
+	return nil, false, nil
+}
+
+// uleb128
+//
+// Decodes an unsigned LEB128 value, returning it and the number of bytes read, or 0 bytes if it is malformed.
+func uleb128(b []byte) (uint32, int) {
+	var result uint32
+	for i := 0; i < len(b) && i < 5; i++ {
+		result |= uint32(b[i]&0x7f) << (7 * i)
+		if b[i]&0x80 == 0 {
+			return result, i + 1
+		}
+	}
+
+	return 0, 0
+}
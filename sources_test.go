@@ -0,0 +1,140 @@
+package pluginengine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// wasmWithCustomSection builds a minimal wasm module with a single custom section
+func wasmWithCustomSection(name, payload string) []byte {
+	content := append([]byte{byte(len(name))}, name...)
+	content = append(content, payload...)
+
+	module := []byte("\x00asm\x01\x00\x00\x00")
+	module = append(module, 0, byte(len(content)))
+	return append(module, content...)
+}
+
+func TestFindPluginSources(t *testing.T) {
+	dir := t.TempDir()
+
+	mustWrite(t, filepath.Join(dir, "unpacked", "plugin.yaml"), "id: test.unpacked\nversion: 1.0.0\n")
+	mustWrite(t, filepath.Join(dir, "unpacked", "nested.zip"), "")
+	mustWrite(t, filepath.Join(dir, "bare.wasm"), "")
+	mustWrite(t, filepath.Join(dir, "bare.yaml"), "")
+	mustWrite(t, filepath.Join(dir, "packed.zip"), "")
+	mustWrite(t, filepath.Join(dir, "packed.tgz"), "")
+	mustWrite(t, filepath.Join(dir, "notes.gz"), "")
+
+	sources, err := findPluginSources(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []pluginSource{
+		{Path: filepath.Join(dir, "unpacked"), Kind: sourceDirectory},
+		{Path: filepath.Join(dir, "packed.tgz"), Kind: sourceArchive},
+		{Path: filepath.Join(dir, "packed.zip"), Kind: sourceArchive},
+		{Path: filepath.Join(dir, "bare.wasm"), Kind: sourceWasm},
+	}
+
+	if len(sources) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, sources)
+	}
+	for i := range expected {
+		if sources[i] != expected[i] {
+			t.Errorf("Expected %v at %d, got %v", expected[i], i, sources[i])
+		}
+	}
+
+	if _, err := findPluginSources(filepath.Join(dir, "notes.gz")); !errors.Is(err, ErrUnsupportedArchive) {
+		t.Errorf("Expected ErrUnsupportedArchive for a plain .gz, got %v", err)
+	}
+}
+
+func TestLoadWasm(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "bare.wasm")
+
+	if err := os.WriteFile(file, wasmWithCustomSection(wasmManifestSection, "id: test.embedded\nversion: 1.0.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plugs, _, err := loadWasm(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plugs) != 1 || plugs[0].Details.Id != "test.embedded" || plugs[0].PathToModule != file {
+		t.Fatalf("Expected the embedded manifest to be used, got %+v", plugs)
+	}
+
+	// a sidecar wins over the embedded manifest
+	mustWrite(t, filepath.Join(dir, "bare.yaml"), "id: test.sidecar\nversion: 1.0.0\n")
+	plugs, _, err = loadWasm(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plugs[0].Details.Id != "test.sidecar" {
+		t.Errorf("Expected the sidecar manifest to be used, got %s", plugs[0].Details.Id)
+	}
+
+	if err := os.WriteFile(file, wasmWithCustomSection("name", ""), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "bare.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadWasm(file); err == nil {
+		t.Errorf("Expected an error for a module without a manifest")
+	}
+}
+
+func TestLoadSourceReadsModulesOnce(t *testing.T) {
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "unpacked", "plugin.yaml"), "id: test.unpacked\nversion: 1.0.0\nstateless: true\n"+
+		"hooks:\n  - id: test.unpacked.hook\n    anchor: test.once\n    func: run\n")
+	mustWrite(t, filepath.Join(dir, "unpacked", "unpacked.wasm"), exportingModule)
+	mustWrite(t, filepath.Join(dir, "bare.yaml"), "id: test.bare\nversion: 1.0.0\nstateless: true\n"+
+		"hooks:\n  - id: test.bare.hook\n    anchor: test.once\n    func: run\n")
+	mustWrite(t, filepath.Join(dir, "bare.wasm"), exportingModule)
+
+	e := newTestEngine(t)
+	e.RegisterHostExtensionPoint("test.once", "Once", "1.0.0", "")
+	if err := e.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// the modules swapped on disk after loading are not what runs
+	for _, file := range []string{filepath.Join(dir, "unpacked", "unpacked.wasm"), filepath.Join(dir, "bare.wasm")} {
+		mustWrite(t, file, "not a module")
+	}
+
+	sum := sha256.Sum256([]byte(exportingModule))
+	for id, hookId := range map[string]string{"test.unpacked": "test.unpacked.hook", "test.bare": "test.bare.hook"} {
+		p := e.plugins[id]["1.0.0"]
+		if nil == p {
+			t.Fatalf("Expected %s to be loaded", id)
+		}
+		if p.ModuleHash != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: expected the module hash of the module as loaded", id)
+		}
+		if _, err := e.CallHookFunc(hookId, nil); err != nil {
+			t.Errorf("%s: expected the module as loaded to run, got %v", id, err)
+		}
+	}
+}
+
+func mustWrite(t *testing.T, path, contents string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
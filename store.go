@@ -0,0 +1,315 @@
+package pluginengine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type (
+	// Store
+	//
+	// The key/value storage behind the KV host functions. Keys live in a namespace, which the engine sets to the id of
+	// the plugin calling, so plugins never see each other's keys. MemoryStore and FileStore are included, hosts can
+	// implement Store over a database to share plugin state between processes.
+	Store interface {
+		// Get returns the value of a key and whether it exists.
+		Get(ctx context.Context, namespace, key string) ([]byte, bool, error)
+		Set(ctx context.Context, namespace, key string, value []byte) error
+		// Delete removes a key, deleting a key that does not exist is not an error.
+		Delete(ctx context.Context, namespace, key string) error
+		// List returns the keys that start with prefix, sorted.
+		List(ctx context.Context, namespace, prefix string) ([]string, error)
+		// Usage returns how much of the store a namespace takes up, used to enforce quotas.
+		Usage(ctx context.Context, namespace string) (StoreUsage, error)
+	}
+
+	// StoreUsage
+	//
+	// The number of keys in a namespace and the bytes of their keys and values.
+	StoreUsage struct {
+		Keys  int   `json:"keys" yaml:"keys"`
+		Bytes int64 `json:"bytes" yaml:"bytes"`
+	}
+
+	// MemoryStore
+	//
+	// A Store held in memory. Plugin state survives the plugin being re-instantiated or reloaded, but not the host
+	// restarting.
+	MemoryStore struct {
+		mu   sync.RWMutex
+		data map[string]map[string][]byte
+	}
+
+	// FileStore
+	//
+	// A Store kept on disk under Dir, a directory per namespace with a file per key. Key file names are base64url
+	// encoded so any key can be stored, and values are written to a temporary file and renamed into place so a crash
+	// never leaves a partially written value. Keys too long to encode in a file name are stored in a file named by their
+	// sha256 instead, which starts with the encoded key on a line of its own.
+	FileStore struct {
+		Dir string
+
+		mu sync.RWMutex
+	}
+)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string]map[string][]byte)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, namespace, key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.data[namespace][key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	return append([]byte(nil), value...), true, nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, namespace, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if nil == s.data[namespace] {
+		s.data[namespace] = make(map[string][]byte)
+	}
+	s.data[namespace][key] = append([]byte(nil), value...)
+
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, namespace, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.data[namespace], key)
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, namespace, prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0)
+	for key := range s.data[namespace] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (s *MemoryStore) Usage(ctx context.Context, namespace string) (StoreUsage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usage := StoreUsage{}
+	for key, value := range s.data[namespace] {
+		usage.Keys++
+		usage.Bytes += int64(len(key) + len(value))
+	}
+
+	return usage, nil
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); nil != err {
+		return nil, err
+	}
+
+	return &FileStore{Dir: dir}, nil
+}
+
+const (
+	// the longest file name most file systems allow
+	maxFileName = 255
+	// starts the file names of hashed keys, it is not in the base64url alphabet
+	hashedKeyPrefix = "~"
+)
+
+// path returns the file a key is stored in, and whether the key is hashed
+func (s *FileStore) path(namespace, key string) (string, bool) {
+	name := base64.RawURLEncoding.EncodeToString([]byte(key))
+	if len(name) <= maxFileName {
+		return filepath.Join(s.namespaceDir(namespace), name), false
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.namespaceDir(namespace), hashedKeyPrefix+hex.EncodeToString(sum[:])), true
+}
+
+// namespaceDir returns the directory of a namespace, encoded like keys so any namespace is a single safe path element
+func (s *FileStore) namespaceDir(namespace string) string {
+	return filepath.Join(s.Dir, base64.RawURLEncoding.EncodeToString([]byte(namespace)))
+}
+
+func (s *FileStore) Get(ctx context.Context, namespace, key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	file, hashed := s.path(namespace, key)
+	value, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if nil != err {
+		return nil, false, err
+	}
+
+	if hashed {
+		// skip the key
+		value = value[bytes.IndexByte(value, '\n')+1:]
+	}
+
+	return value, true, nil
+}
+
+func (s *FileStore) Set(ctx context.Context, namespace, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := s.namespaceDir(namespace)
+	if err := os.MkdirAll(dir, 0755); nil != err {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-")
+	if nil != err {
+		return err
+	}
+
+	file, hashed := s.path(namespace, key)
+	if hashed {
+		_, err = tmp.WriteString(base64.RawURLEncoding.EncodeToString([]byte(key)) + "\n")
+	}
+	if nil == err {
+		_, err = tmp.Write(value)
+	}
+	if closeErr := tmp.Close(); nil == err {
+		err = closeErr
+	}
+	if nil == err {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if nil != err {
+		_ = os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+func (s *FileStore) Delete(ctx context.Context, namespace, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, _ := s.path(namespace, key)
+	err := os.Remove(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *FileStore) List(ctx context.Context, namespace, prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0)
+	err := s.each(namespace, func(key string, size int64) {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	})
+	sort.Strings(keys)
+
+	return keys, err
+}
+
+func (s *FileStore) Usage(ctx context.Context, namespace string) (StoreUsage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usage := StoreUsage{}
+	err := s.each(namespace, func(key string, size int64) {
+		usage.Keys++
+		usage.Bytes += int64(len(key)) + size
+	})
+
+	return usage, err
+}
+
+// each
+//
+// Calls fn with every key in a namespace and the size of its value. The caller must hold the store lock.
+func (s *FileStore) each(namespace string, fn func(key string, size int64)) error {
+	entries, err := os.ReadDir(s.namespaceDir(namespace))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if nil != err {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if nil != err {
+			return err
+		}
+
+		name, size := entry.Name(), info.Size()
+		if strings.HasPrefix(name, hashedKeyPrefix) {
+			line, err := readKeyLine(filepath.Join(s.namespaceDir(namespace), name))
+			if nil != err {
+				return err
+			}
+			name, size = line, size-int64(len(line))-1
+		}
+
+		// skips temporary files, '.' is not in the base64url alphabet
+		key, decodeErr := base64.RawURLEncoding.DecodeString(name)
+		if nil != decodeErr {
+			continue
+		}
+
+		fn(string(key), size)
+	}
+
+	return nil
+}
+
+// readKeyLine returns the encoded key at the start of the file of a hashed key
+func readKeyLine(file string) (string, error) {
+	f, err := os.Open(file)
+	if nil != err {
+		return "", err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if nil != err {
+		return "", err
+	}
+
+	return strings.TrimSuffix(line, "\n"), nil
+}
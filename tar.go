@@ -2,10 +2,16 @@ package pluginengine
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
-	"path/filepath"
+	"path"
+	"sort"
+	"strings"
+	"time"
 )
 
 func Untar(sourceFile, outputPath string) error {
@@ -52,8 +58,11 @@ func Untar(sourceFile, outputPath string) error {
 			return err
 		}
 
-		// Get the individual file name and path
-		fileName := filepath.Join(outputPath, header.Name)
+		// Get the individual file name and path, refusing entries that would end up outside of the output path
+		fileName, err := extractPath(outputPath, header.Name)
+		if err != nil {
+			return err
+		}
 
 		// Handle directories and files differently
 		switch header.Typeflag {
@@ -62,6 +71,8 @@ func Untar(sourceFile, outputPath string) error {
 			if err := os.MkdirAll(fileName, 0755); err != nil {
 				return err
 			}
+		case tar.TypeSymlink, tar.TypeLink:
+			return fmt.Errorf("%w: %s links to %s", ErrUnsafeArchiveEntry, header.Name, header.Linkname)
 		case tar.TypeReg:
 			// Create the file
 			writer, err := os.Create(fileName)
@@ -85,3 +96,148 @@ func Untar(sourceFile, outputPath string) error {
 
 	return nil
 }
+
+// untarFS
+//
+// Reads an uncompressed tar stream into an in memory fs.FS. Only directories and regular files are kept. A stream with
+// more than maxEntries entries or whose files hold more than maxSize bytes in total fails with ErrArchiveTooLarge
+// before they are read, the stream may well be decompressed from something far smaller.
+func untarFS(r io.Reader, maxSize int64, maxEntries int) (fs.FS, error) {
+	mfs := memFS{".": &memFile{name: ".", mode: fs.ModeDir | 0755}}
+	tarReader := tar.NewReader(r)
+
+	entries, size := 0, int64(0)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break // End of archive
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if entries++; entries > maxEntries {
+			return nil, fmt.Errorf("%w: more than %d entries", ErrArchiveTooLarge, maxEntries)
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		if !fs.ValidPath(name) {
+			return nil, &fs.PathError{Op: "untar", Path: header.Name, Err: fs.ErrInvalid}
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			mfs.mkdirAll(name, header.ModTime)
+		case tar.TypeReg:
+			if size += header.Size; size > maxSize {
+				return nil, fmt.Errorf("%w: more than %d bytes", ErrArchiveTooLarge, maxSize)
+			}
+
+			data, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, err
+			}
+
+			mfs.mkdirAll(path.Dir(name), header.ModTime)
+			mfs[name] = &memFile{name: name, data: data, mode: fs.FileMode(header.Mode).Perm(), modTime: header.ModTime}
+		}
+	}
+
+	return mfs, nil
+}
+
+type (
+	// memFS
+	//
+	// A read only fs.FS over files held in memory, keyed by their slash separated path. The root is ".".
+	memFS map[string]*memFile
+
+	memFile struct {
+		name    string
+		data    []byte
+		mode    fs.FileMode
+		modTime time.Time
+	}
+
+	openMemFile struct {
+		*memFile
+		fsys   memFS
+		reader *bytes.Reader
+		dirPos int
+	}
+)
+
+// singleFileFS
+//
+// Returns a memFS holding a single file at its root.
+func singleFileFS(name string, data []byte) memFS {
+	return memFS{
+		".":  &memFile{name: ".", mode: fs.ModeDir | 0755},
+		name: &memFile{name: name, data: data, mode: 0644},
+	}
+}
+
+func (m memFS) mkdirAll(dir string, modTime time.Time) {
+	for ; dir != "." && nil == m[dir]; dir = path.Dir(dir) {
+		m[dir] = &memFile{name: dir, mode: fs.ModeDir | 0755, modTime: modTime}
+	}
+}
+
+func (m memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	f := m[name]
+	if nil == f {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &openMemFile{memFile: f, fsys: m, reader: bytes.NewReader(f.data)}, nil
+}
+
+func (f *memFile) Name() string                   { return path.Base(f.name) }
+func (f *memFile) Size() int64                    { return int64(len(f.data)) }
+func (f *memFile) Mode() fs.FileMode              { return f.mode }
+func (f *memFile) ModTime() time.Time             { return f.modTime }
+func (f *memFile) IsDir() bool                    { return f.mode.IsDir() }
+func (f *memFile) Sys() interface{}               { return nil }
+func (f *openMemFile) Stat() (fs.FileInfo, error) { return f.memFile, nil }
+func (f *openMemFile) Close() error               { return nil }
+
+func (f *openMemFile) Read(b []byte) (int, error) {
+	if f.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	return f.reader.Read(b)
+}
+
+func (f *openMemFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	entries := make([]fs.DirEntry, 0)
+	for name, child := range f.fsys {
+		if name != "." && path.Dir(name) == f.name {
+			entries = append(entries, fs.FileInfoToDirEntry(child))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	entries = entries[f.dirPos:]
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		if len(entries) > n {
+			entries = entries[:n]
+		}
+	}
+
+	f.dirPos += len(entries)
+	return entries, nil
+}
@@ -0,0 +1,244 @@
+package pluginengine
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	observe "github.com/dylibso/observe-sdk/go"
+)
+
+// span attribute keys
+const (
+	AttrPluginId      = "plugin.id"
+	AttrPluginVersion = "plugin.version"
+	AttrHookId        = "hook.id"
+	AttrAnchorId      = "anchor.id"
+	AttrCallerId      = "caller.id"
+	AttrEventName     = "event.name"
+	AttrListener      = "listener"
+	AttrLoadPath      = "load.path"
+)
+
+type (
+	// Tracer
+	//
+	// Creates the spans the engine traces its work with. Start begins a span named name as a child of the span in ctx,
+	// if any, and returns a context holding the new span. The engine passes that context on to the spans it starts
+	// while the span is open, including through the CallHook host function, so a chain of plugin-to-plugin calls is a
+	// single trace. NewOTelTracer adapts an OpenTelemetry tracer. Set one with Engine.SetTracer.
+	Tracer interface {
+		Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+	}
+
+	// Span
+	//
+	// A span started by a Tracer. TraceID returns the id of the span's trace as 32 hex characters, it is handed to the
+	// observe-sdk adapter so function level wasm traces join the trace of the call.
+	Span interface {
+		SetAttributes(attrs ...Attribute)
+		RecordError(err error)
+		TraceID() string
+		End()
+	}
+
+	// Attribute is a key and value tagging a span
+	Attribute struct {
+		Key   string
+		Value string
+	}
+
+	// noopSpan is the span started while tracing is off
+	noopSpan struct{}
+
+	// spanKey is the context key of the span the engine started last, see startSpan
+	spanKey struct{}
+)
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) TraceID() string            { return "" }
+func (noopSpan) End()                       {}
+
+// SetTracer
+//
+// Sets the tracer spans are created with for Load, LoadFS and LoadArchive, precompiling, instantiating and starting
+// plugins, hook calls and event dispatch. Tracing is off until a tracer is set, nil turns it off again.
+func (e *Engine) SetTracer(tracer Tracer) {
+	e.traceMu.Lock()
+	defer e.traceMu.Unlock()
+
+	e.tracer = tracer
+}
+
+// SetObserveAdapter
+//
+// Sets the observe-sdk adapter plugin instances are created with, so calls into them are traced down to the wasm
+// functions they run. The adapter must be started by the host. Instances that already exist are not traced, options
+// may be nil for the observe-sdk defaults.
+func (e *Engine) SetObserveAdapter(adapter *observe.AdapterBase, options *observe.Options) {
+	e.traceMu.Lock()
+	defer e.traceMu.Unlock()
+
+	e.observeAdapter = adapter
+	e.observeOptions = options
+}
+
+// startSpan starts a span with the engine's tracer, or a span that does nothing when tracing is off
+func (e *Engine) startSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	e.traceMu.RLock()
+	tracer := e.tracer
+	e.traceMu.RUnlock()
+
+	if nil == tracer {
+		return ctx, noopSpan{}
+	}
+
+	ctx, span := tracer.Start(ctx, name, attrs...)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// spanOf returns the span the engine started last in ctx, nil when there is none
+func spanOf(ctx context.Context) Span {
+	span, _ := ctx.Value(spanKey{}).(Span)
+	return span
+}
+
+// endSpan records err on the span, if set, and ends it
+func endSpan(span Span, err error) {
+	if nil != err {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// pluginAttrs returns the attributes identifying a plugin version
+func pluginAttrs(p *plugin) []Attribute {
+	return []Attribute{{AttrPluginId, p.Details.Id}, {AttrPluginVersion, p.Details.Version}}
+}
+
+type (
+	// MemoryTracer
+	//
+	// A Tracer keeping the spans it creates in memory, for tests. Spans returns them once they have ended.
+	MemoryTracer struct {
+		mu    sync.Mutex
+		spans []RecordedSpan
+	}
+
+	// RecordedSpan is a span that ended on a MemoryTracer
+	RecordedSpan struct {
+		Name       string
+		TraceID    string
+		SpanID     string
+		ParentID   string
+		Attributes map[string]string
+		Err        error
+		Start      time.Time
+		End        time.Time
+	}
+
+	memorySpan struct {
+		tracer *MemoryTracer
+		mu     sync.Mutex
+		span   RecordedSpan
+		ended  bool
+	}
+
+	// memorySpanKey is the context key of the open memorySpan
+	memorySpanKey struct{}
+)
+
+// NewMemoryTracer returns a MemoryTracer with no spans
+func NewMemoryTracer() *MemoryTracer {
+	return &MemoryTracer{}
+}
+
+func (t *MemoryTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	span := &memorySpan{
+		tracer: t,
+		span: RecordedSpan{
+			Name:       name,
+			SpanID:     randomId(8),
+			Attributes: make(map[string]string, len(attrs)),
+			Start:      time.Now(),
+		},
+	}
+
+	if parent, ok := ctx.Value(memorySpanKey{}).(*memorySpan); ok && parent.tracer == t {
+		span.span.TraceID = parent.span.TraceID
+		span.span.ParentID = parent.span.SpanID
+	} else {
+		span.span.TraceID = randomId(16)
+	}
+	span.SetAttributes(attrs...)
+
+	return context.WithValue(ctx, memorySpanKey{}, span), span
+}
+
+// Spans returns the spans that have ended, in the order they ended
+func (t *MemoryTracer) Spans() []RecordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]RecordedSpan(nil), t.spans...)
+}
+
+// Reset forgets the spans that have ended
+func (t *MemoryTracer) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.spans = nil
+}
+
+func (s *memorySpan) SetAttributes(attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, attr := range attrs {
+		s.span.Attributes[attr.Key] = attr.Value
+	}
+}
+
+func (s *memorySpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.span.Err = err
+}
+
+func (s *memorySpan) TraceID() string {
+	return s.span.TraceID
+}
+
+func (s *memorySpan) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.span.End = time.Now()
+	span := s.span
+	span.Attributes = make(map[string]string, len(s.span.Attributes))
+	for k, v := range s.span.Attributes {
+		span.Attributes[k] = v
+	}
+	s.mu.Unlock()
+
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+
+	s.tracer.spans = append(s.tracer.spans, span)
+}
+
+// randomId returns n random bytes as hex
+func randomId(n int) string {
+	id := make([]byte, n)
+	_, _ = rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
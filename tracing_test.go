@@ -0,0 +1,179 @@
+package pluginengine
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestTracing(t *testing.T) {
+	e := newTestEngine(t)
+	tracer := NewMemoryTracer()
+	e.SetTracer(tracer)
+	e.SetPrecompile(1)
+	e.RegisterHostExtensionPoint("test.toolbar", "Toolbar", "1.0.0", "")
+
+	fsys := fstest.MapFS{
+		"traced/plugin.yaml": {Data: []byte(`
+id: test.traced
+version: 1.0.0
+stateless: true
+hooks:
+  - id: test.traced.hook
+    anchor: test.toolbar
+    func: run
+listeners:
+  - id: test.traced.listener
+    event: test.saved
+    func: run
+`)},
+		"traced/traced.wasm": {Data: []byte(exportingModule)},
+	}
+	if err := e.LoadFS(fsys); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.CallHookFunc("test.traced.hook", nil); err != nil {
+		t.Fatal(err)
+	}
+	e.Publish("test.saved", nil)
+
+	byName := make(map[string][]RecordedSpan)
+	for _, span := range tracer.Spans() {
+		byName[span.Name] = append(byName[span.Name], span)
+	}
+
+	for name, count := range map[string]int{
+		"pluginengine.load": 1, "pluginengine.compile": 1, "pluginengine.instantiate": 1, "pluginengine.call": 1,
+		"pluginengine.event": 1, "pluginengine.listener": 1,
+	} {
+		if len(byName[name]) != count {
+			t.Fatalf("Expected %d %s spans, got %d", count, name, len(byName[name]))
+		}
+	}
+
+	call := byName["pluginengine.call"][0]
+	for key, expected := range map[string]string{
+		AttrPluginId: "test.traced", AttrPluginVersion: "1.0.0", AttrHookId: "test.traced.hook", AttrAnchorId: "test.toolbar",
+	} {
+		if call.Attributes[key] != expected {
+			t.Errorf("Expected the call span's %s to be %q, got %q", key, expected, call.Attributes[key])
+		}
+	}
+
+	if load, compile := byName["pluginengine.load"][0], byName["pluginengine.compile"][0]; compile.ParentID != load.SpanID {
+		t.Error("Expected the compile span to be a child of the load span")
+	}
+
+	// the plugin is instantiated by the first call, within its span
+	if instantiate := byName["pluginengine.instantiate"][0]; instantiate.ParentID != call.SpanID || instantiate.TraceID != call.TraceID {
+		t.Error("Expected the instantiate span to be a child of the call span")
+	}
+
+	event, listener := byName["pluginengine.event"][0], byName["pluginengine.listener"][0]
+	if listener.ParentID != event.SpanID || listener.TraceID != event.TraceID {
+		t.Error("Expected the listener span to be a child of the event span")
+	}
+	if event.Attributes[AttrEventName] != "test.saved" || listener.Attributes[AttrListener] != "test.traced@1.0.0/test.traced.listener" {
+		t.Errorf("Unexpected event and listener attributes: %v, %v", event.Attributes, listener.Attributes)
+	}
+	if event.TraceID == call.TraceID {
+		t.Error("Expected separate host calls to be separate traces")
+	}
+}
+
+// callingModule returns a module exporting a "run" function that calls hookId with the CallHook host function
+func callingModule(hookId string) []byte {
+	size := func(n int) []byte {
+		return []byte{byte(n)&0x7f | 0x80, byte(n >> 7)}
+	}
+	section := func(id byte, contents ...byte) []byte {
+		return append(append([]byte{id}, size(len(contents))...), contents...)
+	}
+	name := func(s string) []byte {
+		return append([]byte{byte(len(s))}, s...)
+	}
+
+	const i64, i32 = 0x7e, 0x7f
+	types := []byte{4,
+		0x60, 0, 0, // run
+		0x60, 2, i64, i64, 1, i64, // CallHook
+		0x60, 1, i64, 1, i64, // alloc
+		0x60, 2, i64, i32, 0, // store_u8
+	}
+	imports := []byte{3}
+	for _, imp := range []struct {
+		module, name string
+		typ          byte
+	}{{"extism:host/pluginengine", "CallHook", 1}, {"extism:host/env", "alloc", 2}, {"extism:host/env", "store_u8", 3}} {
+		imports = append(append(append(imports, name(imp.module)...), name(imp.name)...), 0, imp.typ)
+	}
+
+	// copy the hook id byte by byte into extism memory, then call it
+	body := []byte{1, 1, i64, 0x42, byte(len(hookId)), 0x10, 1, 0x21, 0}
+	for i, c := range []byte(hookId) {
+		body = append(body, 0x20, 0, 0x42, byte(i), 0x7c, 0x41, c&0x7f|0x80, c>>7, 0x10, 2)
+	}
+	body = append(body, 0x20, 0, 0x42, 0, 0x10, 0, 0x1a, 0x0b)
+
+	module := []byte("\x00asm\x01\x00\x00\x00")
+	module = append(module, section(1, types...)...)
+	module = append(module, section(2, imports...)...)
+	module = append(module, section(3, 1, 0)...)
+	module = append(module, section(7, append(append([]byte{1}, name("run")...), 0, 3)...)...)
+	module = append(module, section(10, append(append([]byte{1}, size(len(body))...), body...)...)...)
+
+	return module
+}
+
+func TestTracingCallChain(t *testing.T) {
+	e := newTestEngine(t)
+	tracer := NewMemoryTracer()
+	e.SetTracer(tracer)
+	e.RegisterHostExtensionPoint("test.toolbar", "Toolbar", "1.0.0", "")
+
+	fsys := fstest.MapFS{
+		"outer/plugin.yaml": {Data: []byte("id: test.outer\nversion: 1.0.0\nstateless: true\nloadOnStart: true\n" +
+			"hooks:\n  - id: test.outer.hook\n    anchor: test.toolbar\n    func: run\n")},
+		"outer/outer.wasm": {Data: callingModule("test.inner.hook")},
+		"inner/plugin.yaml": {Data: []byte("id: test.inner\nversion: 1.0.0\nstateless: true\n" +
+			"hooks:\n  - id: test.inner.hook\n    anchor: test.toolbar\n    func: run\n")},
+		"inner/inner.wasm": {Data: []byte(exportingModule)},
+	}
+	if err := e.LoadFS(fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	tracer.Reset()
+	if err := e.Start(); err != nil {
+		t.Fatal(err)
+	}
+	spans := tracer.Spans()
+	if len(spans) != 2 || spans[0].Name != "pluginengine.instantiate" || spans[1].Name != "pluginengine.start" {
+		t.Fatalf("Expected an instantiate span within a start span, got %+v", spans)
+	}
+	if spans[0].ParentID != spans[1].SpanID || spans[0].Attributes[AttrPluginId] != "test.outer" {
+		t.Error("Expected the loadOnStart plugin to be instantiated within the start span")
+	}
+
+	tracer.Reset()
+	if _, err := e.CallHookFunc("test.outer.hook", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := make(map[string]RecordedSpan)
+	for _, span := range tracer.Spans() {
+		if span.Name == "pluginengine.call" {
+			calls[span.Attributes[AttrHookId]] = span
+		}
+	}
+
+	outer, inner := calls["test.outer.hook"], calls["test.inner.hook"]
+	if outer.SpanID == "" || inner.SpanID == "" {
+		t.Fatalf("Expected a call span for both hooks, got %+v", tracer.Spans())
+	}
+	if inner.TraceID != outer.TraceID || inner.ParentID != outer.SpanID || outer.ParentID != "" {
+		t.Error("Expected the nested call to be a child of the outer call in a single trace")
+	}
+	if inner.Attributes[AttrCallerId] != "test.outer" {
+		t.Errorf("Expected the nested call's caller to be test.outer, got %q", inner.Attributes[AttrCallerId])
+	}
+}
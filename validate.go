@@ -0,0 +1,184 @@
+package pluginengine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// ValidationResult
+//
+// The problems found with one plugin, or with a plugin source that could not be read at all, in which case Id and
+// Version are empty.
+type ValidationResult struct {
+	Source   string   `json:"source" yaml:"source"`
+	Id       string   `json:"id,omitempty" yaml:"id,omitempty"`
+	Version  string   `json:"version,omitempty" yaml:"version,omitempty"`
+	Problems []string `json:"problems" yaml:"problems"`
+}
+
+// Valid
+//
+// Returns true when no problems were found.
+func (r ValidationResult) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+// Validate
+//
+// This function checks the plugins found at path, which is anything Load accepts, without loading them into an engine
+// or extracting archives to disk. The manifest of each plugin is strictly parsed (see ParseManifest) and checked for
+// duplicate ids, and its module is compiled to check it is valid wasm and exports every function its hooks and
+// listeners name. A source whose manifest does not parse gets a single result with the parse error and no id. An error
+// is only returned when path itself can not be searched for plugins.
+func Validate(path string) ([]ValidationResult, error) {
+	sources, err := findPluginSources(path)
+	if nil != err {
+		return nil, err
+	}
+
+	results := make([]ValidationResult, 0)
+	for _, src := range sources {
+		plugs, err := readSource(src)
+		if nil != err {
+			results = append(results, ValidationResult{Source: src.Path, Problems: []string{err.Error()}})
+			continue
+		}
+
+		for _, plug := range plugs {
+			results = append(results, ValidationResult{
+				Source:   src.Path,
+				Id:       plug.Details.Id,
+				Version:  plug.Details.Version,
+				Problems: validatePlugin(plug),
+			})
+		}
+	}
+
+	return results, nil
+}
+
+// readSource
+//
+// Reads the plugins of a source with their module bytes, without an engine and without extracting archives.
+func readSource(src pluginSource) ([]*plugin, error) {
+	switch src.Kind {
+	case sourceDirectory:
+		return loadPluginsFS(os.DirFS(src.Path))
+	case sourceWasm:
+		plugs, _, err := loadWasm(src.Path)
+		return plugs, err
+	default:
+		f, err := os.Open(src.Path)
+		if nil != err {
+			return nil, err
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if nil != err {
+			return nil, err
+		}
+
+		fsys, err := archiveFS(f, info.Size())
+		if nil != err {
+			return nil, err
+		}
+
+		return loadPluginsFS(fsys)
+	}
+}
+
+// validatePlugin
+//
+// Returns the problems found with a plugin whose manifest has already been parsed, and so passed the checks in
+// ParseManifest: duplicate ids, modules that are not valid wasm and functions its main module does not export.
+func validatePlugin(p *plugin) []string {
+	problems := make([]string, 0)
+	details := p.Details
+
+	seen := make(map[string]bool)
+	checkDuplicate := func(kind, id string) {
+		if seen[kind+":"+id] {
+			problems = append(problems, fmt.Sprintf("%s %s is declared more than once", kind, id))
+		}
+		seen[kind+":"+id] = true
+	}
+
+	required := make(map[string]string)
+	for _, anchor := range details.Anchors {
+		checkDuplicate("anchor", anchor.Id)
+	}
+	for _, hook := range details.Hooks {
+		checkDuplicate("hook", hook.Id)
+		required[hook.Func] = "hook " + hook.Id
+	}
+	for _, l := range details.Listeners {
+		name := l.Id
+		if name == "" {
+			name = l.Func
+		}
+		required[l.Func] = "listener " + name
+	}
+	for _, s := range details.Schedules {
+		required[s.Func] = "schedule " + s.Id
+	}
+
+	for _, m := range p.Linked {
+		if _, err := moduleExports(m.Data, m.Path); nil != err {
+			problems = append(problems, fmt.Sprintf("module %s: %v", m.Name, err))
+		}
+	}
+
+	exports, err := moduleExports(p.ModuleData, p.PathToModule)
+	if nil != err {
+		return append(problems, fmt.Sprintf("module %s: %v", filepath.Base(p.PathToModule), err))
+	}
+
+	funcs := make([]string, 0, len(required))
+	for fn := range required {
+		funcs = append(funcs, fn)
+	}
+	sort.Strings(funcs)
+
+	for _, fn := range funcs {
+		if !exports[fn] {
+			problems = append(problems, fmt.Sprintf("%s calls %s, which the module does not export", required[fn], fn))
+		}
+	}
+
+	return problems
+}
+
+// moduleExports
+//
+// Compiles a module, without instantiating it, and returns the names of the functions it exports. The module is read
+// from path when data is nil.
+func moduleExports(data []byte, path string) (map[string]bool, error) {
+	if nil == data {
+		var err error
+		if data, err = os.ReadFile(path); nil != err {
+			return nil, err
+		}
+	}
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfigInterpreter())
+	defer runtime.Close(ctx)
+
+	compiled, err := runtime.CompileModule(ctx, data)
+	if nil != err {
+		return nil, err
+	}
+
+	exports := make(map[string]bool)
+	for name := range compiled.ExportedFunctions() {
+		exports[name] = true
+	}
+
+	return exports, nil
+}
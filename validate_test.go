@@ -0,0 +1,79 @@
+package pluginengine
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a minimal wasm module exporting a single function named run
+const exportingModule = "\x00asm\x01\x00\x00\x00" +
+	"\x01\x04\x01\x60\x00\x00" +
+	"\x03\x02\x01\x00" +
+	"\x07\x07\x01\x03run\x00\x00" +
+	"\x0a\x04\x01\x02\x00\x0b"
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+
+	mustWrite(t, filepath.Join(dir, "good", "plugin.yaml"), `
+id: test.good
+version: 1.0.0
+hooks:
+  - id: test.good.hook
+    anchor: test.menu.items
+    func: run
+`)
+	mustWrite(t, filepath.Join(dir, "good", "good.wasm"), exportingModule)
+
+	mustWrite(t, filepath.Join(dir, "bad", "plugin.yaml"), `
+id: test.bad
+version: 1.0.0
+hooks:
+  - id: test.bad.hook
+    anchor: test.menu.items
+    func: run
+  - id: test.bad.hook
+    anchor: test.menu.items
+    func: run
+listeners:
+  - id: test.bad.listener
+    event: test.saved
+    func: onSaved
+`)
+	mustWrite(t, filepath.Join(dir, "bad", "bad.wasm"), exportingModule)
+
+	mustWrite(t, filepath.Join(dir, "broken", "plugin.yaml"), "id: test.broken\n")
+	mustWrite(t, filepath.Join(dir, "broken", "broken.wasm"), exportingModule)
+
+	results, err := Validate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %+v", results)
+	}
+
+	for _, result := range results {
+		switch result.Id {
+		case "test.good":
+			if !result.Valid() {
+				t.Errorf("Expected test.good to be valid, got %v", result.Problems)
+			}
+		case "test.bad":
+			problems := strings.Join(result.Problems, "\n")
+			for _, expected := range []string{"hook test.bad.hook is declared more than once", "listener test.bad.listener calls onSaved"} {
+				if !strings.Contains(problems, expected) {
+					t.Errorf("Expected a problem containing %q, got %v", expected, result.Problems)
+				}
+			}
+		case "":
+			if filepath.Base(result.Source) != "broken" || len(result.Problems) != 1 || !strings.Contains(result.Problems[0], "version: is required") {
+				t.Errorf("Expected the broken manifest to be reported, got %+v", result)
+			}
+		default:
+			t.Errorf("Unexpected result %+v", result)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package pluginengine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+type (
+	// archiveRecord
+	//
+	// An archive loaded from one of the engine's load paths, with the hash of its contents when it was loaded and the
+	// plugins it contributed.
+	archiveRecord struct {
+		Hash    string
+		Plugins []*plugin
+	}
+
+	// WatchOptions
+	//
+	// Interval is how often the load paths are scanned for archives, and Debounce is how long an added, modified or
+	// removed archive has to stay that way before the engine acts on it, so an archive that is still being written is
+	// not loaded half way through. Zero values fall back to a 2 second interval and a 500 millisecond debounce.
+	WatchOptions struct {
+		Interval time.Duration
+		Debounce time.Duration
+	}
+
+	// ArchiveEvent
+	//
+	// The JSON encoded payload of the archive events the watcher publishes on the engine event bus. Plugins holds the
+	// id@version of every plugin the archive now provides (or provided, for a removal).
+	ArchiveEvent struct {
+		Path    string   `json:"path"`
+		Hash    string   `json:"hash,omitempty"`
+		Plugins []string `json:"plugins,omitempty"`
+		Error   string   `json:"error,omitempty"`
+	}
+
+	watcher struct {
+		opts    WatchOptions
+		stop    chan struct{}
+		done    chan struct{}
+		pending map[string]*pendingChange
+	}
+
+	// pendingChange
+	//
+	// A change seen by the watcher that has not yet been stable for the debounce period. An empty hash means the
+	// archive was removed.
+	pendingChange struct {
+		hash  string
+		since time.Time
+	}
+)
+
+const (
+	EventArchiveAdded    = "pluginengine.archive.added"
+	EventArchiveModified = "pluginengine.archive.modified"
+	EventArchiveRemoved  = "pluginengine.archive.removed"
+
+	defaultWatchInterval = 2 * time.Second
+	defaultWatchDebounce = 500 * time.Millisecond
+)
+
+// hashFile
+//
+// Returns the hex encoded sha256 of the file contents.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// addLoadPath
+//
+// Remembers a directory passed to Load so the watcher scans it. The caller must hold the engine lock.
+func (e *Engine) addLoadPath(path string) {
+	for _, p := range e.loadPaths {
+		if p == path {
+			return
+		}
+	}
+
+	e.loadPaths = append(e.loadPaths, path)
+}
+
+// recordArchive
+//
+// Remembers the plugins loaded from an archive along with the archive hash. The caller must hold the engine lock.
+func (e *Engine) recordArchive(file string, plugs []*plugin) {
+	rec := &archiveRecord{Plugins: plugs}
+	if len(plugs) > 0 {
+		rec.Hash = plugs[0].SourceHash
+	} else if hash, err := hashFile(file); nil == err {
+		rec.Hash = hash
+	}
+
+	e.archives[file] = rec
+}
+
+// Events
+//
+// Returns the engine event bus. The host can register listeners on it to be told about engine events, such as the
+// EventArchiveAdded, EventArchiveModified and EventArchiveRemoved events published by the watcher.
+func (e *Engine) Events() *EventBus {
+	return e.events
+}
+
+// emit
+//
+// Publishes an engine event with a JSON encoded payload on the event bus. Listeners run asynchronously and their
+// responses are ignored.
+func (e *Engine) emit(name string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if nil != err {
+		logln("Error marshalling event payload: ", name, err)
+		return
+	}
+
+	e.events.DispatchEvent(Event{Name: name, Payload: data}, func(response []byte, err error) {})
+}
+
+// Watch
+//
+// This method starts watching the directories passed to Load for plugin archives that are added, modified or removed.
+// The directories are polled, and each archive is compared by the hash of its contents so touching a file without
+// changing it does nothing. Once a change has been stable for the debounce period an added archive is loaded, a
+// modified archive has its plugins reloaded (plugins no longer in the archive are unloaded) and a removed archive has
+// its plugins unloaded. Each change is published on the engine event bus.
+func (e *Engine) Watch(opts WatchOptions) error {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultWatchInterval
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = defaultWatchDebounce
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if nil != e.watcher {
+		return errors.New("the engine is already watching its load paths")
+	}
+
+	w := &watcher{
+		opts:    opts,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		pending: make(map[string]*pendingChange),
+	}
+	e.watcher = w
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.stop:
+				return
+			case now := <-ticker.C:
+				e.scanArchives(w, now)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// StopWatching
+//
+// This method stops the watcher started by Watch and waits for a scan in progress to finish.
+func (e *Engine) StopWatching() {
+	e.mu.Lock()
+	w := e.watcher
+	e.watcher = nil
+	e.mu.Unlock()
+
+	if nil != w {
+		close(w.stop)
+		<-w.done
+	}
+}
+
+// scanArchives
+//
+// A single pass of the watcher. It compares the archives currently in the load paths with the ones the engine has
+// loaded, tracks the differences as pending changes and applies those that have been stable for the debounce period.
+func (e *Engine) scanArchives(w *watcher, now time.Time) {
+	e.mu.RLock()
+	paths := append([]string(nil), e.loadPaths...)
+	known := make(map[string]string, len(e.archives))
+	for file, rec := range e.archives {
+		known[file] = rec.Hash
+	}
+	e.mu.RUnlock()
+
+	current := make(map[string]string)
+	for _, dir := range paths {
+		files, err := findFilesWithExtensions(dir, pluginArchiveExtensions)
+		if nil != err {
+			// a directory that can not be read right now would look like every archive in it was removed
+			logln("Error scanning plugin directory, skipping this scan: ", dir, err)
+			return
+		}
+
+		for _, file := range files {
+			hash, err := hashFile(file)
+			if nil != err {
+				// most likely still being written, leave it as it was
+				if h, ok := known[file]; ok {
+					current[file] = h
+				}
+				continue
+			}
+			current[file] = hash
+		}
+	}
+
+	changes := make(map[string]string)
+	for file, hash := range current {
+		if h, ok := known[file]; !ok || h != hash {
+			changes[file] = hash
+		}
+	}
+	for file := range known {
+		if _, ok := current[file]; !ok {
+			changes[file] = ""
+		}
+	}
+
+	for file := range w.pending {
+		if _, ok := changes[file]; !ok {
+			delete(w.pending, file)
+		}
+	}
+
+	for file, hash := range changes {
+		pc := w.pending[file]
+		if nil == pc || pc.hash != hash {
+			w.pending[file] = &pendingChange{hash: hash, since: now}
+			continue
+		}
+
+		if now.Sub(pc.since) >= w.opts.Debounce {
+			delete(w.pending, file)
+			e.applyArchiveChange(file, hash)
+		}
+	}
+}
+
+// applyArchiveChange
+//
+// Loads, reloads or unloads the plugins of a single archive. An empty hash means the archive was removed. A plugin whose
+// new version fails to swap in, such as one that fails to instantiate, keeps its loaded version, which stays recorded
+// for the archive, and the failure is reported in the event.
+func (e *Engine) applyArchiveChange(file, hash string) {
+	e.mu.RLock()
+	rec := e.archives[file]
+	e.mu.RUnlock()
+
+	if hash == "" {
+		if nil == rec {
+			return
+		}
+
+		for _, p := range rec.Plugins {
+			if err := e.unloadPlugin(p); nil != err {
+				logln("Error unloading plugin from removed archive: ", file, err)
+			}
+		}
+
+		e.mu.Lock()
+		delete(e.archives, file)
+		e.mu.Unlock()
+
+		e.emit(EventArchiveRemoved, ArchiveEvent{Path: file, Plugins: pluginIds(rec.Plugins)})
+		return
+	}
+
+	name := EventArchiveAdded
+	if nil != rec {
+		name = EventArchiveModified
+	}
+
+	plugs, err := e.loadArchive(file)
+	if nil != err {
+		logln("Error loading changed plugin archive: ", file, err)
+
+		// remember the hash so a broken archive is not retried until it changes again, keeping whatever it provided
+		e.mu.Lock()
+		if nil == rec {
+			e.archives[file] = &archiveRecord{Hash: hash}
+		} else {
+			rec.Hash = hash
+		}
+		e.mu.Unlock()
+
+		e.emit(name, ArchiveEvent{Path: file, Hash: hash, Error: err.Error()})
+		return
+	}
+	defer e.settle(plugs)
+
+	var previous []*plugin
+	if nil != rec {
+		previous = rec.Plugins
+	}
+
+	// the plugins the archive provides once the change is applied
+	provided := make([]*plugin, 0, len(plugs))
+	failures := make([]string, 0)
+
+	replaced := make(map[*plugin]bool)
+	for _, np := range plugs {
+		var old *plugin
+		for _, op := range previous {
+			if op.Details.Id == np.Details.Id && !replaced[op] {
+				old = op
+				break
+			}
+		}
+
+		if nil != old {
+			replaced[old] = true
+			if err := e.swapPlugin(old, np); nil != err {
+				logln("Error reloading plugin, keeping the loaded version: ", np.Details.Id, err)
+				failures = append(failures, fmt.Sprintf("%s@%s: %v", np.Details.Id, np.Details.Version, err))
+				provided = append(provided, old)
+				continue
+			}
+		} else {
+			e.mu.Lock()
+			e.addPlugin(np, np.Details)
+			e.mu.Unlock()
+		}
+
+		provided = append(provided, np)
+	}
+
+	for _, op := range previous {
+		if !replaced[op] {
+			if err := e.unloadPlugin(op); nil != err {
+				logln("Error unloading plugin no longer in archive: ", op.Details.Id, err)
+			}
+		}
+	}
+
+	// the hash of the archive as it is now, so a swap that failed is not retried until the archive changes again
+	e.mu.Lock()
+	e.archives[file] = &archiveRecord{Hash: hash, Plugins: provided}
+	e.mu.Unlock()
+
+	e.emit(name, ArchiveEvent{Path: file, Hash: hash, Plugins: pluginIds(provided), Error: strings.Join(failures, "; ")})
+}
+
+func pluginIds(plugs []*plugin) []string {
+	ids := make([]string, 0, len(plugs))
+	for _, p := range plugs {
+		ids = append(ids, p.Details.Id+"@"+p.Details.Version)
+	}
+
+	return ids
+}
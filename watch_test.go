@@ -0,0 +1,167 @@
+package pluginengine
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeTestPluginZip(t *testing.T, path, manifest string) {
+	writeTestZip(t, path, map[string]string{"plugin.yaml": manifest, "plugin.wasm": "\x00asm"})
+}
+
+func writeTestZip(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, contents := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanArchives(t *testing.T) {
+	e := newTestEngine(t)
+	dir := t.TempDir()
+	e.addLoadPath(dir)
+
+	var mu sync.Mutex
+	received := make(map[string]int)
+	for _, name := range []string{EventArchiveAdded, EventArchiveModified, EventArchiveRemoved} {
+		e.Events().RegisterListener(name, func(event Event, callback func(response []byte, err error)) {
+			mu.Lock()
+			received[event.Name]++
+			mu.Unlock()
+		})
+	}
+
+	w := &watcher{opts: WatchOptions{Debounce: time.Second}, pending: make(map[string]*pendingChange)}
+	now := time.Now()
+	archive := filepath.Join(dir, "watched.zip")
+
+	writeTestPluginZip(t, archive, "id: test.watch\nversion: 1.0.0\n")
+	e.scanArchives(w, now)
+	if nil != e.plugins["test.watch"] {
+		t.Fatal("Expected the added archive to wait for the debounce period")
+	}
+
+	e.scanArchives(w, now.Add(time.Second))
+	if nil == e.plugins["test.watch"]["1.0.0"] {
+		t.Fatal("Expected the added archive to be loaded")
+	}
+
+	writeTestPluginZip(t, archive, "id: test.watch\nversion: 1.0.1\n")
+	e.scanArchives(w, now.Add(2*time.Second))
+	e.scanArchives(w, now.Add(3*time.Second))
+	if nil != e.plugins["test.watch"]["1.0.0"] || nil == e.plugins["test.watch"]["1.0.1"] {
+		t.Fatal("Expected the modified archive to replace version 1.0.0 with 1.0.1")
+	}
+
+	if err := os.Remove(archive); err != nil {
+		t.Fatal(err)
+	}
+	e.scanArchives(w, now.Add(4*time.Second))
+	e.scanArchives(w, now.Add(5*time.Second))
+	if nil != e.plugins["test.watch"] {
+		t.Fatal("Expected the removed archive's plugin to be unloaded")
+	}
+
+	// listeners run asynchronously
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		done := received[EventArchiveAdded] == 1 && received[EventArchiveModified] == 1 && received[EventArchiveRemoved] == 1
+		mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Errorf("Expected one added, modified and removed event, got %v", received)
+}
+
+func TestScanArchivesFailedSwap(t *testing.T) {
+	e := newTestEngine(t)
+	dir := t.TempDir()
+	e.addLoadPath(dir)
+	e.RegisterHostExtensionPoint("test.swap.anchor", "Swap", "1.0.0", "")
+
+	events := make(chan ArchiveEvent, 4)
+	e.Events().RegisterListener(EventArchiveModified, func(event Event, callback func(response []byte, err error)) {
+		var payload ArchiveEvent
+		_ = json.Unmarshal(event.Payload, &payload)
+		events <- payload
+	})
+
+	w := &watcher{opts: WatchOptions{}, pending: make(map[string]*pendingChange)}
+	now := time.Now()
+	archive := filepath.Join(dir, "swap.zip")
+	manifest := "id: test.swap\nversion: %s\nstateless: true\n" +
+		"hooks:\n  - id: test.swap.run\n    anchor: test.swap.anchor\n    func: run\n"
+
+	writeTestZip(t, archive, map[string]string{"plugin.yaml": fmt.Sprintf(manifest, "1.0.0"), "plugin.wasm": exportingModule})
+	e.scanArchives(w, now)
+	e.scanArchives(w, now)
+	old := e.plugins["test.swap"]["1.0.0"]
+	if nil == old {
+		t.Fatal("Expected the archive to be loaded")
+	}
+	if _, err := e.CallHookFunc("test.swap.run", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rebuilt module does not instantiate, so the loaded version stays
+	writeTestZip(t, archive, map[string]string{"plugin.yaml": fmt.Sprintf(manifest, "1.0.1"), "plugin.wasm": "\x00asm"})
+	e.scanArchives(w, now)
+	e.scanArchives(w, now)
+
+	if e.plugins["test.swap"]["1.0.0"] != old || nil != e.plugins["test.swap"]["1.0.1"] {
+		t.Fatal("Expected version 1.0.0 to stay loaded in place of the broken 1.0.1")
+	}
+	if rec := e.archives[archive]; nil == rec || len(rec.Plugins) != 1 || rec.Plugins[0] != old {
+		t.Fatal("Expected the archive to still provide version 1.0.0")
+	}
+	if _, err := e.CallHookFunc("test.swap.run", nil); err != nil {
+		t.Errorf("Expected version 1.0.0 to keep taking calls, got %v", err)
+	}
+
+	select {
+	case event := <-events:
+		if event.Error == "" || len(event.Plugins) != 1 || event.Plugins[0] != "test.swap@1.0.0" {
+			t.Errorf("Expected the event to report the failed swap and the version kept, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a modified event")
+	}
+
+	// removing the archive still unloads the version it provides
+	if err := os.Remove(archive); err != nil {
+		t.Fatal(err)
+	}
+	e.scanArchives(w, now)
+	e.scanArchives(w, now)
+	if nil != e.plugins["test.swap"] || old.instances() != 0 {
+		t.Error("Expected the removed archive's plugin to be unloaded and stopped")
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
 )
 
 func Unzip(sourceFile, outputPath string) error {
@@ -18,7 +17,7 @@ func Unzip(sourceFile, outputPath string) error {
 	defer func(reader *zip.ReadCloser) {
 		err := reader.Close()
 		if err != nil {
-			fmt.Println("Error in defer close of zip")
+			logln("Error in defer close of zip")
 		}
 	}(reader)
 
@@ -30,8 +29,14 @@ func Unzip(sourceFile, outputPath string) error {
 
 	// Iterate through the files in the archive
 	for _, file := range reader.File {
-		// Get the individual file path
-		filePath := filepath.Join(outputPath, file.Name)
+		// Get the individual file path, refusing entries that would end up outside of the output path
+		filePath, err := extractPath(outputPath, file.Name)
+		if err != nil {
+			return err
+		}
+		if file.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is a symbolic link", ErrUnsafeArchiveEntry, file.Name)
+		}
 
 		// Check for directories
 		if file.FileInfo().IsDir() {